@@ -10,9 +10,11 @@ import (
 	"syscall"
 	"time"
 
+	"cryptobot-api-gateway/internal/auth"
 	"cryptobot-api-gateway/internal/config"
 	"cryptobot-api-gateway/internal/gateway"
 	"cryptobot-api-gateway/internal/messaging"
+	"cryptobot-api-gateway/internal/offline"
 	"cryptobot-api-gateway/internal/websocket"
 
 	"github.com/sirupsen/logrus"
@@ -49,11 +51,185 @@ func main() {
 	}
 
 	// Initialize WebSocket hub
-	wsHub := websocket.NewHub(logger)
+	wsHub := websocket.NewHub(cfg.APIGatewayConfig.WebSocket, logger)
 	go wsHub.Run()
 
+	// Load gateway users
+	usersCfg := cfg.APIGatewayConfig.Users
+	userStore, err := auth.NewFileUserStore(usersCfg.File, time.Duration(usersCfg.ReloadSeconds)*time.Second, logger)
+	if err != nil {
+		logger.Fatalf("Failed to load users: %v", err)
+	}
+	defer userStore.Close()
+
+	// Track revoked tokens, shared between replicas when the broker is available
+	var revocations auth.RevocationList = auth.NewMemoryRevocationList()
+	if revocationCfg := cfg.APIGatewayConfig.Revocation; revocationCfg.Backend == auth.RevocationShared {
+		if messageClient != nil {
+			revocations = auth.NewSharedRevocationList(cfg.APIGatewayConfig.WebSocket.Presence.ReplicaID, func(message auth.RevocationMessage) error {
+				return messageClient.PublishToTopic(revocationCfg.Topic, message)
+			})
+		} else {
+			logger.Warn("Message broker unavailable, token revocations are local to this replica")
+		}
+	}
+
+	// Share login state between replicas when the broker is available
+	var replication *auth.Replication
+	if replicationCfg := cfg.APIGatewayConfig.Replication; replicationCfg.Backend == auth.ReplicationShared {
+		if messageClient != nil {
+			replication = auth.NewReplication(cfg.APIGatewayConfig.WebSocket.Presence.ReplicaID, func(message auth.ReplicationMessage) error {
+				return messageClient.PublishToTopic(replicationCfg.Topic, message)
+			})
+		} else {
+			logger.Warn("Message broker unavailable, login state is local to this replica")
+		}
+	}
+
+	// Issue rotating refresh tokens for login sessions
+	var refreshStore auth.RefreshStore = auth.NewMemoryRefreshStore()
+	if replication != nil {
+		refreshStore = auth.NewSharedRefreshStore(replication)
+	}
+	tokenCfg := cfg.APIGatewayConfig.Tokens
+	refreshTokens := auth.NewRefreshTokens(refreshStore,
+		time.Duration(tokenCfg.RefreshTokenHours)*time.Hour,
+		time.Duration(tokenCfg.SessionMaxHours)*time.Hour)
+	var sessionStore auth.SessionStore = auth.NewMemorySessionStore()
+	if replication != nil {
+		sessionStore = auth.NewSharedSessionStore(replication)
+	}
+	sessions := auth.NewSessions(sessionStore)
+
+	// Load token signing keys, falling back to the shared HMAC secret
+	keys := auth.NewHMACKeySet([]byte(cfg.APIGatewayConfig.JWTSecretKey))
+	if signingCfg := cfg.APIGatewayConfig.Signing; len(signingCfg.Keys) > 0 {
+		files := make([]auth.KeyFile, len(signingCfg.Keys))
+		for i, key := range signingCfg.Keys {
+			files[i] = auth.KeyFile{ID: key.ID, Path: key.File, RetiresAt: key.RetiresAt}
+		}
+		if keys, err = auth.LoadKeySet(signingCfg.ActiveKeyID, files); err != nil {
+			logger.Fatalf("Failed to load signing keys: %v", err)
+		}
+	} else {
+		logger.Warn("No signing keys configured, signing tokens with HS256")
+	}
+
+	// Open the API key store
+	apiKeysCfg := cfg.APIGatewayConfig.APIKeys
+	fileAPIKeyStore, err := auth.NewFileAPIKeyStore(apiKeysCfg.File)
+	if err != nil {
+		logger.Fatalf("Failed to open API key store: %v", err)
+	}
+	var apiKeyStore auth.APIKeyStore = fileAPIKeyStore
+	if replication != nil {
+		apiKeyStore = auth.NewSharedAPIKeyStore(fileAPIKeyStore, replication)
+	}
+	if apiKeysCfg.SigningSecret == "" {
+		logger.Warn("No API key signing secret configured, signed requests are disabled")
+	}
+	var nonces auth.NonceCache = auth.NewMemoryNonceCache()
+	if replication != nil {
+		nonces = auth.NewSharedNonceCache(replication)
+	}
+	apiKeys := auth.NewAPIKeys(apiKeyStore, []byte(apiKeysCfg.SigningSecret),
+		time.Duration(apiKeysCfg.SignatureSkewSeconds)*time.Second, nonces)
+
+	// Sign in through the company identity provider when configured
+	var oidcProvider *auth.OIDCProvider
+	if oidcCfg := cfg.APIGatewayConfig.OIDC; oidcCfg.Enabled {
+		if oidcCfg.StateSecret == "" {
+			logger.Fatal("OIDC login requires oidc.stateSecret or a JWT secret to sign pending logins")
+		}
+		oidcProvider = auth.NewOIDCProvider(auth.OIDCClientConfig{
+			IssuerURL:    oidcCfg.IssuerURL,
+			ClientID:     oidcCfg.ClientID,
+			ClientSecret: oidcCfg.ClientSecret,
+			RedirectURL:  oidcCfg.RedirectURL,
+			Scopes:       oidcCfg.Scopes,
+		})
+	}
+
+	// Open the TOTP enrollment store
+	mfaCfg := cfg.APIGatewayConfig.MFA
+	fileMFAStore, err := auth.NewFileMFAStore(mfaCfg.File)
+	if err != nil {
+		logger.Fatalf("Failed to open MFA store: %v", err)
+	}
+	var mfaStore auth.MFAStore = fileMFAStore
+	if replication != nil {
+		mfaStore = auth.NewSharedMFAStore(fileMFAStore, replication)
+	}
+	mfa := auth.NewMFA(mfaStore, mfaCfg.Issuer)
+
+	// Throttle password guessing per username and client address
+	loginCfg := cfg.APIGatewayConfig.LoginProtection
+	var loginAttempts auth.LoginAttemptStore = auth.NewMemoryLoginAttemptStore()
+	if replication != nil {
+		loginAttempts = auth.NewSharedLoginAttemptStore(replication)
+	}
+	loginThrottle := auth.NewLoginThrottle(loginAttempts, auth.LoginThrottleConfig{
+		Window:    time.Duration(loginCfg.WindowMinutes) * time.Minute,
+		BaseDelay: time.Duration(loginCfg.BaseDelayMs) * time.Millisecond,
+		MaxDelay:  time.Duration(loginCfg.MaxDelayMs) * time.Millisecond,
+		Username: auth.LockoutPolicy{
+			MaxFailures:     loginCfg.Username.MaxFailures,
+			LockoutDuration: time.Duration(loginCfg.Username.LockoutMinutes) * time.Minute,
+		},
+		IP: auth.LockoutPolicy{
+			MaxFailures:     loginCfg.IP.MaxFailures,
+			LockoutDuration: time.Duration(loginCfg.IP.LockoutMinutes) * time.Minute,
+		},
+	})
+
+	// Open the bot ownership store
+	fileBotStore, err := auth.NewFileBotStore(cfg.APIGatewayConfig.Bots.File)
+	if err != nil {
+		logger.Fatalf("Failed to open bot ownership store: %v", err)
+	}
+	var botStore auth.BotStore = fileBotStore
+	if replication != nil {
+		botStore = auth.NewSharedBotStore(fileBotStore, replication)
+	}
+	bots := auth.NewBotOwners(botStore)
+
+	// Initialize the offline event queue
+	var offlineStore offline.Store
+	if queueCfg := cfg.APIGatewayConfig.OfflineQueue; queueCfg.Enabled {
+		ttl := time.Duration(queueCfg.TTLHours) * time.Hour
+		fileStore, err := offline.NewFileStore(queueCfg.Path, queueCfg.MaxPerUser, ttl)
+		if err != nil {
+			logger.Fatalf("Failed to open offline event store: %v", err)
+		}
+		offlineStore = fileStore
+		if replication != nil {
+			offlineStore = auth.NewSharedOfflineStore(fileStore, ttl, replication)
+		}
+	}
+
 	// Initialize gateway with all dependencies
-	gatewayServer := gateway.NewGateway(cfg, messageClient, wsHub, logger)
+	gatewayServer := gateway.NewGateway(cfg, gateway.Deps{
+		MessageClient: messageClient,
+		WSHub:         wsHub,
+		OfflineStore:  offlineStore,
+		UserStore:     userStore,
+		Revocations:   revocations,
+		Replication:   replication,
+		RefreshTokens: refreshTokens,
+		Sessions:      sessions,
+		Keys:          keys,
+		APIKeys:       apiKeys,
+		OIDC:          oidcProvider,
+		MFA:           mfa,
+		Bots:          bots,
+		LoginThrottle: loginThrottle,
+		Logger:        logger,
+	})
+
+	// Forward broker events to WebSocket clients
+	if messageClient != nil {
+		gatewayServer.SubscribeToEvents()
+	}
 
 	// Setup HTTP server
 	server := &http.Server{
@@ -79,6 +255,15 @@ func main() {
 
 	logger.Info("Shutting down API Gateway...")
 
+	// Stop admitting clients and drain the connected ones before the HTTP
+	// server, which would otherwise wait on open event streams
+	drainTimeout := time.Duration(cfg.APIGatewayConfig.WebSocket.Shutdown.DrainTimeoutSeconds) * time.Second
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	if err := wsHub.Shutdown(drainCtx); err != nil {
+		logger.Warnf("WebSocket clients not drained before timeout: %v", err)
+	}
+	cancelDrain()
+
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -95,5 +280,12 @@ func main() {
 	// Close WebSocket hub
 	wsHub.Close()
 
+	// Flush the offline event queue
+	if offlineStore != nil {
+		if err := offlineStore.Close(); err != nil {
+			logger.Errorf("Failed to close offline event store: %v", err)
+		}
+	}
+
 	logger.Info("API Gateway stopped")
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"cryptobot-api-gateway/internal/auth"
+)
+
+// hash-password reads a password from stdin and prints a hash for the users file
+func main() {
+	algorithm := flag.String("algorithm", auth.HashBcrypt, "hash algorithm: bcrypt or argon2id")
+	flag.Parse()
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Fatalf("Failed to read password: %v", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		log.Fatal("Password must not be empty")
+	}
+
+	hash, err := auth.HashPassword(password, *algorithm)
+	if err != nil {
+		log.Fatalf("Failed to hash password: %v", err)
+	}
+	fmt.Println(hash)
+}
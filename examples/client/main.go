@@ -126,7 +126,6 @@ func testWebSocket(baseURL, token string) {
 
 	u.Scheme = "ws"
 	u.Path = "/ws"
-	u.RawQuery = "user_id=test_client"
 
 	header := http.Header{}
 	header.Set("Authorization", "Bearer "+token)
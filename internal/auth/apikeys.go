@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// API key scopes. A key may only use the permissions its scopes grant, and
+// a key with only ScopeRead may not make requests that change state.
+const (
+	ScopeRead     = "read"
+	ScopeTrade    = "trade"
+	ScopeCommands = "commands"
+)
+
+// apiKeyPrefix starts every API key so leaked keys are easy to recognise
+const apiKeyPrefix = "cbk_"
+
+// lastUsedResolution limits how often a key's last-used time is stored
+const lastUsedResolution = time.Minute
+
+// Errors returned by API key stores and APIKeys
+var (
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+	ErrInvalidAPIKey      = errors.New("invalid api key")
+	ErrAPIKeyExpired      = errors.New("api key expired")
+	ErrAPIKeyIPNotAllowed = errors.New("api key not allowed from this address")
+)
+
+// APIKey is the stored form of an API key. The key itself is only shown
+// when it is created; it starts with its ID followed by a secret, and only
+// its hash is kept. AllowedIPs holds addresses or CIDR ranges the key may
+// be used from, and is empty when the key may be used from anywhere.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	UserID     string     `json:"userId"`
+	Hash       string     `json:"hash"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowedIps,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// HasScope reports whether the key was granted scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadOnly reports whether the key may only make requests that do not
+// change state
+func (k APIKey) ReadOnly() bool {
+	return !k.HasScope(ScopeTrade) && !k.HasScope(ScopeCommands)
+}
+
+// APIKeyStore persists API keys by ID
+type APIKeyStore interface {
+	// Create stores a new key, failing if its ID is already taken
+	Create(key APIKey) error
+	// Get returns the key with the given ID
+	Get(id string) (APIKey, error)
+	// List returns a user's keys, oldest first
+	List(userID string) ([]APIKey, error)
+	// Delete removes a key
+	Delete(id string) error
+	// Touch records when a key was last used
+	Touch(id string, usedAt time.Time) error
+}
+
+// APIKeyRequest describes a key to create
+type APIKeyRequest struct {
+	UserID     string
+	Name       string
+	Scopes     []string
+	AllowedIPs []string
+	ExpiresAt  time.Time
+}
+
+// APIKeys creates and verifies API keys and requests signed with them
+type APIKeys struct {
+	store         APIKeyStore
+	signingSecret []byte
+	skew          time.Duration
+	nonces        NonceCache
+}
+
+// NewAPIKeys creates an API key manager backed by store. Signed requests
+// are verified with secrets derived from signingSecret, and are disabled
+// when it is empty; their timestamps may be off by up to skew.
+func NewAPIKeys(store APIKeyStore, signingSecret []byte, skew time.Duration, nonces NonceCache) *APIKeys {
+	return &APIKeys{store: store, signingSecret: signingSecret, skew: skew, nonces: nonces}
+}
+
+// Create issues a key and returns it together with its stored record.
+// The returned key cannot be recovered later.
+func (a *APIKeys) Create(request APIKeyRequest) (string, APIKey, error) {
+	if len(request.Scopes) == 0 {
+		return "", APIKey{}, errors.New("at least one scope is required")
+	}
+	for _, scope := range request.Scopes {
+		if scope != ScopeRead && scope != ScopeTrade && scope != ScopeCommands {
+			return "", APIKey{}, fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+	for _, allowed := range request.AllowedIPs {
+		if _, err := parseAllowedIP(allowed); err != nil {
+			return "", APIKey{}, err
+		}
+	}
+
+	now := time.Now().UTC()
+	if !request.ExpiresAt.After(now) {
+		return "", APIKey{}, errors.New("expiry must be in the future")
+	}
+
+	id := apiKeyPrefix + newKeyID()
+	secret := newOpaqueID(32)
+	key := id + "_" + secret
+
+	record := APIKey{
+		ID:         id,
+		Name:       request.Name,
+		UserID:     request.UserID,
+		Hash:       hashToken(key),
+		Scopes:     request.Scopes,
+		AllowedIPs: request.AllowedIPs,
+		CreatedAt:  now,
+		ExpiresAt:  request.ExpiresAt.UTC(),
+	}
+	if err := a.store.Create(record); err != nil {
+		return "", APIKey{}, fmt.Errorf("failed to store api key: %w", err)
+	}
+	return key, record, nil
+}
+
+// Authenticate verifies a presented key and that it may be used from
+// clientIP, and records its use
+func (a *APIKeys) Authenticate(key, clientIP string) (APIKey, error) {
+	id, ok := apiKeyID(key)
+	if !ok {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	record, err := a.store.Get(id)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(key)), []byte(record.Hash)) != 1 {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	return a.use(record, clientIP)
+}
+
+// use checks that an authenticated key is still valid from clientIP and
+// records its use
+func (a *APIKeys) use(record APIKey, clientIP string) (APIKey, error) {
+	now := time.Now().UTC()
+	if !now.Before(record.ExpiresAt) {
+		return APIKey{}, ErrAPIKeyExpired
+	}
+	if !ipAllowed(record.AllowedIPs, clientIP) {
+		return APIKey{}, ErrAPIKeyIPNotAllowed
+	}
+
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) >= lastUsedResolution {
+		if err := a.store.Touch(record.ID, now); err != nil {
+			return APIKey{}, fmt.Errorf("failed to record api key use: %w", err)
+		}
+		record.LastUsedAt = &now
+	}
+	return record, nil
+}
+
+// Get returns the key with the given ID
+func (a *APIKeys) Get(id string) (APIKey, error) {
+	return a.store.Get(id)
+}
+
+// List returns a user's keys
+func (a *APIKeys) List(userID string) ([]APIKey, error) {
+	return a.store.List(userID)
+}
+
+// Revoke deletes a key so it can no longer be used
+func (a *APIKeys) Revoke(id string) error {
+	return a.store.Delete(id)
+}
+
+// apiKeyID extracts the ID from a presented key
+func apiKeyID(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", false
+	}
+	i := strings.Index(key[len(apiKeyPrefix):], "_")
+	if i <= 0 {
+		return "", false
+	}
+	return key[:len(apiKeyPrefix)+i], true
+}
+
+// ipAllowed reports whether clientIP matches one of allowed, or allowed is empty
+func ipAllowed(allowed []string, clientIP string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range allowed {
+		network, err := parseAllowedIP(entry)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAllowedIP parses an allowlist entry, either an address or a CIDR range
+func parseAllowedIP(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range: %s", entry)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", entry)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// newKeyID returns the random, non-secret part of an API key's ID
+func newKeyID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
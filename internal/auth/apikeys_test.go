@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAPIKeys returns API keys backed by a file store in a temporary
+// directory, with request signing enabled
+func newTestAPIKeys(t *testing.T) *APIKeys {
+	t.Helper()
+
+	store, err := NewFileAPIKeyStore(filepath.Join(t.TempDir(), "api-keys.json"))
+	if err != nil {
+		t.Fatalf("NewFileAPIKeyStore: %v", err)
+	}
+	return NewAPIKeys(store, []byte("signing-secret"), 30*time.Second, NewMemoryNonceCache())
+}
+
+func TestAPIKeysCreateValidation(t *testing.T) {
+	keys := newTestAPIKeys(t)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		request APIKeyRequest
+		valid   bool
+	}{
+		{"valid", APIKeyRequest{UserID: "u-alice", Scopes: []string{ScopeRead}, ExpiresAt: future}, true},
+		{"all scopes", APIKeyRequest{UserID: "u-alice", Scopes: []string{ScopeRead, ScopeTrade, ScopeCommands}, ExpiresAt: future}, true},
+		{"allowlisted address and range", APIKeyRequest{UserID: "u-alice", Scopes: []string{ScopeRead}, AllowedIPs: []string{"10.0.0.1", "192.168.0.0/16", "2001:db8::/32"}, ExpiresAt: future}, true},
+		{"no scopes", APIKeyRequest{UserID: "u-alice", ExpiresAt: future}, false},
+		{"unknown scope", APIKeyRequest{UserID: "u-alice", Scopes: []string{"admin"}, ExpiresAt: future}, false},
+		{"invalid address", APIKeyRequest{UserID: "u-alice", Scopes: []string{ScopeRead}, AllowedIPs: []string{"10.0.0"}, ExpiresAt: future}, false},
+		{"invalid range", APIKeyRequest{UserID: "u-alice", Scopes: []string{ScopeRead}, AllowedIPs: []string{"10.0.0.0/33"}, ExpiresAt: future}, false},
+		{"past expiry", APIKeyRequest{UserID: "u-alice", Scopes: []string{ScopeRead}, ExpiresAt: time.Now().Add(-time.Minute)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, record, err := keys.Create(tt.request)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Create() error = %v, valid %v", err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			if !strings.HasPrefix(key, record.ID+"_") {
+				t.Errorf("key %q does not start with its ID %q", key, record.ID)
+			}
+			if strings.Contains(record.Hash, key) {
+				t.Error("stored record contains the key")
+			}
+		})
+	}
+}
+
+func TestAPIKeysAuthenticate(t *testing.T) {
+	keys := newTestAPIKeys(t)
+	key, record, err := keys.Create(APIKeyRequest{
+		UserID:     "u-alice",
+		Scopes:     []string{ScopeRead},
+		AllowedIPs: []string{"10.1.0.0/16"},
+		ExpiresAt:  time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		clientIP string
+		want     error
+	}{
+		{"valid key from an allowed address", key, "10.1.2.3", nil},
+		{"valid key from another address", key, "10.2.0.1", ErrAPIKeyIPNotAllowed},
+		{"wrong secret", record.ID + "_wrong", "10.1.2.3", ErrInvalidAPIKey},
+		{"unknown key", apiKeyPrefix + "000000000000_secret", "10.1.2.3", ErrInvalidAPIKey},
+		{"malformed key", "not-a-key", "10.1.2.3", ErrInvalidAPIKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keys.Authenticate(tt.key, tt.clientIP)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.want)
+			}
+			if err == nil && (got.ID != record.ID || got.LastUsedAt == nil) {
+				t.Errorf("Authenticate() = %+v, want key %s with its use recorded", got, record.ID)
+			}
+		})
+	}
+}
+
+func TestAPIKeysRejectExpiredAndRevokedKeys(t *testing.T) {
+	keys := newTestAPIKeys(t)
+	key, record, err := keys.Create(APIKeyRequest{UserID: "u-alice", Scopes: []string{ScopeRead}, ExpiresAt: time.Now().Add(50 * time.Millisecond)})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := keys.Authenticate(key, "10.0.0.1"); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("Authenticate() of an expired key error = %v, want %v", err, ErrAPIKeyExpired)
+	}
+
+	if err := keys.Revoke(record.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := keys.Authenticate(key, "10.0.0.1"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate() of a revoked key error = %v, want %v", err, ErrInvalidAPIKey)
+	}
+}
+
+func TestAPIKeyReadOnly(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		want   bool
+	}{
+		{[]string{ScopeRead}, true},
+		{[]string{ScopeRead, ScopeTrade}, false},
+		{[]string{ScopeCommands}, false},
+	}
+
+	for _, tt := range tests {
+		if got := (APIKey{Scopes: tt.scopes}).ReadOnly(); got != tt.want {
+			t.Errorf("ReadOnly() with scopes %v = %v, want %v", tt.scopes, got, tt.want)
+		}
+	}
+}
+
+func TestFileAPIKeyStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-keys.json")
+	store, err := NewFileAPIKeyStore(path)
+	if err != nil {
+		t.Fatalf("NewFileAPIKeyStore: %v", err)
+	}
+	key, record, err := NewAPIKeys(store, nil, 0, nil).Create(APIKeyRequest{UserID: "u-alice", Scopes: []string{ScopeRead}, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	reopened, err := NewFileAPIKeyStore(path)
+	if err != nil {
+		t.Fatalf("NewFileAPIKeyStore: %v", err)
+	}
+	keys := NewAPIKeys(reopened, nil, 0, nil)
+	if _, err := keys.Authenticate(key, "10.0.0.1"); err != nil {
+		t.Fatalf("key not usable after reopening the store: %v", err)
+	}
+	listed, err := keys.List("u-alice")
+	if err != nil || len(listed) != 1 || listed[0].ID != record.ID {
+		t.Errorf("List() = %v, %v; want the created key", listed, err)
+	}
+}
+
+// newSharedTestAPIKeys returns API keys kept in a file of their own and
+// shared through replication
+func newSharedTestAPIKeys(t *testing.T, replication *Replication, path string) *APIKeys {
+	t.Helper()
+
+	store, err := NewFileAPIKeyStore(path)
+	if err != nil {
+		t.Fatalf("NewFileAPIKeyStore: %v", err)
+	}
+	return NewAPIKeys(NewSharedAPIKeyStore(store, replication), nil, 0, nil)
+}
+
+func TestSharedAPIKeyStoreAcrossReplicas(t *testing.T) {
+	broker := &testBroker{}
+	dir := t.TempDir()
+	onA := newSharedTestAPIKeys(t, broker.join("a"), filepath.Join(dir, "a.json"))
+	onB := newSharedTestAPIKeys(t, broker.join("b"), filepath.Join(dir, "b.json"))
+
+	key, record, err := onA.Create(APIKeyRequest{UserID: "u-alice", Scopes: []string{ScopeRead}, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := onB.Authenticate(key, "10.0.0.1"); err != nil {
+		t.Fatalf("other replica Authenticate() error = %v", err)
+	}
+	if used, _ := onA.Get(record.ID); used.LastUsedAt == nil {
+		t.Error("use on the other replica was not recorded")
+	}
+
+	if err := onA.Revoke(record.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := onB.Authenticate(key, "10.0.0.1"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("other replica Authenticate() of a revoked key error = %v, want %v", err, ErrInvalidAPIKey)
+	}
+}
+
+func TestSharedAPIKeyStoreRevokesStaleCopies(t *testing.T) {
+	broker := &testBroker{}
+	dir := t.TempDir()
+	onA := newSharedTestAPIKeys(t, broker.join("a"), filepath.Join(dir, "a.json"))
+	newSharedTestAPIKeys(t, broker.join("b"), filepath.Join(dir, "b.json"))
+
+	key, record, err := onA.Create(APIKeyRequest{UserID: "u-alice", Scopes: []string{ScopeRead}, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Replica b restarts with its copy of the key after it was revoked
+	broker.replicas = broker.replicas[:1]
+	if err := onA.Revoke(record.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	restarted := broker.join("b")
+	onB := newSharedTestAPIKeys(t, restarted, filepath.Join(dir, "b.json"))
+	if _, err := onB.Authenticate(key, "10.0.0.1"); err != nil {
+		t.Fatalf("stale copy Authenticate() error = %v", err)
+	}
+	if err := restarted.RequestSync(); err != nil {
+		t.Fatalf("RequestSync: %v", err)
+	}
+
+	if _, err := onB.Authenticate(key, "10.0.0.1"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("restarted replica Authenticate() error = %v, want %v", err, ErrInvalidAPIKey)
+	}
+	if _, err := onA.Get(record.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("revoked key came back: Get() error = %v, want %v", err, ErrAPIKeyNotFound)
+	}
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sources of a bot's owner
+const (
+	BotOwnerRegistry = "registry"
+	BotOwnerAdmin    = "admin"
+)
+
+// Errors returned by bot stores and BotOwners
+var (
+	ErrBotNotFound  = errors.New("bot not found")
+	ErrBotNoOwner   = errors.New("bot owner required")
+	ErrBotForbidden = errors.New("bot belongs to another user")
+)
+
+// BotOwnership records who may control a bot: the user or team that owns
+// it and the users it was shared with. Source tells whether the owner was
+// reported by the bot registry or set by an administrator.
+type BotOwnership struct {
+	BotID      string    `json:"botId"`
+	OwnerID    string    `json:"ownerId,omitempty"`
+	TeamID     string    `json:"teamId,omitempty"`
+	SharedWith []string  `json:"sharedWith,omitempty"`
+	Source     string    `json:"source"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// Allows reports whether user owns the bot, belongs to its team or was
+// given access to it
+func (b BotOwnership) Allows(user User) bool {
+	if b.OwnerID != "" && b.OwnerID == user.ID {
+		return true
+	}
+	if b.TeamID != "" {
+		for _, team := range user.Teams {
+			if team == b.TeamID {
+				return true
+			}
+		}
+	}
+	for _, userID := range b.SharedWith {
+		if userID == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// BotStore persists bot ownership by bot ID
+type BotStore interface {
+	// Get returns a bot's ownership
+	Get(botID string) (BotOwnership, error)
+	// Save stores a bot's ownership, replacing any previous one
+	Save(ownership BotOwnership) error
+	// List returns every bot's ownership
+	List() ([]BotOwnership, error)
+}
+
+// BotOwners tracks which user or team owns each bot and who it is shared
+// with. Updates are serialized so concurrent changes are not lost.
+type BotOwners struct {
+	store BotStore
+	mu    sync.Mutex
+}
+
+// NewBotOwners creates a bot ownership registry backed by store
+func NewBotOwners(store BotStore) *BotOwners {
+	return &BotOwners{store: store}
+}
+
+// Authorize checks that user may control a bot. Bots without a known owner
+// may only be controlled by administrators, which callers check first.
+func (o *BotOwners) Authorize(botID string, user User) error {
+	ownership, err := o.store.Get(botID)
+	if errors.Is(err, ErrBotNotFound) {
+		return ErrBotNoOwner
+	}
+	if err != nil {
+		return err
+	}
+	if ownership.OwnerID == "" && ownership.TeamID == "" && len(ownership.SharedWith) == 0 {
+		return ErrBotNoOwner
+	}
+	if !ownership.Allows(user) {
+		return ErrBotForbidden
+	}
+	return nil
+}
+
+// SetOwner records the user or team owning a bot, keeping who it is shared
+// with. source is BotOwnerRegistry or BotOwnerAdmin. An owner set by an
+// administrator is kept when the registry reports another, so a bot cannot
+// take itself back by announcing a different owner.
+func (o *BotOwners) SetOwner(botID, ownerID, teamID, source string) (BotOwnership, error) {
+	if ownerID == "" && teamID == "" {
+		return BotOwnership{}, errors.New("owner or team required")
+	}
+
+	return o.update(botID, func(ownership *BotOwnership) bool {
+		if source == BotOwnerRegistry && ownership.Source == BotOwnerAdmin {
+			return false
+		}
+		if ownership.OwnerID == ownerID && ownership.TeamID == teamID && ownership.Source == source {
+			return false
+		}
+		ownership.OwnerID = ownerID
+		ownership.TeamID = teamID
+		ownership.Source = source
+		return true
+	})
+}
+
+// Share gives a user access to a bot
+func (o *BotOwners) Share(botID, userID string) (BotOwnership, error) {
+	return o.update(botID, func(ownership *BotOwnership) bool {
+		for _, shared := range ownership.SharedWith {
+			if shared == userID {
+				return false
+			}
+		}
+		ownership.SharedWith = append(ownership.SharedWith, userID)
+		return true
+	})
+}
+
+// Unshare removes a user's access to a bot
+func (o *BotOwners) Unshare(botID, userID string) (BotOwnership, error) {
+	return o.update(botID, func(ownership *BotOwnership) bool {
+		for i, shared := range ownership.SharedWith {
+			if shared == userID {
+				ownership.SharedWith = append(ownership.SharedWith[:i:i], ownership.SharedWith[i+1:]...)
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Get returns a bot's ownership
+func (o *BotOwners) Get(botID string) (BotOwnership, error) {
+	return o.store.Get(botID)
+}
+
+// List returns every bot's ownership, ordered by bot ID
+func (o *BotOwners) List() ([]BotOwnership, error) {
+	bots, err := o.store.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(bots, func(i, j int) bool { return bots[i].BotID < bots[j].BotID })
+	return bots, nil
+}
+
+// update applies change to a bot's ownership, creating it if the bot is
+// new, and stores it if change reports a change
+func (o *BotOwners) update(botID string, change func(ownership *BotOwnership) bool) (BotOwnership, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ownership, err := o.store.Get(botID)
+	if errors.Is(err, ErrBotNotFound) {
+		ownership = BotOwnership{BotID: botID}
+	} else if err != nil {
+		return BotOwnership{}, err
+	}
+
+	if !change(&ownership) {
+		return ownership, nil
+	}
+	ownership.UpdatedAt = time.Now().UTC()
+	if err := o.store.Save(ownership); err != nil {
+		return BotOwnership{}, fmt.Errorf("failed to store bot ownership: %w", err)
+	}
+	return ownership, nil
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// newTestBotOwners returns a bot ownership registry backed by a file store
+// in a temporary directory
+func newTestBotOwners(t *testing.T) *BotOwners {
+	t.Helper()
+
+	store, err := NewFileBotStore(filepath.Join(t.TempDir(), "bots.json"))
+	if err != nil {
+		t.Fatalf("NewFileBotStore: %v", err)
+	}
+	return NewBotOwners(store)
+}
+
+func TestBotOwnersAuthorize(t *testing.T) {
+	bots := newTestBotOwners(t)
+	bots.SetOwner("bot-owned", "u-alice", "", BotOwnerRegistry)
+	bots.SetOwner("bot-team", "", "desk", BotOwnerRegistry)
+	bots.SetOwner("bot-shared", "u-root", "", BotOwnerAdmin)
+	bots.Share("bot-shared", "u-bob")
+
+	alice := User{ID: "u-alice", Teams: []string{"desk"}}
+	bob := User{ID: "u-bob"}
+
+	tests := []struct {
+		name  string
+		botID string
+		user  User
+		want  error
+	}{
+		{"owner", "bot-owned", alice, nil},
+		{"other user", "bot-owned", bob, ErrBotForbidden},
+		{"team member", "bot-team", alice, nil},
+		{"not in team", "bot-team", bob, ErrBotForbidden},
+		{"shared with", "bot-shared", bob, nil},
+		{"not shared with", "bot-shared", alice, ErrBotForbidden},
+		{"unknown bot", "bot-unknown", alice, ErrBotNoOwner},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := bots.Authorize(tt.botID, tt.user); !errors.Is(err, tt.want) {
+				t.Errorf("Authorize() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	bots.Unshare("bot-shared", "u-bob")
+	if err := bots.Authorize("bot-shared", bob); !errors.Is(err, ErrBotForbidden) {
+		t.Errorf("Authorize() after unshare error = %v, want %v", err, ErrBotForbidden)
+	}
+}
+
+func TestBotOwnersKeepAdminOwner(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates []BotOwnership
+		want    BotOwnership
+	}{
+		{
+			"registry reports the owner",
+			[]BotOwnership{{OwnerID: "u-alice", Source: BotOwnerRegistry}},
+			BotOwnership{OwnerID: "u-alice", Source: BotOwnerRegistry},
+		},
+		{
+			"registry changes the owner",
+			[]BotOwnership{{OwnerID: "u-alice", Source: BotOwnerRegistry}, {OwnerID: "u-bob", Source: BotOwnerRegistry}},
+			BotOwnership{OwnerID: "u-bob", Source: BotOwnerRegistry},
+		},
+		{
+			"admin overrides the registry",
+			[]BotOwnership{{OwnerID: "u-alice", Source: BotOwnerRegistry}, {TeamID: "desk", Source: BotOwnerAdmin}},
+			BotOwnership{TeamID: "desk", Source: BotOwnerAdmin},
+		},
+		{
+			"registry cannot override an admin",
+			[]BotOwnership{{OwnerID: "u-root", Source: BotOwnerAdmin}, {OwnerID: "u-mallory", Source: BotOwnerRegistry}},
+			BotOwnership{OwnerID: "u-root", Source: BotOwnerAdmin},
+		},
+		{
+			"admin changes an admin owner",
+			[]BotOwnership{{OwnerID: "u-root", Source: BotOwnerAdmin}, {OwnerID: "u-alice", Source: BotOwnerAdmin}},
+			BotOwnership{OwnerID: "u-alice", Source: BotOwnerAdmin},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bots := newTestBotOwners(t)
+			for _, update := range tt.updates {
+				if _, err := bots.SetOwner("bot-1", update.OwnerID, update.TeamID, update.Source); err != nil {
+					t.Fatalf("SetOwner: %v", err)
+				}
+			}
+
+			got, err := bots.Get("bot-1")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if got.OwnerID != tt.want.OwnerID || got.TeamID != tt.want.TeamID || got.Source != tt.want.Source {
+				t.Errorf("ownership = owner %q team %q from %s, want owner %q team %q from %s",
+					got.OwnerID, got.TeamID, got.Source, tt.want.OwnerID, tt.want.TeamID, tt.want.Source)
+			}
+		})
+	}
+}
+
+func TestFileBotStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bots.json")
+	store, err := NewFileBotStore(path)
+	if err != nil {
+		t.Fatalf("NewFileBotStore: %v", err)
+	}
+	bots := NewBotOwners(store)
+	bots.SetOwner("bot-1", "u-root", "", BotOwnerAdmin)
+	bots.Share("bot-1", "u-bob")
+
+	reopened, err := NewFileBotStore(path)
+	if err != nil {
+		t.Fatalf("NewFileBotStore: %v", err)
+	}
+	bots = NewBotOwners(reopened)
+
+	// The admin-set owner survives a restart and still wins over the registry
+	bots.SetOwner("bot-1", "u-mallory", "", BotOwnerRegistry)
+	got, err := bots.Get("bot-1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.OwnerID != "u-root" || got.Source != BotOwnerAdmin || len(got.SharedWith) != 1 {
+		t.Errorf("ownership after reopening = %+v", got)
+	}
+	if _, err := bots.Get("bot-2"); !errors.Is(err, ErrBotNotFound) {
+		t.Errorf("Get() of an unknown bot error = %v, want %v", err, ErrBotNotFound)
+	}
+}
+
+// newSharedTestBotOwners returns a bot ownership registry kept in a file of
+// its own and shared through replication
+func newSharedTestBotOwners(t *testing.T, replication *Replication, path string) *BotOwners {
+	t.Helper()
+
+	store, err := NewFileBotStore(path)
+	if err != nil {
+		t.Fatalf("NewFileBotStore: %v", err)
+	}
+	return NewBotOwners(NewSharedBotStore(store, replication))
+}
+
+func TestSharedBotStoreAcrossReplicas(t *testing.T) {
+	broker := &testBroker{}
+	dir := t.TempDir()
+	onA := newSharedTestBotOwners(t, broker.join("a"), filepath.Join(dir, "a.json"))
+	onB := newSharedTestBotOwners(t, broker.join("b"), filepath.Join(dir, "b.json"))
+	bob := User{ID: "u-bob"}
+
+	onA.SetOwner("bot-1", "u-alice", "", BotOwnerRegistry)
+	if _, err := onA.Share("bot-1", "u-bob"); err != nil {
+		t.Fatalf("Share: %v", err)
+	}
+	if err := onB.Authorize("bot-1", bob); err != nil {
+		t.Errorf("other replica Authorize() of a shared bot error = %v", err)
+	}
+
+	if _, err := onB.Unshare("bot-1", "u-bob"); err != nil {
+		t.Fatalf("Unshare: %v", err)
+	}
+	if err := onA.Authorize("bot-1", bob); !errors.Is(err, ErrBotForbidden) {
+		t.Errorf("other replica Authorize() after Unshare error = %v, want %v", err, ErrBotForbidden)
+	}
+}
+
+func TestSharedBotStoreUpdatesStaleCopies(t *testing.T) {
+	broker := &testBroker{}
+	dir := t.TempDir()
+	onA := newSharedTestBotOwners(t, broker.join("a"), filepath.Join(dir, "a.json"))
+	newSharedTestBotOwners(t, broker.join("b"), filepath.Join(dir, "b.json"))
+	onA.SetOwner("bot-1", "u-alice", "", BotOwnerRegistry)
+	onA.Share("bot-1", "u-bob")
+
+	// Replica b restarts with its copy of the bot after the grant was removed
+	broker.replicas = broker.replicas[:1]
+	if _, err := onA.Unshare("bot-1", "u-bob"); err != nil {
+		t.Fatalf("Unshare: %v", err)
+	}
+	restarted := broker.join("b")
+	onB := newSharedTestBotOwners(t, restarted, filepath.Join(dir, "b.json"))
+	if err := restarted.RequestSync(); err != nil {
+		t.Fatalf("RequestSync: %v", err)
+	}
+
+	if err := onB.Authorize("bot-1", User{ID: "u-bob"}); !errors.Is(err, ErrBotForbidden) {
+		t.Errorf("restarted replica Authorize() error = %v, want %v", err, ErrBotForbidden)
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the claims of a gateway access token. The registered jti,
+// sub, iss, aud, exp, nbf and iat claims come from jwt.RegisteredClaims;
+// UserID duplicates sub for services that read the older user_id claim.
+// AuthTime and AMR record when and how the user last authenticated.
+//
+// Callers authenticated with an API key get Claims built from the key's
+// owner, with APIKeyID, Scopes and Signed set; those are never part of a
+// token. Signed reports whether the request carried a valid signature.
+type Claims struct {
+	UserID    string           `json:"user_id"`
+	Username  string           `json:"username"`
+	Roles     []string         `json:"roles"`
+	SessionID string           `json:"sid,omitempty"`
+	AuthTime  *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR       []string         `json:"amr,omitempty"`
+	APIKeyID  string           `json:"-"`
+	Scopes    []string         `json:"-"`
+	Signed    bool             `json:"-"`
+	jwt.RegisteredClaims
+}
+
+// HasAMR reports whether the user authenticated with method
+func (c *Claims) HasAMR(method string) bool {
+	for _, m := range c.AMR {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
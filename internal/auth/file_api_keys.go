@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileAPIKeyStore is an APIKeyStore that keeps all keys in a single JSON
+// file, rewritten on every change
+type FileAPIKeyStore struct {
+	path string
+	keys map[string]APIKey
+	mu   sync.Mutex
+}
+
+// NewFileAPIKeyStore opens or creates the store at path
+func NewFileAPIKeyStore(path string) (*FileAPIKeyStore, error) {
+	store := &FileAPIKeyStore{
+		path: path,
+		keys: make(map[string]APIKey),
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create api key store directory: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to read api key store: %w", err)
+	default:
+		if err := json.Unmarshal(data, &store.keys); err != nil {
+			return nil, fmt.Errorf("failed to decode api key store: %w", err)
+		}
+	}
+
+	return store, nil
+}
+
+// Create stores a new key, failing if its ID is already taken
+func (s *FileAPIKeyStore) Create(key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.keys[key.ID]; exists {
+		return fmt.Errorf("duplicate api key id: %s", key.ID)
+	}
+	s.keys[key.ID] = key
+	return s.save()
+}
+
+// Get returns the key with the given ID
+func (s *FileAPIKeyStore) Get(id string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+// List returns a user's keys, oldest first
+func (s *FileAPIKeyStore) List(userID string) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []APIKey{}
+	for _, key := range s.keys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// Delete removes a key
+func (s *FileAPIKeyStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		return ErrAPIKeyNotFound
+	}
+	delete(s.keys, id)
+	return s.save()
+}
+
+// Touch records when a key was last used
+func (s *FileAPIKeyStore) Touch(id string, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = &usedAt
+	s.keys[id] = key
+	return s.save()
+}
+
+// put stores key, replacing any previous key with its ID
+func (s *FileAPIKeyStore) put(key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = key
+	return s.save()
+}
+
+// all returns every stored key
+func (s *FileAPIKeyStore) all() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// save atomically writes all keys to disk. The caller must hold s.mu.
+func (s *FileAPIKeyStore) save() error {
+	data, err := json.Marshal(s.keys)
+	if err != nil {
+		return fmt.Errorf("failed to encode api key store: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write api key store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace api key store: %w", err)
+	}
+	return nil
+}
+
+// apiKeyStoreName identifies API keys in replication messages
+const apiKeyStoreName = "api_keys"
+
+// revokedAPIKey is a deleted key, remembered until it would have expired
+type revokedAPIKey struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// apiKeyUse records when a key was last used
+type apiKeyUse struct {
+	ID     string    `json:"id"`
+	UsedAt time.Time `json:"usedAt"`
+}
+
+// SharedAPIKeyStore is an APIKeyStore replicated between gateway replicas,
+// so a key created or revoked on one replica is created or revoked on all
+// of them. Each replica keeps its own copy of the keys in local, which must
+// not be shared with other replicas. Revoked key IDs are remembered until
+// the key would have expired, so a replica that restarts with a stale copy
+// cannot bring a revoked key back; a key revoked on another replica may
+// still be used within the broker's delivery delay.
+type SharedAPIKeyStore struct {
+	local       *FileAPIKeyStore
+	replication *Replication
+	revoked     map[string]time.Time
+	mu          sync.Mutex
+}
+
+// NewSharedAPIKeyStore creates a store that keeps keys in local and shares
+// them through replication
+func NewSharedAPIKeyStore(local *FileAPIKeyStore, replication *Replication) *SharedAPIKeyStore {
+	store := &SharedAPIKeyStore{
+		local:       local,
+		replication: replication,
+		revoked:     make(map[string]time.Time),
+	}
+	replication.register(apiKeyStoreName, store)
+	return store
+}
+
+// Create stores a new key and sends it to the other replicas
+func (s *SharedAPIKeyStore) Create(key APIKey) error {
+	if err := s.local.Create(key); err != nil {
+		return err
+	}
+	return s.replication.send(apiKeyStoreName, "create", key)
+}
+
+// Get returns the key with the given ID
+func (s *SharedAPIKeyStore) Get(id string) (APIKey, error) {
+	return s.local.Get(id)
+}
+
+// List returns a user's keys, oldest first
+func (s *SharedAPIKeyStore) List(userID string) ([]APIKey, error) {
+	return s.local.List(userID)
+}
+
+// Delete removes a key on every replica
+func (s *SharedAPIKeyStore) Delete(id string) error {
+	key, err := s.local.Get(id)
+	if err != nil {
+		return err
+	}
+	if err := s.local.Delete(id); err != nil {
+		return err
+	}
+
+	revoked := revokedAPIKey{ID: id, ExpiresAt: key.ExpiresAt}
+	s.remember(revoked)
+	return s.replication.send(apiKeyStoreName, "delete", revoked)
+}
+
+// Touch records when a key was last used and sends it to the other replicas
+func (s *SharedAPIKeyStore) Touch(id string, usedAt time.Time) error {
+	if err := s.local.Touch(id, usedAt); err != nil {
+		return err
+	}
+	return s.replication.send(apiKeyStoreName, "touch", apiKeyUse{ID: id, UsedAt: usedAt})
+}
+
+// remember records a revoked key, forgetting those that have expired
+func (s *SharedAPIKeyStore) remember(revoked revokedAPIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, expiresAt := range s.revoked {
+		if !now.Before(expiresAt) {
+			delete(s.revoked, id)
+		}
+	}
+	if now.Before(revoked.ExpiresAt) {
+		s.revoked[revoked.ID] = revoked.ExpiresAt
+	}
+}
+
+// isRevoked reports whether the key with the given ID was revoked
+func (s *SharedAPIKeyStore) isRevoked(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revoked[id]
+	return ok
+}
+
+// applyChange applies a change made by another replica
+func (s *SharedAPIKeyStore) applyChange(change StoreChange) error {
+	switch change.Op {
+	case "create":
+		var key APIKey
+		if err := json.Unmarshal(change.Data, &key); err != nil {
+			return err
+		}
+		if s.isRevoked(key.ID) {
+			return nil
+		}
+		return s.local.put(key)
+	case "delete":
+		var revoked revokedAPIKey
+		if err := json.Unmarshal(change.Data, &revoked); err != nil {
+			return err
+		}
+		s.remember(revoked)
+		if err := s.local.Delete(revoked.ID); err != nil && !errors.Is(err, ErrAPIKeyNotFound) {
+			return err
+		}
+	case "touch":
+		var use apiKeyUse
+		if err := json.Unmarshal(change.Data, &use); err != nil {
+			return err
+		}
+		if err := s.local.Touch(use.ID, use.UsedAt); err != nil && !errors.Is(err, ErrAPIKeyNotFound) {
+			return err
+		}
+	default:
+		return unknownChange(change)
+	}
+	return nil
+}
+
+// snapshot returns the keys this replica holds and those it knows were
+// revoked, revocations first
+func (s *SharedAPIKeyStore) snapshot() []StoreChange {
+	s.mu.Lock()
+	changes := make([]StoreChange, 0, len(s.revoked))
+	for id, expiresAt := range s.revoked {
+		changes = append(changes, storeChange("delete", revokedAPIKey{ID: id, ExpiresAt: expiresAt}))
+	}
+	s.mu.Unlock()
+
+	for _, key := range s.local.all() {
+		changes = append(changes, storeChange("create", key))
+	}
+	return changes
+}
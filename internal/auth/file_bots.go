@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileBotStore is a BotStore that keeps all bot ownership in a single JSON
+// file, rewritten on every change
+type FileBotStore struct {
+	path string
+	bots map[string]BotOwnership
+	mu   sync.Mutex
+}
+
+// NewFileBotStore opens or creates the store at path
+func NewFileBotStore(path string) (*FileBotStore, error) {
+	store := &FileBotStore{
+		path: path,
+		bots: make(map[string]BotOwnership),
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create bot store directory: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to read bot store: %w", err)
+	default:
+		if err := json.Unmarshal(data, &store.bots); err != nil {
+			return nil, fmt.Errorf("failed to decode bot store: %w", err)
+		}
+	}
+
+	return store, nil
+}
+
+// Get returns a bot's ownership
+func (s *FileBotStore) Get(botID string) (BotOwnership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ownership, ok := s.bots[botID]
+	if !ok {
+		return BotOwnership{}, ErrBotNotFound
+	}
+	return ownership, nil
+}
+
+// Save stores a bot's ownership, replacing any previous one
+func (s *FileBotStore) Save(ownership BotOwnership) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bots[ownership.BotID] = ownership
+	return s.save()
+}
+
+// List returns every bot's ownership
+func (s *FileBotStore) List() ([]BotOwnership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bots := make([]BotOwnership, 0, len(s.bots))
+	for _, ownership := range s.bots {
+		bots = append(bots, ownership)
+	}
+	return bots, nil
+}
+
+// save atomically writes all bot ownership to disk. The caller must hold s.mu.
+func (s *FileBotStore) save() error {
+	data, err := json.Marshal(s.bots)
+	if err != nil {
+		return fmt.Errorf("failed to encode bot store: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write bot store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace bot store: %w", err)
+	}
+	return nil
+}
+
+// botStoreName identifies bot ownership in replication messages
+const botStoreName = "bots"
+
+// SharedBotStore is a BotStore replicated between gateway replicas, so an
+// owner set or a bot shared on one replica is honored on all of them. Each
+// replica keeps its own copy in local, which must not be shared with other
+// replicas. Changes are ordered by UpdatedAt so a replica that restarts with
+// a stale copy does not undo newer ones; a grant removed on another replica
+// may still be honored within the broker's delivery delay.
+type SharedBotStore struct {
+	local       *FileBotStore
+	replication *Replication
+	mu          sync.Mutex
+}
+
+// NewSharedBotStore creates a store that keeps bot ownership in local and
+// shares it through replication
+func NewSharedBotStore(local *FileBotStore, replication *Replication) *SharedBotStore {
+	store := &SharedBotStore{local: local, replication: replication}
+	replication.register(botStoreName, store)
+	return store
+}
+
+// Get returns a bot's ownership
+func (s *SharedBotStore) Get(botID string) (BotOwnership, error) {
+	return s.local.Get(botID)
+}
+
+// Save stores a bot's ownership and sends it to the other replicas
+func (s *SharedBotStore) Save(ownership BotOwnership) error {
+	if err := s.local.Save(ownership); err != nil {
+		return err
+	}
+	return s.replication.send(botStoreName, "save", ownership)
+}
+
+// List returns every bot's ownership
+func (s *SharedBotStore) List() ([]BotOwnership, error) {
+	return s.local.List()
+}
+
+// applyChange applies a change made by another replica, unless this
+// replica holds a newer one for the bot
+func (s *SharedBotStore) applyChange(change StoreChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch change.Op {
+	case "save":
+		var ownership BotOwnership
+		if err := json.Unmarshal(change.Data, &ownership); err != nil {
+			return err
+		}
+		if existing, err := s.local.Get(ownership.BotID); err == nil && !ownership.UpdatedAt.After(existing.UpdatedAt) {
+			return nil
+		}
+		return s.local.Save(ownership)
+	default:
+		return unknownChange(change)
+	}
+}
+
+// snapshot returns the bot ownership this replica holds
+func (s *SharedBotStore) snapshot() []StoreChange {
+	bots, _ := s.local.List()
+	changes := make([]StoreChange, len(bots))
+	for i, ownership := range bots {
+		changes[i] = storeChange("save", ownership)
+	}
+	return changes
+}
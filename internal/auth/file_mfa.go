@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileMFAStore is an MFAStore that keeps all enrollments in a single JSON
+// file, rewritten on every change. The file holds TOTP secrets and must be
+// protected like a credential.
+type FileMFAStore struct {
+	path        string
+	enrollments map[string]MFAEnrollment
+	mu          sync.Mutex
+}
+
+// NewFileMFAStore opens or creates the store at path
+func NewFileMFAStore(path string) (*FileMFAStore, error) {
+	store := &FileMFAStore{
+		path:        path,
+		enrollments: make(map[string]MFAEnrollment),
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create mfa store directory: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to read mfa store: %w", err)
+	default:
+		if err := json.Unmarshal(data, &store.enrollments); err != nil {
+			return nil, fmt.Errorf("failed to decode mfa store: %w", err)
+		}
+	}
+
+	return store, nil
+}
+
+// Get returns a user's enrollment
+func (s *FileMFAStore) Get(userID string) (MFAEnrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, ok := s.enrollments[userID]
+	if !ok {
+		return MFAEnrollment{}, ErrMFANotEnrolled
+	}
+	return enrollment, nil
+}
+
+// Save stores a user's enrollment, replacing any previous one
+func (s *FileMFAStore) Save(enrollment MFAEnrollment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enrollments[enrollment.UserID] = enrollment
+	return s.save()
+}
+
+// Delete removes a user's enrollment
+func (s *FileMFAStore) Delete(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.enrollments[userID]; !ok {
+		return ErrMFANotEnrolled
+	}
+	delete(s.enrollments, userID)
+	return s.save()
+}
+
+// all returns every stored enrollment
+func (s *FileMFAStore) all() []MFAEnrollment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollments := make([]MFAEnrollment, 0, len(s.enrollments))
+	for _, enrollment := range s.enrollments {
+		enrollments = append(enrollments, enrollment)
+	}
+	return enrollments
+}
+
+// save atomically writes all enrollments to disk. The caller must hold s.mu.
+func (s *FileMFAStore) save() error {
+	data, err := json.Marshal(s.enrollments)
+	if err != nil {
+		return fmt.Errorf("failed to encode mfa store: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write mfa store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace mfa store: %w", err)
+	}
+	return nil
+}
+
+// mfaStoreName identifies MFA enrollments in replication messages
+const mfaStoreName = "mfa"
+
+// deletedMFAEnrollment records when a user's enrollment was removed
+type deletedMFAEnrollment struct {
+	UserID    string    `json:"userId"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// SharedMFAStore is an MFAStore replicated between gateway replicas, so a
+// second factor enrolled on one replica is required on all of them, and a
+// code or recovery code used on one cannot be used again on another. Each
+// replica keeps its own copy in local, which must not be shared with other
+// replicas. Changes are ordered by UpdatedAt so a replica that restarts
+// with a stale copy does not undo newer ones; a code used on another replica
+// may still be accepted within the broker's delivery delay.
+type SharedMFAStore struct {
+	local       *FileMFAStore
+	replication *Replication
+	deleted     map[string]time.Time
+	mu          sync.Mutex
+}
+
+// NewSharedMFAStore creates a store that keeps enrollments in local and
+// shares them through replication
+func NewSharedMFAStore(local *FileMFAStore, replication *Replication) *SharedMFAStore {
+	store := &SharedMFAStore{
+		local:       local,
+		replication: replication,
+		deleted:     make(map[string]time.Time),
+	}
+	replication.register(mfaStoreName, store)
+	return store
+}
+
+// Get returns a user's enrollment
+func (s *SharedMFAStore) Get(userID string) (MFAEnrollment, error) {
+	return s.local.Get(userID)
+}
+
+// Save stores a user's enrollment and sends it to the other replicas
+func (s *SharedMFAStore) Save(enrollment MFAEnrollment) error {
+	enrollment.UpdatedAt = time.Now().UTC()
+	if err := s.local.Save(enrollment); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.deleted, enrollment.UserID)
+	s.mu.Unlock()
+	return s.replication.send(mfaStoreName, "save", enrollment)
+}
+
+// Delete removes a user's enrollment on every replica
+func (s *SharedMFAStore) Delete(userID string) error {
+	if err := s.local.Delete(userID); err != nil {
+		return err
+	}
+
+	deleted := deletedMFAEnrollment{UserID: userID, DeletedAt: time.Now().UTC()}
+	s.mu.Lock()
+	s.deleted[userID] = deleted.DeletedAt
+	s.mu.Unlock()
+	return s.replication.send(mfaStoreName, "delete", deleted)
+}
+
+// applyChange applies a change made by another replica, unless this
+// replica holds a newer one for the user
+func (s *SharedMFAStore) applyChange(change StoreChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch change.Op {
+	case "save":
+		var enrollment MFAEnrollment
+		if err := json.Unmarshal(change.Data, &enrollment); err != nil {
+			return err
+		}
+		if !s.newer(enrollment.UserID, enrollment.UpdatedAt) {
+			return nil
+		}
+		delete(s.deleted, enrollment.UserID)
+		return s.local.Save(enrollment)
+	case "delete":
+		var deleted deletedMFAEnrollment
+		if err := json.Unmarshal(change.Data, &deleted); err != nil {
+			return err
+		}
+		if !s.newer(deleted.UserID, deleted.DeletedAt) {
+			return nil
+		}
+		s.deleted[deleted.UserID] = deleted.DeletedAt
+		if err := s.local.Delete(deleted.UserID); err != nil && !errors.Is(err, ErrMFANotEnrolled) {
+			return err
+		}
+	default:
+		return unknownChange(change)
+	}
+	return nil
+}
+
+// newer reports whether a change to a user's enrollment made at changedAt
+// is newer than what this replica holds. The caller must hold s.mu.
+func (s *SharedMFAStore) newer(userID string, changedAt time.Time) bool {
+	if deletedAt, ok := s.deleted[userID]; ok && !changedAt.After(deletedAt) {
+		return false
+	}
+	enrollment, err := s.local.Get(userID)
+	return err != nil || changedAt.After(enrollment.UpdatedAt)
+}
+
+// snapshot returns the enrollments this replica holds and the ones it
+// knows were removed
+func (s *SharedMFAStore) snapshot() []StoreChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes := make([]StoreChange, 0, len(s.deleted))
+	for userID, deletedAt := range s.deleted {
+		changes = append(changes, storeChange("delete", deletedMFAEnrollment{UserID: userID, DeletedAt: deletedAt}))
+	}
+	for _, enrollment := range s.local.all() {
+		changes = append(changes, storeChange("save", enrollment))
+	}
+	return changes
+}
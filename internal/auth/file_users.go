@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// usersFile is the layout of a users file
+type usersFile struct {
+	Users []User `json:"users" yaml:"users"`
+}
+
+// FileUserStore is a UserStore loaded from a YAML or JSON users file. The
+// file is checked for changes every reload interval; a file that fails to
+// load leaves the previous users in place.
+type FileUserStore struct {
+	path    string
+	logger  *logrus.Entry
+	users   userIndex
+	modTime time.Time
+	mu      sync.RWMutex
+	done    chan struct{}
+}
+
+// NewFileUserStore loads users from path and watches it for changes
+func NewFileUserStore(path string, reloadInterval time.Duration, logger *logrus.Entry) (*FileUserStore, error) {
+	store := &FileUserStore{
+		path:   path,
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	if reloadInterval > 0 {
+		go store.watch(reloadInterval)
+	}
+	return store, nil
+}
+
+// GetUser returns the user with the given username
+func (s *FileUserStore) GetUser(username string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users.byName(username)
+}
+
+// GetUserByID returns the user with the given id
+func (s *FileUserStore) GetUserByID(id string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users.byID(id)
+}
+
+// Close stops watching the users file
+func (s *FileUserStore) Close() {
+	close(s.done)
+}
+
+// load reads and validates the users file
+func (s *FileUserStore) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat users file: %w", err)
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read users file: %w", err)
+	}
+
+	var file usersFile
+	switch strings.ToLower(filepath.Ext(s.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &file)
+	default:
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse users file: %w", err)
+	}
+
+	users, err := indexUsers(file.Users)
+	if err != nil {
+		return fmt.Errorf("invalid users file: %w", err)
+	}
+
+	s.mu.Lock()
+	s.users = users
+	s.modTime = info.ModTime()
+	s.mu.Unlock()
+	return nil
+}
+
+// watch reloads the users file whenever its modification time changes
+func (s *FileUserStore) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				s.logger.Warnf("Failed to check users file: %v", err)
+				continue
+			}
+
+			// Remember the new version even if it fails to load, so a bad
+			// file is reported once rather than on every tick
+			s.mu.Lock()
+			changed := !info.ModTime().Equal(s.modTime)
+			s.modTime = info.ModTime()
+			s.mu.Unlock()
+			if !changed {
+				continue
+			}
+
+			if err := s.load(); err != nil {
+				s.logger.Errorf("Failed to reload users file, keeping previous users: %v", err)
+				continue
+			}
+			s.logger.Infof("Reloaded users from %s", s.path)
+
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// discardLogger returns a logger that writes nowhere
+func discardLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logrus.NewEntry(logger)
+}
+
+// writeFile writes data to path, moving its modification time forward so
+// reloads notice the change
+func writeFile(t *testing.T, path, data string, modTime time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to set time of %s: %v", path, err)
+	}
+}
+
+func TestFileUserStoreFormats(t *testing.T) {
+	hash := testPasswordHash(t)
+
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{"yaml", "users.yaml", "users:\n  - id: u-alice\n    username: alice\n    passwordHash: \"" + hash + "\"\n    roles: [user]\n"},
+		{"yml", "users.yml", "users:\n  - id: u-alice\n    username: alice\n    passwordHash: \"" + hash + "\"\n"},
+		{"json", "users.json", `{"users": [{"id": "u-alice", "username": "alice", "passwordHash": "` + hash + `"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			writeFile(t, path, tt.data, time.Now())
+
+			store, err := NewFileUserStore(path, 0, discardLogger())
+			if err != nil {
+				t.Fatalf("NewFileUserStore: %v", err)
+			}
+			defer store.Close()
+
+			if _, err := Authenticate(store, "alice", "s3cret"); err != nil {
+				t.Errorf("Authenticate: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileUserStoreRejectsInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	writeFile(t, path, "users:\n  - id: u-alice\n    username: alice\n    passwordHash: plaintext\n", time.Now())
+
+	if _, err := NewFileUserStore(path, 0, discardLogger()); err == nil {
+		t.Fatal("NewFileUserStore accepted a plaintext password")
+	}
+}
+
+func TestFileUserStoreReloads(t *testing.T) {
+	hash := testPasswordHash(t)
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	start := time.Now().Add(-time.Hour)
+	writeFile(t, path, "users:\n  - id: u-alice\n    username: alice\n    passwordHash: \""+hash+"\"\n", start)
+
+	store, err := NewFileUserStore(path, 10*time.Millisecond, discardLogger())
+	if err != nil {
+		t.Fatalf("NewFileUserStore: %v", err)
+	}
+	defer store.Close()
+
+	// A change that fails to load keeps the previous users
+	writeFile(t, path, "users: [", start.Add(time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	if _, err := store.GetUser("alice"); err != nil {
+		t.Fatalf("GetUser(alice) after bad reload: %v", err)
+	}
+
+	writeFile(t, path, "users:\n  - id: u-bob\n    username: bob\n    passwordHash: \""+hash+"\"\n", start.Add(2*time.Minute))
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := store.GetUser("bob"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("users file not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := store.GetUser("alice"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUser(alice) after reload error = %v, want ErrUserNotFound", err)
+	}
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeyFile is a PEM signing key. The private key signs tokens while the key
+// is active; a key given only as a public key can still verify tokens. Keys
+// stop verifying at RetiresAt, if set.
+type KeyFile struct {
+	ID        string
+	Path      string
+	RetiresAt time.Time
+}
+
+// signingKey is a loaded asymmetric key
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	public    crypto.PublicKey
+	retiresAt time.Time
+}
+
+// KeySet signs and verifies gateway tokens. An asymmetric set holds several
+// keys identified by kid so keys can be rotated: the active key signs new
+// tokens while older keys keep verifying until they retire. An HMAC set
+// signs with a shared secret and is meant for development.
+type KeySet struct {
+	active     *signingKey
+	keys       map[string]*signingKey
+	hmacSecret []byte
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewHMACKeySet creates a key set that signs with HS256 and a shared secret
+func NewHMACKeySet(secret []byte) *KeySet {
+	return &KeySet{hmacSecret: secret}
+}
+
+// LoadKeySet loads RSA (RS256) and ECDSA P-256 (ES256) keys from PEM files.
+// activeID names the key used for signing, which must include its private key.
+func LoadKeySet(activeID string, files []KeyFile) (*KeySet, error) {
+	set := &KeySet{keys: make(map[string]*signingKey, len(files))}
+
+	for _, file := range files {
+		if file.ID == "" {
+			return nil, errors.New("signing key id is required")
+		}
+		if _, exists := set.keys[file.ID]; exists {
+			return nil, fmt.Errorf("duplicate signing key id: %s", file.ID)
+		}
+
+		key, err := loadKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", file.ID, err)
+		}
+		set.keys[file.ID] = key
+	}
+
+	active, ok := set.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q not configured", activeID)
+	}
+	if active.private == nil {
+		return nil, fmt.Errorf("active signing key %s has no private key", activeID)
+	}
+	if active.retired(time.Now()) {
+		return nil, fmt.Errorf("active signing key %s is retired", activeID)
+	}
+	set.active = active
+
+	return set, nil
+}
+
+// Sign signs claims with the active key, naming it in the kid header
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if s.active == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.hmacSecret)
+	}
+
+	token := jwt.NewWithClaims(s.active.method, claims)
+	token.Header["kid"] = s.active.id
+	return token.SignedString(s.active.private)
+}
+
+// Keyfunc returns the verification key for a token, for use with jwt.Parse.
+// The token's algorithm must match its key, and retired keys are rejected.
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if s.active == nil {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+		}
+		return s.hmacSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	if key.retired(time.Now()) {
+		return nil, fmt.Errorf("signing key %s is retired", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", token.Method.Alg(), kid)
+	}
+	return key.public, nil
+}
+
+// JWKS returns the public keys that can still verify tokens
+func (s *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+
+	for _, key := range s.keys {
+		if key.retired(now) {
+			continue
+		}
+
+		jwk := JWK{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size)))
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// retired reports whether the key may no longer verify tokens
+func (k *signingKey) retired(now time.Time) bool {
+	return !k.retiresAt.IsZero() && !now.Before(k.retiresAt)
+}
+
+// loadKey reads a private or public key from a PEM file
+func loadKey(file KeyFile) (*signingKey, error) {
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	key := &signingKey{id: file.ID, retiresAt: file.RetiresAt}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.private = signer
+		key.public = signer.Public()
+	} else {
+		key.public = parsed
+	}
+
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		key.method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		if public.Curve != elliptic.P256() {
+			return nil, errors.New("ECDSA keys must use the P-256 curve")
+		}
+		key.method = jwt.SigningMethodES256
+	default:
+		return nil, errors.New("unsupported key type, expected RSA or ECDSA P-256")
+	}
+	return key, nil
+}
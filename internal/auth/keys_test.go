@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKeyFile writes key as a PEM file of the given block type and returns its path
+func writeKeyFile(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return path
+}
+
+// testKeyFiles generates an RSA and an ECDSA key and returns their private
+// key files and the public key file of the ECDSA key
+func testKeyFiles(t *testing.T) (rsaPath, ecPath, ecPublicPath string) {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	ecPublicDER, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	return writeKeyFile(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+		writeKeyFile(t, "ec.pem", "EC PRIVATE KEY", ecDER),
+		writeKeyFile(t, "ec.pub.pem", "PUBLIC KEY", ecPublicDER)
+}
+
+// testClaims returns claims for a token valid for an hour
+func testClaims() *Claims {
+	return &Claims{
+		UserID: "u-alice",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func TestKeySetSignsWithActiveKey(t *testing.T) {
+	rsaPath, ecPath, _ := testKeyFiles(t)
+
+	tests := []struct {
+		active string
+		alg    string
+	}{
+		{"rsa", "RS256"},
+		{"ec", "ES256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.active, func(t *testing.T) {
+			keys, err := LoadKeySet(tt.active, []KeyFile{{ID: "rsa", Path: rsaPath}, {ID: "ec", Path: ecPath}})
+			if err != nil {
+				t.Fatalf("LoadKeySet: %v", err)
+			}
+
+			signed, err := keys.Sign(testClaims())
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			token, err := jwt.ParseWithClaims(signed, &Claims{}, keys.Keyfunc)
+			if err != nil {
+				t.Fatalf("token does not verify: %v", err)
+			}
+			if token.Header["kid"] != tt.active || token.Method.Alg() != tt.alg {
+				t.Errorf("token signed by %v with %s, want %s with %s", token.Header["kid"], token.Method.Alg(), tt.active, tt.alg)
+			}
+		})
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	rsaPath, ecPath, ecPublicPath := testKeyFiles(t)
+
+	old, err := LoadKeySet("ec", []KeyFile{{ID: "ec", Path: ecPath}})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	signed, _ := old.Sign(testClaims())
+
+	// The old key keeps verifying from its public key until it retires
+	rotated, err := LoadKeySet("rsa", []KeyFile{
+		{ID: "rsa", Path: rsaPath},
+		{ID: "ec", Path: ecPublicPath, RetiresAt: time.Now().Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	if _, err := jwt.ParseWithClaims(signed, &Claims{}, rotated.Keyfunc); err != nil {
+		t.Errorf("token of the previous key rejected before it retired: %v", err)
+	}
+	if jwks := rotated.JWKS(); len(jwks.Keys) != 2 {
+		t.Errorf("JWKS has %d keys, want 2", len(jwks.Keys))
+	}
+
+	retired, err := LoadKeySet("rsa", []KeyFile{
+		{ID: "rsa", Path: rsaPath},
+		{ID: "ec", Path: ecPublicPath, RetiresAt: time.Now().Add(-time.Second)},
+	})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	if _, err := jwt.ParseWithClaims(signed, &Claims{}, retired.Keyfunc); err == nil {
+		t.Error("token of a retired key accepted")
+	}
+	if jwks := retired.JWKS(); len(jwks.Keys) != 1 || jwks.Keys[0].Kid != "rsa" {
+		t.Errorf("JWKS = %+v, want only the rsa key", jwks.Keys)
+	}
+}
+
+func TestKeySetRejectsForgedTokens(t *testing.T) {
+	rsaPath, _, _ := testKeyFiles(t)
+	keys, err := LoadKeySet("rsa", []KeyFile{{ID: "rsa", Path: rsaPath}})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	hmacToken.Header["kid"] = "rsa"
+	unknownKid := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	unknownKid.Header["kid"] = "other"
+
+	tests := []struct {
+		name  string
+		token *jwt.Token
+	}{
+		{"HS256 under an RSA kid", hmacToken},
+		{"unknown kid", unknownKid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, _ := tt.token.SignedString([]byte("guess"))
+			if _, err := jwt.ParseWithClaims(signed, &Claims{}, keys.Keyfunc); err == nil {
+				t.Error("forged token accepted")
+			}
+		})
+	}
+}
+
+func TestLoadKeySetValidation(t *testing.T) {
+	rsaPath, ecPath, ecPublicPath := testKeyFiles(t)
+
+	smallKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	smallPath := writeKeyFile(t, "small.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(smallKey))
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p384DER, _ := x509.MarshalECPrivateKey(p384Key)
+	p384Path := writeKeyFile(t, "p384.pem", "EC PRIVATE KEY", p384DER)
+
+	tests := []struct {
+		name   string
+		active string
+		files  []KeyFile
+	}{
+		{"active key missing", "other", []KeyFile{{ID: "rsa", Path: rsaPath}}},
+		{"active key public only", "ec", []KeyFile{{ID: "ec", Path: ecPublicPath}}},
+		{"active key retired", "rsa", []KeyFile{{ID: "rsa", Path: rsaPath, RetiresAt: time.Now().Add(-time.Second)}}},
+		{"duplicate id", "rsa", []KeyFile{{ID: "rsa", Path: rsaPath}, {ID: "rsa", Path: ecPath}}},
+		{"missing id", "rsa", []KeyFile{{Path: rsaPath}}},
+		{"short RSA key", "small", []KeyFile{{ID: "small", Path: smallPath}}},
+		{"P-384 key", "p384", []KeyFile{{ID: "p384", Path: p384Path}}},
+		{"missing file", "rsa", []KeyFile{{ID: "rsa", Path: filepath.Join(t.TempDir(), "missing.pem")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadKeySet(tt.active, tt.files); err == nil {
+				t.Error("LoadKeySet accepted an invalid configuration")
+			}
+		})
+	}
+}
+
+func TestHMACKeySetRejectsOtherAlgorithms(t *testing.T) {
+	rsaPath, _, _ := testKeyFiles(t)
+	rsaKeys, _ := LoadKeySet("rsa", []KeyFile{{ID: "rsa", Path: rsaPath}})
+	signed, _ := rsaKeys.Sign(testClaims())
+
+	if _, err := jwt.ParseWithClaims(signed, &Claims{}, NewHMACKeySet([]byte("secret")).Keyfunc); err == nil {
+		t.Error("HMAC key set accepted an RS256 token")
+	}
+}
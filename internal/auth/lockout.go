@@ -0,0 +1,418 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned when a login attempt is refused before its credentials
+// are checked
+var (
+	ErrLoginLocked    = errors.New("login temporarily locked")
+	ErrLoginThrottled = errors.New("login attempted too soon after a failure")
+)
+
+// LoginAttempts tracks recent failed logins for one username or client
+// address. Each failure of a username delays its next attempt further, and
+// MaxFailures within the window lock the key until LockedUntil.
+type LoginAttempts struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"lastFailure"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+// Locked reports whether the key is locked at now
+func (a LoginAttempts) Locked(now time.Time) bool {
+	return now.Before(a.LockedUntil)
+}
+
+// LoginAttemptStore persists failed login attempts by key
+type LoginAttemptStore interface {
+	// Get returns the attempts for a key, or a record with no failures
+	Get(key string) (LoginAttempts, error)
+	// Save stores the attempts for a key until expiresAt
+	Save(attempts LoginAttempts, expiresAt time.Time) error
+	// Delete forgets the attempts for a key
+	Delete(key string) error
+	// List returns every tracked key
+	List() ([]LoginAttempts, error)
+}
+
+// LockoutPolicy locks a key for LockoutDuration after MaxFailures failed
+// logins. A zero MaxFailures never locks.
+type LockoutPolicy struct {
+	MaxFailures     int
+	LockoutDuration time.Duration
+}
+
+// LoginThrottleConfig controls how failed logins are throttled. Failures
+// older than Window are forgotten. After each failure the username's next
+// attempt must wait BaseDelay, doubling per failure up to MaxDelay. Client
+// addresses are only locked out, so users sharing an address are not slowed
+// down by each other's mistakes.
+type LoginThrottleConfig struct {
+	Window    time.Duration
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Username  LockoutPolicy
+	IP        LockoutPolicy
+}
+
+// LoginThrottle tracks failed logins per username and per client address
+// and refuses attempts that come too soon or while locked out. Updates are
+// serialized within one gateway replica only; a shared store sends them to
+// the others.
+type LoginThrottle struct {
+	store  LoginAttemptStore
+	config LoginThrottleConfig
+	mu     sync.Mutex
+}
+
+// NewLoginThrottle creates a login throttle backed by store
+func NewLoginThrottle(store LoginAttemptStore, config LoginThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{store: store, config: config}
+}
+
+// Allow checks whether a login for username from clientIP may be attempted
+// now. When it may not, it returns how long to wait and ErrLoginLocked or
+// ErrLoginThrottled. An allowed attempt holds back the next one by the
+// current delay, so parallel guesses do not all get through.
+func (t *LoginThrottle) Allow(username, clientIP string) (time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now().UTC()
+	records := make([]LoginAttempts, 0, 2)
+	for _, key := range []string{userAttemptKey(username), ipAttemptKey(clientIP)} {
+		attempts, err := t.current(key, now)
+		if err != nil {
+			return 0, err
+		}
+		if attempts.Locked(now) {
+			return attempts.LockedUntil.Sub(now), ErrLoginLocked
+		}
+		if now.Before(attempts.NextAttempt) {
+			return attempts.NextAttempt.Sub(now), ErrLoginThrottled
+		}
+		records = append(records, attempts)
+	}
+
+	for _, attempts := range records {
+		if attempts.NextAttempt.IsZero() {
+			continue
+		}
+		attempts.NextAttempt = now.Add(t.delay(attempts.Failures))
+		if err := t.store.Save(attempts, t.expiresAt(attempts)); err != nil {
+			return 0, fmt.Errorf("failed to store login attempts: %w", err)
+		}
+	}
+	return 0, nil
+}
+
+// Failure records a failed login for username from clientIP and returns the
+// updated attempts for both, which are locked once they reach their policy's
+// MaxFailures
+func (t *LoginThrottle) Failure(username, clientIP string) (LoginAttempts, LoginAttempts, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	user, err := t.fail(userAttemptKey(username), t.config.Username, true)
+	if err != nil {
+		return LoginAttempts{}, LoginAttempts{}, err
+	}
+	ip, err := t.fail(ipAttemptKey(clientIP), t.config.IP, false)
+	if err != nil {
+		return LoginAttempts{}, LoginAttempts{}, err
+	}
+	return user, ip, nil
+}
+
+// Success forgets the failed logins for username. Failures from the client
+// address are kept, so logging in to one account does not reset guessing
+// at others.
+func (t *LoginThrottle) Success(username string) error {
+	return t.UnlockUser(username)
+}
+
+// UnlockUser clears a username's failed logins and lockout
+func (t *LoginThrottle) UnlockUser(username string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.store.Delete(userAttemptKey(username))
+}
+
+// UnlockIP clears a client address's failed logins and lockout
+func (t *LoginThrottle) UnlockIP(clientIP string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.store.Delete(ipAttemptKey(clientIP))
+}
+
+// Lockouts returns the usernames and addresses that are currently locked
+func (t *LoginThrottle) Lockouts() ([]LoginAttempts, error) {
+	all, err := t.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	locked := make([]LoginAttempts, 0)
+	for _, attempts := range all {
+		if attempts.Locked(now) {
+			locked = append(locked, attempts)
+		}
+	}
+	sort.Slice(locked, func(i, j int) bool { return locked[i].Key < locked[j].Key })
+	return locked, nil
+}
+
+// fail adds a failure to key, delaying its next attempt if delayed and
+// locking it when policy's threshold is reached. The caller must hold t.mu.
+func (t *LoginThrottle) fail(key string, policy LockoutPolicy, delayed bool) (LoginAttempts, error) {
+	now := time.Now().UTC()
+	attempts, err := t.current(key, now)
+	if err != nil {
+		return LoginAttempts{}, err
+	}
+
+	attempts.Failures++
+	attempts.LastFailure = now
+	if delayed {
+		attempts.NextAttempt = now.Add(t.delay(attempts.Failures))
+	}
+	if policy.MaxFailures > 0 && attempts.Failures >= policy.MaxFailures {
+		attempts.LockedUntil = now.Add(policy.LockoutDuration)
+	}
+
+	if err := t.store.Save(attempts, t.expiresAt(attempts)); err != nil {
+		return LoginAttempts{}, fmt.Errorf("failed to store login attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+// current returns the live attempts for key, starting over once failures
+// have aged out of the window or a lockout has ended. The caller must hold
+// t.mu.
+func (t *LoginThrottle) current(key string, now time.Time) (LoginAttempts, error) {
+	attempts, err := t.store.Get(key)
+	if err != nil {
+		return LoginAttempts{}, fmt.Errorf("failed to load login attempts: %w", err)
+	}
+
+	lockoutEnded := !attempts.LockedUntil.IsZero() && !attempts.Locked(now)
+	if lockoutEnded || now.Sub(attempts.LastFailure) > t.config.Window {
+		return LoginAttempts{Key: key}, nil
+	}
+	return attempts, nil
+}
+
+// delay returns how long to wait after the given number of failures
+func (t *LoginThrottle) delay(failures int) time.Duration {
+	if failures <= 0 || t.config.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := t.config.BaseDelay
+	for i := 1; i < failures && delay < t.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.config.MaxDelay {
+		delay = t.config.MaxDelay
+	}
+	return delay
+}
+
+// expiresAt returns when a record no longer affects logins
+func (t *LoginThrottle) expiresAt(attempts LoginAttempts) time.Time {
+	expiresAt := attempts.LastFailure.Add(t.config.Window)
+	for _, at := range []time.Time{attempts.NextAttempt, attempts.LockedUntil} {
+		if at.After(expiresAt) {
+			expiresAt = at
+		}
+	}
+	return expiresAt
+}
+
+// userAttemptKey returns the store key for a username
+func userAttemptKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// ipAttemptKey returns the store key for a client address
+func ipAttemptKey(clientIP string) string {
+	return "ip:" + clientIP
+}
+
+// MemoryLoginAttemptStore keeps failed login attempts in process memory
+type MemoryLoginAttemptStore struct {
+	mu        sync.Mutex
+	attempts  map[string]LoginAttempts
+	expires   map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryLoginAttemptStore creates an empty store
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		attempts:  make(map[string]LoginAttempts),
+		expires:   make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get returns the attempts for a key, or a record with no failures
+func (s *MemoryLoginAttemptStore) Get(key string) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempts, ok := s.attempts[key]; ok && time.Now().Before(s.expires[key]) {
+		return attempts, nil
+	}
+	return LoginAttempts{Key: key}, nil
+}
+
+// Save stores the attempts for a key until expiresAt
+func (s *MemoryLoginAttemptStore) Save(attempts LoginAttempts, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts[attempts.Key] = attempts
+	s.expires[attempts.Key] = expiresAt
+
+	if now := time.Now(); now.Sub(s.lastSweep) > sweepInterval {
+		for key, exp := range s.expires {
+			if !exp.After(now) {
+				delete(s.attempts, key)
+				delete(s.expires, key)
+			}
+		}
+		s.lastSweep = now
+	}
+	return nil
+}
+
+// Delete forgets the attempts for a key
+func (s *MemoryLoginAttemptStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	delete(s.expires, key)
+	return nil
+}
+
+// List returns every tracked key that has not expired
+func (s *MemoryLoginAttemptStore) List() ([]LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	result := make([]LoginAttempts, 0, len(s.attempts))
+	for key, attempts := range s.attempts {
+		if now.Before(s.expires[key]) {
+			result = append(result, attempts)
+		}
+	}
+	return result, nil
+}
+
+// all returns every tracked key that has not expired with its expiry
+func (s *MemoryLoginAttemptStore) all() []savedAttempts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	result := make([]savedAttempts, 0, len(s.attempts))
+	for key, attempts := range s.attempts {
+		if exp := s.expires[key]; now.Before(exp) {
+			result = append(result, savedAttempts{Attempts: attempts, ExpiresAt: exp})
+		}
+	}
+	return result
+}
+
+// loginAttemptStoreName identifies login attempts in replication messages
+const loginAttemptStoreName = "login_attempts"
+
+// savedAttempts is a saved record sent to other replicas
+type savedAttempts struct {
+	Attempts  LoginAttempts `json:"attempts"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+}
+
+// SharedLoginAttemptStore is a LoginAttemptStore replicated between gateway
+// replicas, so failures count towards one lockout whichever replica they
+// reach and an unlock applies everywhere. Failures on two replicas within
+// the broker's delivery delay may be counted once.
+type SharedLoginAttemptStore struct {
+	local       *MemoryLoginAttemptStore
+	replication *Replication
+}
+
+// NewSharedLoginAttemptStore creates a store that shares login attempts
+// through replication
+func NewSharedLoginAttemptStore(replication *Replication) *SharedLoginAttemptStore {
+	store := &SharedLoginAttemptStore{local: NewMemoryLoginAttemptStore(), replication: replication}
+	replication.register(loginAttemptStoreName, store)
+	return store
+}
+
+// Get returns the attempts for a key, or a record with no failures
+func (s *SharedLoginAttemptStore) Get(key string) (LoginAttempts, error) {
+	return s.local.Get(key)
+}
+
+// Save stores the attempts for a key until expiresAt on every replica
+func (s *SharedLoginAttemptStore) Save(attempts LoginAttempts, expiresAt time.Time) error {
+	s.local.Save(attempts, expiresAt)
+	return s.replication.send(loginAttemptStoreName, "save", savedAttempts{Attempts: attempts, ExpiresAt: expiresAt})
+}
+
+// Delete forgets the attempts for a key on every replica
+func (s *SharedLoginAttemptStore) Delete(key string) error {
+	s.local.Delete(key)
+	return s.replication.send(loginAttemptStoreName, "delete", key)
+}
+
+// List returns every tracked key
+func (s *SharedLoginAttemptStore) List() ([]LoginAttempts, error) {
+	return s.local.List()
+}
+
+// applyChange applies a change made by another replica
+func (s *SharedLoginAttemptStore) applyChange(change StoreChange) error {
+	switch change.Op {
+	case "save":
+		var saved savedAttempts
+		if err := json.Unmarshal(change.Data, &saved); err != nil {
+			return err
+		}
+		s.local.Save(saved.Attempts, saved.ExpiresAt)
+	case "delete":
+		var key string
+		if err := json.Unmarshal(change.Data, &key); err != nil {
+			return err
+		}
+		s.local.Delete(key)
+	default:
+		return unknownChange(change)
+	}
+	return nil
+}
+
+// snapshot returns the attempts this replica tracks
+func (s *SharedLoginAttemptStore) snapshot() []StoreChange {
+	saved := s.local.all()
+	changes := make([]StoreChange, len(saved))
+	for i, record := range saved {
+		changes[i] = storeChange("save", record)
+	}
+	return changes
+}
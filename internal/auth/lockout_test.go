@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testThrottleConfig locks a username after three failures and an address
+// after five, without delays between attempts
+var testThrottleConfig = LoginThrottleConfig{
+	Window:   time.Minute,
+	Username: LockoutPolicy{MaxFailures: 3, LockoutDuration: time.Minute},
+	IP:       LockoutPolicy{MaxFailures: 5, LockoutDuration: time.Minute},
+}
+
+func TestLoginThrottleLocksUsername(t *testing.T) {
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptStore(), testThrottleConfig)
+
+	for i := 0; i < 3; i++ {
+		if _, err := throttle.Allow("alice", "192.0.2.1"); err != nil {
+			t.Fatalf("attempt %d refused: %v", i+1, err)
+		}
+		throttle.Failure("alice", "192.0.2.1")
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		clientIP string
+		want     error
+	}{
+		{"locked username", "alice", "192.0.2.1", ErrLoginLocked},
+		{"locked username from another address", "Alice ", "192.0.2.2", ErrLoginLocked},
+		{"other username", "bob", "192.0.2.1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, err := throttle.Allow(tt.username, tt.clientIP)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Allow() error = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil && (wait <= 0 || wait > time.Minute) {
+				t.Errorf("Allow() wait = %v", wait)
+			}
+		})
+	}
+
+	if err := throttle.UnlockUser("alice"); err != nil {
+		t.Fatalf("UnlockUser: %v", err)
+	}
+	if _, err := throttle.Allow("alice", "192.0.2.1"); err != nil {
+		t.Errorf("Allow() after unlock error = %v", err)
+	}
+}
+
+func TestLoginThrottleLocksAddress(t *testing.T) {
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptStore(), testThrottleConfig)
+
+	// Guessing at many usernames locks the address, not the usernames
+	for _, username := range []string{"a", "b", "c", "d", "e"} {
+		throttle.Failure(username, "192.0.2.1")
+	}
+
+	if _, err := throttle.Allow("f", "192.0.2.1"); !errors.Is(err, ErrLoginLocked) {
+		t.Errorf("Allow() from locked address error = %v, want %v", err, ErrLoginLocked)
+	}
+	if _, err := throttle.Allow("a", "192.0.2.2"); err != nil {
+		t.Errorf("Allow() from another address error = %v", err)
+	}
+
+	lockouts, err := throttle.Lockouts()
+	if err != nil {
+		t.Fatalf("Lockouts: %v", err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Key != "ip:192.0.2.1" {
+		t.Errorf("Lockouts() = %v, want ip:192.0.2.1", lockouts)
+	}
+
+	// A successful login does not clear the address's failures
+	throttle.Success("f")
+	if _, err := throttle.Allow("f", "192.0.2.1"); !errors.Is(err, ErrLoginLocked) {
+		t.Errorf("Allow() after another user's login error = %v, want %v", err, ErrLoginLocked)
+	}
+}
+
+func TestLoginThrottleDelaysAfterFailure(t *testing.T) {
+	config := testThrottleConfig
+	config.BaseDelay = time.Hour
+	config.MaxDelay = time.Hour
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptStore(), config)
+
+	throttle.Failure("alice", "192.0.2.1")
+	if _, err := throttle.Allow("alice", "192.0.2.1"); !errors.Is(err, ErrLoginThrottled) {
+		t.Errorf("Allow() right after a failure error = %v, want %v", err, ErrLoginThrottled)
+	}
+
+	// Addresses are not delayed, so users behind one are not slowed down
+	if _, err := throttle.Allow("bob", "192.0.2.1"); err != nil {
+		t.Errorf("Allow() of another user error = %v", err)
+	}
+}
+
+func TestLoginThrottleDelayDoubles(t *testing.T) {
+	throttle := NewLoginThrottle(nil, LoginThrottleConfig{BaseDelay: time.Second, MaxDelay: 5 * time.Second})
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := throttle.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestSharedLoginAttemptStoreAcrossReplicas(t *testing.T) {
+	broker := &testBroker{}
+	onA := NewLoginThrottle(NewSharedLoginAttemptStore(broker.join("a")), testThrottleConfig)
+	onB := NewLoginThrottle(NewSharedLoginAttemptStore(broker.join("b")), testThrottleConfig)
+
+	// Failures count towards one lockout whichever replica they reach
+	onA.Failure("alice", "192.0.2.1")
+	onB.Failure("alice", "192.0.2.2")
+	onA.Failure("alice", "192.0.2.3")
+
+	for name, throttle := range map[string]*LoginThrottle{"a": onA, "b": onB} {
+		if _, err := throttle.Allow("alice", "192.0.2.4"); !errors.Is(err, ErrLoginLocked) {
+			t.Errorf("replica %s: Allow() error = %v, want %v", name, err, ErrLoginLocked)
+		}
+	}
+
+	// and unlocking on one replica unlocks everywhere
+	if err := onB.UnlockUser("alice"); err != nil {
+		t.Fatalf("UnlockUser: %v", err)
+	}
+	for name, throttle := range map[string]*LoginThrottle{"a": onA, "b": onB} {
+		if _, err := throttle.Allow("alice", "192.0.2.4"); err != nil {
+			t.Errorf("replica %s: Allow() after unlock error = %v", name, err)
+		}
+	}
+}
+
+func TestSharedLoginAttemptStoreSyncsNewReplica(t *testing.T) {
+	broker := &testBroker{}
+	onA := NewLoginThrottle(NewSharedLoginAttemptStore(broker.join("a")), testThrottleConfig)
+	for i := 0; i < 3; i++ {
+		onA.Failure("alice", "192.0.2.1")
+	}
+
+	late := broker.join("b")
+	onB := NewLoginThrottle(NewSharedLoginAttemptStore(late), testThrottleConfig)
+	if err := late.RequestSync(); err != nil {
+		t.Fatalf("RequestSync: %v", err)
+	}
+	if _, err := onB.Allow("alice", "192.0.2.1"); !errors.Is(err, ErrLoginLocked) {
+		t.Errorf("new replica: Allow() error = %v, want %v", err, ErrLoginLocked)
+	}
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"cryptobot-api-gateway/internal/offline"
+)
+
+// offlineStoreName identifies offline events in replication messages
+const offlineStoreName = "offline_events"
+
+// queuedOfflineEvent is an event queued for a user, sent to replicas that
+// start so they can deliver it too
+type queuedOfflineEvent struct {
+	UserID string        `json:"userId"`
+	Event  offline.Event `json:"event"`
+}
+
+// readOfflineEvents lists events a user read
+type readOfflineEvents struct {
+	UserID string    `json:"userId"`
+	IDs    []string  `json:"ids"`
+	ReadAt time.Time `json:"readAt"`
+}
+
+// SharedOfflineStore is an offline.Store whose read marks are replicated
+// between gateway replicas. Every replica receives each broker event and
+// queues it under the same ID, so an event read on one replica is removed
+// from all of them. Each replica keeps its own queue in local, which must
+// not be shared with other replicas; a replica that starts is sent the
+// events the others hold. Read events are remembered for ttl, the longest
+// an event stays queued, so a stale copy or an event delivered late by the
+// broker is not queued again.
+type SharedOfflineStore struct {
+	local       *offline.FileStore
+	ttl         time.Duration
+	replication *Replication
+	read        map[string]readOfflineEvents // readKey -> read mark
+	mu          sync.Mutex
+}
+
+// NewSharedOfflineStore creates a store that keeps offline events in local
+// and shares read marks through replication
+func NewSharedOfflineStore(local *offline.FileStore, ttl time.Duration, replication *Replication) *SharedOfflineStore {
+	store := &SharedOfflineStore{
+		local:       local,
+		ttl:         ttl,
+		replication: replication,
+		read:        make(map[string]readOfflineEvents),
+	}
+	replication.register(offlineStoreName, store)
+	return store
+}
+
+// Append stores an event for a user unless it is queued or already read
+func (s *SharedOfflineStore) Append(userID, eventType string, data json.RawMessage) (offline.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := offline.EventID(userID, eventType, data)
+	if _, ok := s.read[readKey(userID, id)]; ok {
+		return offline.Event{ID: id, Type: eventType, Data: data}, nil
+	}
+	return s.local.Append(userID, eventType, data)
+}
+
+// List returns a user's unread events, oldest first
+func (s *SharedOfflineStore) List(userID string) ([]offline.Event, error) {
+	return s.local.List(userID)
+}
+
+// MarkRead removes events from a user's queue and tells the other replicas
+// to remove them too
+func (s *SharedOfflineStore) MarkRead(userID string, ids []string) (int, error) {
+	mark := readOfflineEvents{UserID: userID, IDs: ids, ReadAt: time.Now().UTC()}
+
+	s.mu.Lock()
+	s.remember(mark)
+	removed, err := s.local.MarkRead(userID, ids)
+	s.mu.Unlock()
+	if err != nil {
+		return removed, err
+	}
+	return removed, s.replication.send(offlineStoreName, "read", mark)
+}
+
+// Close releases the local store
+func (s *SharedOfflineStore) Close() error {
+	return s.local.Close()
+}
+
+// remember records a read mark and forgets marks older than the TTL. The
+// caller must hold s.mu.
+func (s *SharedOfflineStore) remember(mark readOfflineEvents) {
+	for _, id := range mark.IDs {
+		s.read[readKey(mark.UserID, id)] = readOfflineEvents{UserID: mark.UserID, IDs: []string{id}, ReadAt: mark.ReadAt}
+	}
+
+	cutoff := time.Now().Add(-s.ttl)
+	for id, read := range s.read {
+		if read.ReadAt.Before(cutoff) {
+			delete(s.read, id)
+		}
+	}
+}
+
+// readKey identifies a user's read mark for an event, so a user cannot mark
+// another user's events read
+func readKey(userID, id string) string {
+	return userID + "\x00" + id
+}
+
+// applyChange applies a change made by another replica
+func (s *SharedOfflineStore) applyChange(change StoreChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch change.Op {
+	case "queue":
+		var queued queuedOfflineEvent
+		if err := json.Unmarshal(change.Data, &queued); err != nil {
+			return err
+		}
+		if _, ok := s.read[readKey(queued.UserID, queued.Event.ID)]; ok {
+			return nil
+		}
+		_, err := s.local.Put(queued.UserID, queued.Event)
+		return err
+	case "read":
+		var mark readOfflineEvents
+		if err := json.Unmarshal(change.Data, &mark); err != nil {
+			return err
+		}
+		s.remember(mark)
+		_, err := s.local.MarkRead(mark.UserID, mark.IDs)
+		return err
+	default:
+		return unknownChange(change)
+	}
+}
+
+// snapshot returns the events this replica holds and the read marks it
+// remembers
+func (s *SharedOfflineStore) snapshot() []StoreChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Read marks go first so a replica does not queue events only to
+	// remove them again
+	var changes []StoreChange
+	for _, mark := range s.read {
+		changes = append(changes, storeChange("read", mark))
+	}
+	for userID, events := range s.local.All() {
+		for _, event := range events {
+			changes = append(changes, storeChange("queue", queuedOfflineEvent{UserID: userID, Event: event}))
+		}
+	}
+	return changes
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/offline"
+)
+
+// newSharedTestOfflineStore opens a replica's offline store at path
+func newSharedTestOfflineStore(t *testing.T, replication *Replication, path string) (*SharedOfflineStore, *offline.FileStore) {
+	t.Helper()
+
+	local, err := offline.NewFileStore(path, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	t.Cleanup(func() { local.Close() })
+	return NewSharedOfflineStore(local, time.Hour, replication), local
+}
+
+// queued returns the IDs of a user's unread events
+func queued(t *testing.T, store offline.Store, userID string) []string {
+	t.Helper()
+
+	events, err := store.List(userID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	return ids
+}
+
+func TestSharedOfflineStoreAcrossReplicas(t *testing.T) {
+	broker := &testBroker{}
+	dir := t.TempDir()
+	onA, _ := newSharedTestOfflineStore(t, broker.join("a"), filepath.Join(dir, "a.log"))
+	onB, _ := newSharedTestOfflineStore(t, broker.join("b"), filepath.Join(dir, "b.log"))
+	fill := json.RawMessage(`{"userId":"u-alice","tradeId":"t-1"}`)
+
+	// Both replicas receive the broker event and queue it under the same ID
+	event, err := onA.Append("u-alice", "trades.filled", fill)
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if _, err := onB.Append("u-alice", "trades.filled", fill); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if ids := queued(t, onB, "u-alice"); len(ids) != 1 || ids[0] != event.ID {
+		t.Fatalf("other replica queued %v, want [%s]", ids, event.ID)
+	}
+
+	// Reading it on one replica removes it from the other
+	if removed, err := onA.MarkRead("u-alice", []string{event.ID}); err != nil || removed != 1 {
+		t.Fatalf("MarkRead = %d, %v, want 1", removed, err)
+	}
+	if ids := queued(t, onB, "u-alice"); len(ids) != 0 {
+		t.Errorf("other replica still queues %v after it was read", ids)
+	}
+
+	// A late copy of the broker event is not queued again
+	if _, err := onB.Append("u-alice", "trades.filled", fill); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if ids := queued(t, onB, "u-alice"); len(ids) != 0 {
+		t.Errorf("late event queued again as %v", ids)
+	}
+}
+
+func TestSharedOfflineStoreReadMarksArePerUser(t *testing.T) {
+	broker := &testBroker{}
+	store, _ := newSharedTestOfflineStore(t, broker.join("a"), filepath.Join(t.TempDir(), "a.log"))
+	fill := json.RawMessage(`{"userId":"u-alice","tradeId":"t-1"}`)
+	id := offline.EventID("u-alice", "trades.filled", fill)
+
+	// Marking another user's event read does not stop it being queued
+	if _, err := store.MarkRead("u-bob", []string{id}); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+	if _, err := store.Append("u-alice", "trades.filled", fill); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if ids := queued(t, store, "u-alice"); len(ids) != 1 {
+		t.Errorf("queued %v, want the event", ids)
+	}
+}
+
+func TestSharedOfflineStoreSyncsRestartedReplica(t *testing.T) {
+	broker := &testBroker{}
+	dir := t.TempDir()
+	onA, _ := newSharedTestOfflineStore(t, broker.join("a"), filepath.Join(dir, "a.log"))
+	_, localB := newSharedTestOfflineStore(t, broker.join("b"), filepath.Join(dir, "b.log"))
+
+	read, _ := onA.Append("u-alice", "trades.filled", json.RawMessage(`{"tradeId":"t-1"}`))
+	localB.Append("u-alice", "trades.filled", json.RawMessage(`{"tradeId":"t-1"}`))
+
+	// Replica b is down while the first event is read and a second one fires
+	broker.replicas = broker.replicas[:1]
+	if _, err := onA.MarkRead("u-alice", []string{read.ID}); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+	missed, _ := onA.Append("u-alice", "trades.filled", json.RawMessage(`{"tradeId":"t-2"}`))
+
+	restarted := broker.join("b")
+	onB, _ := newSharedTestOfflineStore(t, restarted, filepath.Join(dir, "b.log"))
+	if err := restarted.RequestSync(); err != nil {
+		t.Fatalf("RequestSync: %v", err)
+	}
+
+	if ids := queued(t, onB, "u-alice"); len(ids) != 1 || ids[0] != missed.ID {
+		t.Errorf("restarted replica queues %v, want only [%s]", ids, missed.ID)
+	}
+}
@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockOIDCProvider is an OpenID Connect provider that issues ID tokens
+// with whatever claims a test registers for a code
+type mockOIDCProvider struct {
+	server *httptest.Server
+	keys   *KeySet
+	mu     sync.Mutex
+	grants map[string]mockOIDCGrant
+}
+
+// mockOIDCGrant is an authorization code's ID token claims and the PKCE
+// challenge its redemption must satisfy
+type mockOIDCGrant struct {
+	claims    jwt.MapClaims
+	challenge string
+}
+
+// newMockOIDCProvider starts a provider signing with a fresh RSA key
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	path := writeKeyFile(t, "provider.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	keys, err := LoadKeySet("provider-key", []KeyFile{{ID: "provider-key", Path: path}})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+
+	provider := &mockOIDCProvider{keys: keys, grants: make(map[string]mockOIDCGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                provider.server.URL,
+			AuthorizationEndpoint: provider.server.URL + "/authorize",
+			TokenEndpoint:         provider.server.URL + "/token",
+			JWKSURI:               provider.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(provider.keys.JWKS())
+	})
+	mux.HandleFunc("/token", provider.handleToken)
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+// handleToken redeems a code registered with grant
+func (p *mockOIDCProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	grant, ok := p.grants[r.FormValue("code")]
+	delete(p.grants, r.FormValue("code"))
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	idToken, err := p.keys.Sign(grant.claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+}
+
+// grant registers an authorization code for the login started at
+// authURL, whose ID token has claims
+func (p *mockOIDCProvider) grant(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("invalid authorization URL: %v", err)
+	}
+	code := NewOIDCState()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.grants[code] = mockOIDCGrant{claims: claims, challenge: parsed.Query().Get("code_challenge")}
+	return code
+}
+
+// idTokenClaims returns valid ID token claims for a login with nonce
+func (p *mockOIDCProvider) idTokenClaims(clientID, nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   clientID,
+		"sub":   "alice",
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+
+	tests := []struct {
+		name   string
+		change func(claims jwt.MapClaims)
+		valid  bool
+	}{
+		{"valid", func(jwt.MapClaims) {}, true},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }, false},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://attacker.example" }, false},
+		{"nonce mismatch", func(c jwt.MapClaims) { c["nonce"] = "replayed" }, false},
+		{"missing sub", func(c jwt.MapClaims) { delete(c, "sub") }, false},
+		{"empty sub", func(c jwt.MapClaims) { c["sub"] = "" }, false},
+		{"missing exp", func(c jwt.MapClaims) { delete(c, "exp") }, false},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewOIDCProvider(OIDCClientConfig{IssuerURL: mock.server.URL, ClientID: "gateway", RedirectURL: "http://gateway/callback"})
+			ctx := context.Background()
+			nonce, verifier := NewOIDCState(), NewPKCEVerifier()
+
+			authURL, err := provider.AuthCodeURL(ctx, NewOIDCState(), nonce, verifier)
+			if err != nil {
+				t.Fatalf("AuthCodeURL: %v", err)
+			}
+			claims := mock.idTokenClaims("gateway", nonce)
+			tt.change(claims)
+			code := mock.grant(t, authURL, claims)
+
+			got, err := provider.Exchange(ctx, code, verifier, nonce)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Exchange() error = %v, valid %v", err, tt.valid)
+			}
+			if tt.valid && got["sub"] != "alice" {
+				t.Errorf("sub = %v, want alice", got["sub"])
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("Exchange() error = %v, want %v", err, ErrInvalidIDToken)
+			}
+		})
+	}
+}
+
+func TestOIDCProviderRequiresPKCEVerifier(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := NewOIDCProvider(OIDCClientConfig{IssuerURL: mock.server.URL, ClientID: "gateway"})
+	ctx := context.Background()
+
+	nonce := NewOIDCState()
+	authURL, err := provider.AuthCodeURL(ctx, NewOIDCState(), nonce, NewPKCEVerifier())
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code := mock.grant(t, authURL, mock.idTokenClaims("gateway", nonce))
+
+	if _, err := provider.Exchange(ctx, code, NewPKCEVerifier(), nonce); err == nil {
+		t.Error("code redeemed with another verifier")
+	}
+}
+
+func TestOIDCProviderRejectsMismatchedIssuer(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := NewOIDCProvider(OIDCClientConfig{IssuerURL: mock.server.URL + "/other", ClientID: "gateway"})
+
+	_, err := provider.AuthCodeURL(context.Background(), NewOIDCState(), NewOIDCState(), NewPKCEVerifier())
+	if err == nil || !strings.Contains(err.Error(), "discover") {
+		t.Errorf("AuthCodeURL() error = %v, want a discovery failure", err)
+	}
+}
+
+func TestSharedExternalUserStore(t *testing.T) {
+	broker := &testBroker{}
+	onA := NewSharedExternalUserStore(broker.join("a"))
+	onB := NewSharedExternalUserStore(broker.join("b"))
+
+	user := User{ID: "oidc:alice", Username: "alice", Roles: []string{"user"}}
+	if err := onA.Put(user); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got, err := onB.GetUserByID(user.ID); err != nil || got.Username != "alice" {
+		t.Errorf("GetUserByID() on another replica = %+v, %v", got, err)
+	}
+
+	late := broker.join("c")
+	onC := NewSharedExternalUserStore(late)
+	if err := late.RequestSync(); err != nil {
+		t.Fatalf("RequestSync: %v", err)
+	}
+	if _, err := onC.GetUserByID(user.ID); err != nil {
+		t.Errorf("new replica does not know an existing user: %v", err)
+	}
+}
+
+func TestOIDCProviderServesCacheDuringFetch(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jwks" {
+			<-release
+		}
+		mock.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	provider := NewOIDCProvider(OIDCClientConfig{IssuerURL: mock.server.URL, ClientID: "gateway"})
+	ctx := context.Background()
+	if _, err := provider.AuthCodeURL(ctx, NewOIDCState(), NewOIDCState(), NewPKCEVerifier()); err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	// A key set fetch that hangs does not hold up logins using the cached
+	// discovery document
+	provider.discovery.JWKSURI = slow.URL + "/jwks"
+	go provider.key(ctx, "provider-key")
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := provider.AuthCodeURL(ctx, NewOIDCState(), NewOIDCState(), NewPKCEVerifier())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("AuthCodeURL: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("AuthCodeURL blocked by a key set fetch")
+	}
+}
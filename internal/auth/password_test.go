@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashAndVerifyPassword(t *testing.T) {
+	for _, algorithm := range []string{HashBcrypt, HashArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			hash, err := HashPassword("s3cret", algorithm)
+			if err != nil {
+				t.Fatalf("HashPassword: %v", err)
+			}
+			if strings.Contains(hash, "s3cret") {
+				t.Fatal("hash contains the password")
+			}
+
+			tests := []struct {
+				password string
+				want     bool
+			}{
+				{"s3cret", true},
+				{"S3cret", false},
+				{"", false},
+			}
+			for _, tt := range tests {
+				ok, err := VerifyPassword(hash, tt.password)
+				if err != nil {
+					t.Fatalf("VerifyPassword(%q): %v", tt.password, err)
+				}
+				if ok != tt.want {
+					t.Errorf("VerifyPassword(%q) = %v, want %v", tt.password, ok, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHashPasswordSaltsEachHash(t *testing.T) {
+	first, _ := HashPassword("s3cret", HashArgon2id)
+	second, _ := HashPassword("s3cret", HashArgon2id)
+	if first == second {
+		t.Fatal("two hashes of the same password are equal")
+	}
+}
+
+func TestHashPasswordRejectsUnknownAlgorithm(t *testing.T) {
+	if _, err := HashPassword("s3cret", "md5"); err == nil {
+		t.Fatal("HashPassword accepted an unknown algorithm")
+	}
+}
+
+func TestVerifyPasswordRejectsInvalidHashes(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"plaintext", "s3cret"},
+		{"empty", ""},
+		{"md5 crypt", "$1$salt$qJH7.N4xYta3aEG/dfqo/0"},
+		{"truncated bcrypt", "$2a$10$short"},
+		{"argon2id missing parts", "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA"},
+		{"argon2id wrong version", "$argon2id$v=16$m=65536,t=3,p=2$c2FsdHNhbHQ$a2V5a2V5"},
+		{"argon2id zero time", "$argon2id$v=19$m=65536,t=0,p=2$c2FsdHNhbHQ$a2V5a2V5"},
+		{"argon2id bad salt", "$argon2id$v=19$m=65536,t=3,p=2$!!!$a2V5a2V5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := VerifyPassword(tt.hash, "s3cret")
+			if err == nil || ok {
+				t.Errorf("VerifyPassword() = %v, %v, want an error", ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Errors returned when a refresh token cannot be used
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// RefreshToken is the server-side record of an opaque refresh token. Tokens
+// issued by rotating one another share a FamilyID, which identifies the
+// login session; SessionExpiresAt is the session's absolute end. AuthTime
+// and AMR record when and how the user logged in.
+type RefreshToken struct {
+	Hash             string    `json:"hash"`
+	FamilyID         string    `json:"familyId"`
+	UserID           string    `json:"userId"`
+	AuthTime         time.Time `json:"authTime"`
+	AMR              []string  `json:"amr"`
+	IssuedAt         time.Time `json:"issuedAt"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	SessionExpiresAt time.Time `json:"sessionExpiresAt"`
+	Used             bool      `json:"used"`
+}
+
+// RefreshStore persists refresh tokens by the hash of the token
+type RefreshStore interface {
+	// Save stores a newly issued token
+	Save(token RefreshToken) error
+	// Use marks a token used and returns it as it was before. Tokens whose
+	// family was revoked are reported as not found.
+	Use(hash string) (RefreshToken, error)
+	// RevokeFamily invalidates every token of a session
+	RevokeFamily(familyID string) error
+}
+
+// RefreshTokens issues and rotates refresh tokens
+type RefreshTokens struct {
+	store      RefreshStore
+	ttl        time.Duration
+	maxSession time.Duration
+}
+
+// NewRefreshTokens creates a refresh token manager. Each token is valid for
+// ttl, and rotation never extends a session past maxSession from login.
+func NewRefreshTokens(store RefreshStore, ttl, maxSession time.Duration) *RefreshTokens {
+	return &RefreshTokens{store: store, ttl: ttl, maxSession: maxSession}
+}
+
+// Issue starts a new session for a user who authenticated at authTime with
+// the amr methods and returns its first token
+func (r *RefreshTokens) Issue(userID string, authTime time.Time, amr []string) (string, RefreshToken, error) {
+	now := time.Now()
+	return r.issue(RefreshToken{
+		FamilyID:         newOpaqueID(16),
+		UserID:           userID,
+		AuthTime:         authTime,
+		AMR:              amr,
+		SessionExpiresAt: now.Add(r.maxSession),
+	})
+}
+
+// Rotate exchanges a refresh token for a new one in the same session.
+// Presenting a token that was already rotated revokes the whole session,
+// since either the client or an attacker holds a stolen copy.
+func (r *RefreshTokens) Rotate(token string) (string, RefreshToken, error) {
+	current, err := r.store.Use(hashToken(token))
+	if err != nil {
+		return "", RefreshToken{}, err
+	}
+
+	if current.Used {
+		if err := r.store.RevokeFamily(current.FamilyID); err != nil {
+			return "", RefreshToken{}, fmt.Errorf("failed to revoke reused session: %w", err)
+		}
+		return "", current, ErrRefreshTokenReused
+	}
+
+	now := time.Now()
+	if !now.Before(current.ExpiresAt) || !now.Before(current.SessionExpiresAt) {
+		return "", RefreshToken{}, ErrInvalidRefreshToken
+	}
+
+	return r.issue(RefreshToken{
+		FamilyID:         current.FamilyID,
+		UserID:           current.UserID,
+		AuthTime:         current.AuthTime,
+		AMR:              current.AMR,
+		SessionExpiresAt: current.SessionExpiresAt,
+	})
+}
+
+// Revoke ends a session so none of its refresh tokens can be used
+func (r *RefreshTokens) Revoke(familyID string) error {
+	return r.store.RevokeFamily(familyID)
+}
+
+// issue creates and stores a token for the session described by record
+func (r *RefreshTokens) issue(record RefreshToken) (string, RefreshToken, error) {
+	token := newOpaqueID(32)
+
+	record.Hash = hashToken(token)
+	record.IssuedAt = time.Now()
+	record.ExpiresAt = record.IssuedAt.Add(r.ttl)
+	if record.ExpiresAt.After(record.SessionExpiresAt) {
+		record.ExpiresAt = record.SessionExpiresAt
+	}
+
+	if err := r.store.Save(record); err != nil {
+		return "", RefreshToken{}, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return token, record, nil
+}
+
+// MemoryRefreshStore keeps refresh tokens in process memory
+type MemoryRefreshStore struct {
+	mu        sync.Mutex
+	tokens    map[string]RefreshToken
+	families  map[string][]string // family id -> token hashes
+	lastSweep time.Time
+}
+
+// NewMemoryRefreshStore creates an empty store
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		tokens:    make(map[string]RefreshToken),
+		families:  make(map[string][]string),
+		lastSweep: time.Now(),
+	}
+}
+
+// Save stores a newly issued token
+func (s *MemoryRefreshStore) Save(token RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.Hash] = token
+	s.families[token.FamilyID] = append(s.families[token.FamilyID], token.Hash)
+
+	if time.Since(s.lastSweep) > sweepInterval {
+		s.sweep()
+	}
+	return nil
+}
+
+// Use marks a token used and returns it as it was before
+func (s *MemoryRefreshStore) Use(hash string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hash]
+	if !ok {
+		return RefreshToken{}, ErrInvalidRefreshToken
+	}
+
+	used := token
+	used.Used = true
+	s.tokens[hash] = used
+	return token, nil
+}
+
+// RevokeFamily invalidates every token of a session
+func (s *MemoryRefreshStore) RevokeFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, hash := range s.families[familyID] {
+		delete(s.tokens, hash)
+	}
+	delete(s.families, familyID)
+	return nil
+}
+
+// merge stores a token saved or used on another replica. A token stays
+// used once either replica used it.
+func (s *MemoryRefreshStore) merge(token RefreshToken) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.tokens[token.Hash]
+	if ok {
+		token.Used = token.Used || existing.Used
+	} else {
+		s.families[token.FamilyID] = append(s.families[token.FamilyID], token.Hash)
+	}
+	s.tokens[token.Hash] = token
+}
+
+// all returns every stored token
+func (s *MemoryRefreshStore) all() []RefreshToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make([]RefreshToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// sweep drops sessions that have ended. The caller must hold s.mu.
+func (s *MemoryRefreshStore) sweep() {
+	now := time.Now()
+	for familyID, hashes := range s.families {
+		live := hashes[:0]
+		for _, hash := range hashes {
+			if token, ok := s.tokens[hash]; ok && now.Before(token.SessionExpiresAt) {
+				live = append(live, hash)
+			} else {
+				delete(s.tokens, hash)
+			}
+		}
+
+		if len(live) == 0 {
+			delete(s.families, familyID)
+		} else {
+			s.families[familyID] = live
+		}
+	}
+	s.lastSweep = now
+}
+
+// refreshStoreName identifies refresh tokens in replication messages
+const refreshStoreName = "refresh_tokens"
+
+// SharedRefreshStore is a RefreshStore replicated between gateway replicas,
+// so a token can be rotated on any replica and reuse of a rotated token is
+// detected wherever it is presented. A token presented to two replicas
+// within the broker's delivery delay may be rotated on both.
+type SharedRefreshStore struct {
+	local       *MemoryRefreshStore
+	replication *Replication
+}
+
+// NewSharedRefreshStore creates a store that shares its tokens through
+// replication
+func NewSharedRefreshStore(replication *Replication) *SharedRefreshStore {
+	store := &SharedRefreshStore{local: NewMemoryRefreshStore(), replication: replication}
+	replication.register(refreshStoreName, store)
+	return store
+}
+
+// Save stores a newly issued token and sends it to the other replicas
+func (s *SharedRefreshStore) Save(token RefreshToken) error {
+	s.local.Save(token)
+	return s.replication.send(refreshStoreName, "save", token)
+}
+
+// Use marks a token used on every replica and returns it as it was before
+func (s *SharedRefreshStore) Use(hash string) (RefreshToken, error) {
+	token, err := s.local.Use(hash)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if err := s.replication.send(refreshStoreName, "use", hash); err != nil {
+		return RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// RevokeFamily invalidates every token of a session on every replica
+func (s *SharedRefreshStore) RevokeFamily(familyID string) error {
+	s.local.RevokeFamily(familyID)
+	return s.replication.send(refreshStoreName, "revoke", familyID)
+}
+
+// applyChange applies a change made by another replica
+func (s *SharedRefreshStore) applyChange(change StoreChange) error {
+	switch change.Op {
+	case "save":
+		var token RefreshToken
+		if err := json.Unmarshal(change.Data, &token); err != nil {
+			return err
+		}
+		s.local.merge(token)
+	case "use":
+		var hash string
+		if err := json.Unmarshal(change.Data, &hash); err != nil {
+			return err
+		}
+		s.local.Use(hash)
+	case "revoke":
+		var familyID string
+		if err := json.Unmarshal(change.Data, &familyID); err != nil {
+			return err
+		}
+		s.local.RevokeFamily(familyID)
+	default:
+		return unknownChange(change)
+	}
+	return nil
+}
+
+// snapshot returns the tokens this replica holds
+func (s *SharedRefreshStore) snapshot() []StoreChange {
+	tokens := s.local.all()
+	changes := make([]StoreChange, len(tokens))
+	for i, token := range tokens {
+		changes[i] = storeChange("save", token)
+	}
+	return changes
+}
+
+// hashToken returns the stored form of an opaque token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newOpaqueID returns n random bytes encoded for use in URLs and headers
+func newOpaqueID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
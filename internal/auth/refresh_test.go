@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokensRotate(t *testing.T) {
+	tokens := NewRefreshTokens(NewMemoryRefreshStore(), time.Hour, 24*time.Hour)
+
+	first, session, err := tokens.Issue("u-alice", time.Now(), []string{AMRPassword})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	second, rotated, err := tokens.Rotate(first)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if second == first {
+		t.Fatal("rotation returned the same token")
+	}
+	if rotated.FamilyID != session.FamilyID || rotated.UserID != "u-alice" {
+		t.Errorf("rotated token is in family %s for %s, want %s for u-alice", rotated.FamilyID, rotated.UserID, session.FamilyID)
+	}
+	if !rotated.AuthTime.Equal(session.AuthTime) {
+		t.Error("rotation changed the authentication time")
+	}
+
+	if _, _, err := tokens.Rotate("unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Rotate(unknown) error = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRefreshTokensReuseRevokesSession(t *testing.T) {
+	tokens := NewRefreshTokens(NewMemoryRefreshStore(), time.Hour, 24*time.Hour)
+
+	first, session, _ := tokens.Issue("u-alice", time.Now(), nil)
+	second, _, err := tokens.Rotate(first)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	_, reused, err := tokens.Rotate(first)
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reusing a rotated token error = %v, want ErrRefreshTokenReused", err)
+	}
+	if reused.FamilyID != session.FamilyID {
+		t.Errorf("reuse reported family %s, want %s", reused.FamilyID, session.FamilyID)
+	}
+
+	// The legitimate client's newest token dies with the session
+	if _, _, err := tokens.Rotate(second); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("newest token after reuse error = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRefreshTokensExpiry(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		maxSession time.Duration
+	}{
+		{"token expired", -time.Second, time.Hour},
+		{"session ended", time.Hour, -time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := NewRefreshTokens(NewMemoryRefreshStore(), tt.ttl, tt.maxSession)
+			token, _, _ := tokens.Issue("u-alice", time.Now(), nil)
+			if _, _, err := tokens.Rotate(token); !errors.Is(err, ErrInvalidRefreshToken) {
+				t.Errorf("Rotate() error = %v, want ErrInvalidRefreshToken", err)
+			}
+		})
+	}
+}
+
+func TestRefreshTokensNeverOutliveSession(t *testing.T) {
+	tokens := NewRefreshTokens(NewMemoryRefreshStore(), 24*time.Hour, time.Hour)
+
+	_, session, _ := tokens.Issue("u-alice", time.Now(), nil)
+	if session.ExpiresAt.After(session.SessionExpiresAt) {
+		t.Errorf("token expires at %v, after its session ends at %v", session.ExpiresAt, session.SessionExpiresAt)
+	}
+}
+
+func TestSharedRefreshStoreAcrossReplicas(t *testing.T) {
+	broker := &testBroker{}
+	onA := NewRefreshTokens(NewSharedRefreshStore(broker.join("a")), time.Hour, 24*time.Hour)
+	onB := NewRefreshTokens(NewSharedRefreshStore(broker.join("b")), time.Hour, 24*time.Hour)
+
+	first, _, err := onA.Issue("u-alice", time.Now(), nil)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	// A token issued on one replica rotates on another
+	second, _, err := onB.Rotate(first)
+	if err != nil {
+		t.Fatalf("Rotate on another replica: %v", err)
+	}
+
+	// Replaying the rotated token on the first replica is detected as reuse
+	if _, _, err := onA.Rotate(first); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reuse on another replica error = %v, want ErrRefreshTokenReused", err)
+	}
+
+	// and the session is revoked everywhere
+	for name, tokens := range map[string]*RefreshTokens{"a": onA, "b": onB} {
+		if _, _, err := tokens.Rotate(second); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("replica %s accepted a token of the revoked session: %v", name, err)
+		}
+	}
+}
+
+func TestSharedRefreshStoreSyncsNewReplica(t *testing.T) {
+	broker := &testBroker{}
+	onA := NewRefreshTokens(NewSharedRefreshStore(broker.join("a")), time.Hour, 24*time.Hour)
+	token, _, _ := onA.Issue("u-alice", time.Now(), nil)
+
+	// A replica that starts later asks for the tokens it missed
+	late := broker.join("b")
+	onB := NewRefreshTokens(NewSharedRefreshStore(late), time.Hour, 24*time.Hour)
+
+	if err := late.RequestSync(); err != nil {
+		t.Fatalf("RequestSync: %v", err)
+	}
+	if _, _, err := onB.Rotate(token); err != nil {
+		t.Errorf("new replica cannot rotate an existing token: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Replication backends for stores that can be shared between replicas
+const (
+	ReplicationMemory = "memory"
+	ReplicationShared = "shared"
+)
+
+// StoreChange is one change to a replicated store. Op names the change and
+// Data holds its JSON-encoded argument.
+type StoreChange struct {
+	Op   string          `json:"op"`
+	Data json.RawMessage `json:"data"`
+}
+
+// ReplicationMessage is exchanged between replicas sharing stores. A
+// replica that starts sends a sync request, for every store when Store is
+// empty, and the others answer with changes that recreate their state.
+type ReplicationMessage struct {
+	Store       string        `json:"store,omitempty"`
+	ReplicaID   string        `json:"replicaId"`
+	Changes     []StoreChange `json:"changes,omitempty"`
+	SyncRequest bool          `json:"syncRequest,omitempty"`
+}
+
+// ReplicationPublisher sends a message to the other replicas
+type ReplicationPublisher func(message ReplicationMessage) error
+
+// replicatedStore is a store whose changes are shared through a Replication
+type replicatedStore interface {
+	// applyChange applies a change made by another replica
+	applyChange(change StoreChange) error
+	// snapshot returns changes that recreate the store's current state
+	snapshot() []StoreChange
+}
+
+// Replication shares the changes of registered stores with the other
+// gateway replicas. Every change is applied locally first and then
+// published, so replicas converge within the broker's delivery delay.
+type Replication struct {
+	replicaID string
+	publish   ReplicationPublisher
+	stores    map[string]replicatedStore
+	mu        sync.RWMutex
+}
+
+// NewReplication creates a replication that publishes through publish.
+// Messages from other replicas are passed to Apply.
+func NewReplication(replicaID string, publish ReplicationPublisher) *Replication {
+	return &Replication{
+		replicaID: replicaID,
+		publish:   publish,
+		stores:    make(map[string]replicatedStore),
+	}
+}
+
+// register adds a store to be replicated under name
+func (r *Replication) register(name string, store replicatedStore) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stores[name] = store
+}
+
+// send publishes a change this replica made to the named store
+func (r *Replication) send(store, op string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s change: %w", store, err)
+	}
+
+	message := ReplicationMessage{
+		Store:     store,
+		ReplicaID: r.replicaID,
+		Changes:   []StoreChange{{Op: op, Data: raw}},
+	}
+	if err := r.publish(message); err != nil {
+		return fmt.Errorf("failed to publish %s change: %w", store, err)
+	}
+	return nil
+}
+
+// RequestSync asks the other replicas for the state of every store
+func (r *Replication) RequestSync() error {
+	return r.publish(ReplicationMessage{ReplicaID: r.replicaID, SyncRequest: true})
+}
+
+// Apply merges a message received from another replica, answering sync
+// requests with the state of the requested stores
+func (r *Replication) Apply(message ReplicationMessage) error {
+	if message.ReplicaID == r.replicaID {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if store, ok := r.stores[message.Store]; ok {
+		for _, change := range message.Changes {
+			if err := store.applyChange(change); err != nil {
+				return fmt.Errorf("failed to apply %s change: %w", message.Store, err)
+			}
+		}
+	}
+
+	if !message.SyncRequest {
+		return nil
+	}
+	for name, store := range r.stores {
+		if message.Store != "" && message.Store != name {
+			continue
+		}
+		changes := store.snapshot()
+		if len(changes) == 0 {
+			continue
+		}
+		if err := r.publish(ReplicationMessage{Store: name, ReplicaID: r.replicaID, Changes: changes}); err != nil {
+			return fmt.Errorf("failed to publish %s state: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// storeChange encodes a change for a snapshot
+func storeChange(op string, data interface{}) StoreChange {
+	raw, _ := json.Marshal(data)
+	return StoreChange{Op: op, Data: raw}
+}
+
+// unknownChange is returned for changes a store does not know how to apply
+func unknownChange(change StoreChange) error {
+	return fmt.Errorf("unknown change %q", change.Op)
+}
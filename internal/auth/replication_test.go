@@ -0,0 +1,24 @@
+package auth
+
+// testBroker delivers replication messages synchronously to every replica
+// that joined it, as the message broker would
+type testBroker struct {
+	replicas []*Replication
+}
+
+// join adds a replica to the broker
+func (b *testBroker) join(replicaID string) *Replication {
+	replication := NewReplication(replicaID, b.publish)
+	b.replicas = append(b.replicas, replication)
+	return replication
+}
+
+// publish delivers a message to every replica, including its sender
+func (b *testBroker) publish(message ReplicationMessage) error {
+	for _, replica := range b.replicas {
+		if err := replica.Apply(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
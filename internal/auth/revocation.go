@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Revocation backends
+const (
+	RevocationMemory = "memory"
+	RevocationShared = "shared"
+)
+
+// sweepInterval bounds how often expired revocations are purged
+const sweepInterval = time.Minute
+
+// Revocation is a revoked token id and the time its token expires, after
+// which the entry is no longer needed
+type Revocation struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// RevocationList records tokens that were revoked before they expired
+type RevocationList interface {
+	// Revoke marks the token with the given jti revoked until expiresAt
+	Revoke(jti string, expiresAt time.Time) error
+	// IsRevoked reports whether the token with the given jti is revoked
+	IsRevoked(jti string) (bool, error)
+}
+
+// MemoryRevocationList keeps revocations in process memory
+type MemoryRevocationList struct {
+	mu        sync.Mutex
+	entries   map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryRevocationList creates an empty revocation list
+func NewMemoryRevocationList() *MemoryRevocationList {
+	return &MemoryRevocationList{
+		entries:   make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Revoke marks the token with the given jti revoked until expiresAt
+func (l *MemoryRevocationList) Revoke(jti string, expiresAt time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if expiresAt.After(now) {
+		l.entries[jti] = expiresAt
+	}
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		for id, exp := range l.entries {
+			if !exp.After(now) {
+				delete(l.entries, id)
+			}
+		}
+		l.lastSweep = now
+	}
+	return nil
+}
+
+// IsRevoked reports whether the token with the given jti is revoked
+func (l *MemoryRevocationList) IsRevoked(jti string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expiresAt, ok := l.entries[jti]
+	return ok && expiresAt.After(time.Now()), nil
+}
+
+// Entries returns every revocation that has not expired
+func (l *MemoryRevocationList) Entries() []Revocation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	entries := make([]Revocation, 0, len(l.entries))
+	for jti, expiresAt := range l.entries {
+		if expiresAt.After(now) {
+			entries = append(entries, Revocation{JTI: jti, ExpiresAt: expiresAt})
+		}
+	}
+	return entries
+}
+
+// RevocationMessage is exchanged between replicas sharing a revocation list.
+// A replica that starts sends a sync request and the others answer with
+// their current entries.
+type RevocationMessage struct {
+	ReplicaID   string       `json:"replicaId"`
+	Revocations []Revocation `json:"revocations,omitempty"`
+	SyncRequest bool         `json:"syncRequest,omitempty"`
+}
+
+// RevocationPublisher sends a message to the other replicas
+type RevocationPublisher func(message RevocationMessage) error
+
+// SharedRevocationList is a revocation list replicated between gateway
+// replicas, so a token revoked on one replica is rejected by all of them
+type SharedRevocationList struct {
+	local     *MemoryRevocationList
+	replicaID string
+	publish   RevocationPublisher
+	onRevoke  func(jti string)
+}
+
+// NewSharedRevocationList creates a list that replicates through publish.
+// Messages from other replicas are passed to Apply.
+func NewSharedRevocationList(replicaID string, publish RevocationPublisher) *SharedRevocationList {
+	return &SharedRevocationList{
+		local:     NewMemoryRevocationList(),
+		replicaID: replicaID,
+		publish:   publish,
+	}
+}
+
+// Revoke records the revocation locally and sends it to the other replicas
+func (l *SharedRevocationList) Revoke(jti string, expiresAt time.Time) error {
+	l.local.Revoke(jti, expiresAt)
+
+	message := RevocationMessage{
+		ReplicaID:   l.replicaID,
+		Revocations: []Revocation{{JTI: jti, ExpiresAt: expiresAt}},
+	}
+	if err := l.publish(message); err != nil {
+		return fmt.Errorf("failed to publish revocation: %w", err)
+	}
+	return nil
+}
+
+// IsRevoked reports whether the token with the given jti is revoked
+func (l *SharedRevocationList) IsRevoked(jti string) (bool, error) {
+	return l.local.IsRevoked(jti)
+}
+
+// OnRevoke registers a function called with each revocation received from
+// another replica
+func (l *SharedRevocationList) OnRevoke(handler func(jti string)) {
+	l.onRevoke = handler
+}
+
+// RequestSync asks the other replicas for the revocations they hold
+func (l *SharedRevocationList) RequestSync() error {
+	return l.publish(RevocationMessage{ReplicaID: l.replicaID, SyncRequest: true})
+}
+
+// Apply merges a message received from another replica, answering sync
+// requests with this replica's entries
+func (l *SharedRevocationList) Apply(message RevocationMessage) error {
+	if message.ReplicaID == l.replicaID {
+		return nil
+	}
+
+	for _, revocation := range message.Revocations {
+		l.local.Revoke(revocation.JTI, revocation.ExpiresAt)
+		if l.onRevoke != nil {
+			l.onRevoke(revocation.JTI)
+		}
+	}
+
+	if message.SyncRequest {
+		if entries := l.local.Entries(); len(entries) > 0 {
+			return l.publish(RevocationMessage{ReplicaID: l.replicaID, Revocations: entries})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRevocationList(t *testing.T) {
+	list := NewMemoryRevocationList()
+	list.Revoke("live", time.Now().Add(time.Hour))
+	list.Revoke("expired", time.Now().Add(-time.Second))
+
+	tests := []struct {
+		jti  string
+		want bool
+	}{
+		{"live", true},
+		{"expired", false},
+		{"unknown", false},
+	}
+	for _, tt := range tests {
+		if revoked, err := list.IsRevoked(tt.jti); err != nil || revoked != tt.want {
+			t.Errorf("IsRevoked(%s) = %v, %v, want %v", tt.jti, revoked, err, tt.want)
+		}
+	}
+
+	if entries := list.Entries(); len(entries) != 1 || entries[0].JTI != "live" {
+		t.Errorf("Entries() = %+v, want only the live revocation", entries)
+	}
+}
+
+// revocationReplicas returns shared revocation lists that deliver their
+// messages to each other synchronously
+func revocationReplicas(ids ...string) []*SharedRevocationList {
+	lists := make([]*SharedRevocationList, len(ids))
+	for i, id := range ids {
+		lists[i] = NewSharedRevocationList(id, func(message RevocationMessage) error {
+			for _, other := range lists {
+				if err := other.Apply(message); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return lists
+}
+
+func TestSharedRevocationListReplicates(t *testing.T) {
+	lists := revocationReplicas("a", "b")
+
+	var notified []string
+	lists[1].OnRevoke(func(jti string) { notified = append(notified, jti) })
+
+	if err := lists[0].Revoke("token-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	for _, list := range lists {
+		if revoked, _ := list.IsRevoked("token-1"); !revoked {
+			t.Errorf("replica %s does not see the revocation", list.replicaID)
+		}
+	}
+	if len(notified) != 1 || notified[0] != "token-1" {
+		t.Errorf("OnRevoke called with %v, want [token-1]", notified)
+	}
+}
+
+func TestSharedRevocationListSyncsNewReplica(t *testing.T) {
+	lists := revocationReplicas("a", "b")
+	lists[0].local.Revoke("token-1", time.Now().Add(time.Hour))
+
+	if err := lists[1].RequestSync(); err != nil {
+		t.Fatalf("RequestSync: %v", err)
+	}
+	if revoked, _ := lists[1].IsRevoked("token-1"); !revoked {
+		t.Error("new replica did not receive existing revocations")
+	}
+}
@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// lastSeenResolution limits how often a session's last-seen time is stored
+const lastSeenResolution = time.Minute
+
+// ErrSessionNotFound is returned for sessions that do not exist or ended
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a login session as shown to its user: the device it was
+// started from and when it was last used. Its ID is the refresh token
+// family and the sid claim of its access tokens.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"userId"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	LastIP     string    `json:"lastIp"`
+	AMR        []string  `json:"amr"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// SessionStore persists login sessions by ID
+type SessionStore interface {
+	// Save stores a session, replacing any previous one with its ID
+	Save(session Session) error
+	// Get returns the session with the given ID
+	Get(id string) (Session, error)
+	// List returns a user's sessions, or every session when userID is empty
+	List(userID string) ([]Session, error)
+	// Delete removes a session
+	Delete(id string) error
+}
+
+// Sessions records login sessions and when they are used
+type Sessions struct {
+	store SessionStore
+	mu    sync.Mutex
+}
+
+// NewSessions creates a session registry backed by store
+func NewSessions(store SessionStore) *Sessions {
+	return &Sessions{store: store}
+}
+
+// Start records a new session from the refresh token that began it
+func (s *Sessions) Start(token RefreshToken, userAgent, clientIP string) (Session, error) {
+	now := time.Now().UTC()
+	session := Session{
+		ID:         token.FamilyID,
+		UserID:     token.UserID,
+		UserAgent:  userAgent,
+		IP:         clientIP,
+		LastIP:     clientIP,
+		AMR:        token.AMR,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  token.ExpiresAt,
+	}
+	if err := s.store.Save(session); err != nil {
+		return Session{}, fmt.Errorf("failed to store session: %w", err)
+	}
+	return session, nil
+}
+
+// Refreshed records a session's refresh token rotation. The session lasts
+// as long as its newest refresh token.
+func (s *Sessions) Refreshed(token RefreshToken, clientIP string) error {
+	return s.update(token.FamilyID, clientIP, func(session *Session) {
+		session.AMR = token.AMR
+		session.ExpiresAt = token.ExpiresAt
+	})
+}
+
+// Seen records that a session was used from clientIP. Uses from the same
+// address are only stored once per lastSeenResolution.
+func (s *Sessions) Seen(id, clientIP string) error {
+	return s.update(id, clientIP, nil)
+}
+
+// Get returns the session with the given ID
+func (s *Sessions) Get(id string) (Session, error) {
+	session, err := s.store.Get(id)
+	if err != nil {
+		return Session{}, err
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// List returns a user's live sessions, most recently used first, or every
+// live session when userID is empty
+func (s *Sessions) List(userID string) ([]Session, error) {
+	all, err := s.store.List(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sessions := make([]Session, 0, len(all))
+	for _, session := range all {
+		if now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+// End forgets a session. Its tokens must be revoked separately.
+func (s *Sessions) End(id string) error {
+	return s.store.Delete(id)
+}
+
+// OnRemoteEnd registers fn to be called with the ID of each session ended
+// on another replica. It does nothing unless the store is shared.
+func (s *Sessions) OnRemoteEnd(fn func(id string)) {
+	if shared, ok := s.store.(*SharedSessionStore); ok {
+		shared.onDelete = fn
+	}
+}
+
+// update records a live session's use from clientIP and applies change if
+// it is not nil. Without a change the session is only saved when the
+// address changed or the last save is older than lastSeenResolution.
+func (s *Sessions) update(id, clientIP string, change func(session *Session)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if change != nil {
+		change(&session)
+	} else if session.LastIP == clientIP && now.Sub(session.LastSeenAt) < lastSeenResolution {
+		return nil
+	}
+
+	session.LastIP = clientIP
+	session.LastSeenAt = now
+	if err := s.store.Save(session); err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	return nil
+}
+
+// MemorySessionStore keeps login sessions in process memory
+type MemorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]Session
+	lastSweep time.Time
+}
+
+// NewMemorySessionStore creates an empty store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions:  make(map[string]Session),
+		lastSweep: time.Now(),
+	}
+}
+
+// Save stores a session, replacing any previous one with its ID
+func (s *MemorySessionStore) Save(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.ID] = session
+
+	if now := time.Now(); now.Sub(s.lastSweep) > sweepInterval {
+		for id, existing := range s.sessions {
+			if !now.Before(existing.ExpiresAt) {
+				delete(s.sessions, id)
+			}
+		}
+		s.lastSweep = now
+	}
+	return nil
+}
+
+// Get returns the session with the given ID
+func (s *MemorySessionStore) Get(id string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// List returns a user's sessions, or every session when userID is empty
+func (s *MemorySessionStore) List(userID string) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := make([]Session, 0)
+	for _, session := range s.sessions {
+		if userID == "" || session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// Delete removes a session
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// sessionStoreName identifies login sessions in replication messages
+const sessionStoreName = "sessions"
+
+// SharedSessionStore is a SessionStore replicated between gateway replicas,
+// so users see and can revoke their sessions whichever replica they reach,
+// and a session ended on one replica is ended on all of them
+type SharedSessionStore struct {
+	local       *MemorySessionStore
+	replication *Replication
+	onDelete    func(id string)
+}
+
+// NewSharedSessionStore creates a store that shares sessions through
+// replication
+func NewSharedSessionStore(replication *Replication) *SharedSessionStore {
+	store := &SharedSessionStore{local: NewMemorySessionStore(), replication: replication}
+	replication.register(sessionStoreName, store)
+	return store
+}
+
+// Save stores a session and sends it to the other replicas
+func (s *SharedSessionStore) Save(session Session) error {
+	s.local.Save(session)
+	return s.replication.send(sessionStoreName, "save", session)
+}
+
+// Get returns the session with the given ID
+func (s *SharedSessionStore) Get(id string) (Session, error) {
+	return s.local.Get(id)
+}
+
+// List returns a user's sessions, or every session when userID is empty
+func (s *SharedSessionStore) List(userID string) ([]Session, error) {
+	return s.local.List(userID)
+}
+
+// Delete removes a session on every replica
+func (s *SharedSessionStore) Delete(id string) error {
+	s.local.Delete(id)
+	return s.replication.send(sessionStoreName, "delete", id)
+}
+
+// applyChange applies a change made by another replica
+func (s *SharedSessionStore) applyChange(change StoreChange) error {
+	switch change.Op {
+	case "save":
+		var session Session
+		if err := json.Unmarshal(change.Data, &session); err != nil {
+			return err
+		}
+		s.local.Save(session)
+	case "delete":
+		var id string
+		if err := json.Unmarshal(change.Data, &id); err != nil {
+			return err
+		}
+		s.local.Delete(id)
+		if s.onDelete != nil {
+			s.onDelete(id)
+		}
+	default:
+		return unknownChange(change)
+	}
+	return nil
+}
+
+// snapshot returns the sessions this replica holds
+func (s *SharedSessionStore) snapshot() []StoreChange {
+	sessions, _ := s.local.List("")
+	changes := make([]StoreChange, len(sessions))
+	for i, session := range sessions {
+		changes[i] = storeChange("save", session)
+	}
+	return changes
+}
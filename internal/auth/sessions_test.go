@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionsRecordUse(t *testing.T) {
+	sessions := NewSessions(NewMemorySessionStore())
+	token := RefreshToken{FamilyID: "s1", UserID: "u-alice", ExpiresAt: time.Now().Add(time.Hour)}
+
+	if _, err := sessions.Start(token, "curl/8", "192.0.2.1"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := sessions.Seen("s1", "192.0.2.2"); err != nil {
+		t.Fatalf("Seen: %v", err)
+	}
+
+	session, err := sessions.Get("s1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if session.IP != "192.0.2.1" || session.LastIP != "192.0.2.2" {
+		t.Errorf("session started from %s and last seen from %s", session.IP, session.LastIP)
+	}
+
+	if err := sessions.End("s1"); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+	if err := sessions.Seen("s1", "192.0.2.2"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Seen() after End error = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestSessionsExpire(t *testing.T) {
+	sessions := NewSessions(NewMemorySessionStore())
+	sessions.Start(RefreshToken{FamilyID: "s1", UserID: "u-alice", ExpiresAt: time.Now().Add(-time.Second)}, "", "192.0.2.1")
+
+	if _, err := sessions.Get("s1"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get() of an expired session error = %v, want %v", err, ErrSessionNotFound)
+	}
+	if list, _ := sessions.List("u-alice"); len(list) != 0 {
+		t.Errorf("List() returned %d expired sessions", len(list))
+	}
+}
+
+func TestSharedSessionStoreAcrossReplicas(t *testing.T) {
+	broker := &testBroker{}
+	onA := NewSessions(NewSharedSessionStore(broker.join("a")))
+	onB := NewSessions(NewSharedSessionStore(broker.join("b")))
+
+	var ended []string
+	onB.OnRemoteEnd(func(id string) { ended = append(ended, id) })
+
+	token := RefreshToken{FamilyID: "s1", UserID: "u-alice", ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err := onA.Start(token, "curl/8", "192.0.2.1"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if list, _ := onB.List("u-alice"); len(list) != 1 {
+		t.Fatalf("other replica lists %d sessions, want 1", len(list))
+	}
+
+	if err := onA.End("s1"); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+	if _, err := onB.Get("s1"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("other replica Get() error = %v, want %v", err, ErrSessionNotFound)
+	}
+	if len(ended) != 1 || ended[0] != "s1" {
+		t.Errorf("other replica was told of ended sessions %v, want [s1]", ended)
+	}
+}
+
+func TestSharedSessionStoreSyncsNewReplica(t *testing.T) {
+	broker := &testBroker{}
+	onA := NewSessions(NewSharedSessionStore(broker.join("a")))
+	onA.Start(RefreshToken{FamilyID: "s1", UserID: "u-alice", ExpiresAt: time.Now().Add(time.Hour)}, "", "192.0.2.1")
+
+	late := broker.join("b")
+	onB := NewSessions(NewSharedSessionStore(late))
+	if err := late.RequestSync(); err != nil {
+		t.Fatalf("RequestSync: %v", err)
+	}
+	if _, err := onB.Get("s1"); err != nil {
+		t.Errorf("new replica Get() error = %v", err)
+	}
+}
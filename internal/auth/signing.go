@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Errors returned when a signed request is rejected
+var (
+	ErrSigningDisabled   = errors.New("request signing not configured")
+	ErrInvalidSignature  = errors.New("invalid request signature")
+	ErrStaleSignature    = errors.New("request timestamp outside allowed skew")
+	ErrNonceReused       = errors.New("request nonce already used")
+	ErrNonceRequired     = errors.New("request nonce required")
+	ErrTimestampRequired = errors.New("request timestamp required")
+)
+
+// SignedRequest is a request authenticated with an API key's signing
+// secret instead of the key itself. Signature is the hex HMAC-SHA256 of the
+// request's StringToSign.
+type SignedRequest struct {
+	KeyID     string
+	Timestamp string
+	Nonce     string
+	Method    string
+	Path      string
+	Body      []byte
+	Signature string
+}
+
+// StringToSign returns the message a client signs: the timestamp, nonce,
+// method, path with query and hex SHA-256 of the body, separated by newlines
+func (r SignedRequest) StringToSign() string {
+	bodyHash := sha256.Sum256(r.Body)
+	return r.Timestamp + "\n" + r.Nonce + "\n" + r.Method + "\n" + r.Path + "\n" + hex.EncodeToString(bodyHash[:])
+}
+
+// NonceCache remembers request nonces so a signed request cannot be replayed
+type NonceCache interface {
+	// Use records a nonce until expiresAt and reports whether it was unused
+	Use(nonce string, expiresAt time.Time) (bool, error)
+}
+
+// SigningSecret returns the secret a key signs requests with. Secrets are
+// derived from the gateway's signing secret, so they are never stored.
+func (a *APIKeys) SigningSecret(id string) (string, error) {
+	if len(a.signingSecret) == 0 {
+		return "", ErrSigningDisabled
+	}
+	mac := hmac.New(sha256.New, a.signingSecret)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// VerifySignature authenticates a signed request from clientIP. The
+// timestamp must be within the allowed skew and each nonce may be used once.
+func (a *APIKeys) VerifySignature(request SignedRequest, clientIP string) (APIKey, error) {
+	if request.Timestamp == "" {
+		return APIKey{}, ErrTimestampRequired
+	}
+	if request.Nonce == "" {
+		return APIKey{}, ErrNonceRequired
+	}
+
+	seconds, err := strconv.ParseInt(request.Timestamp, 10, 64)
+	if err != nil {
+		return APIKey{}, ErrInvalidSignature
+	}
+	timestamp := time.Unix(seconds, 0)
+	if skew := time.Since(timestamp); skew > a.skew || skew < -a.skew {
+		return APIKey{}, ErrStaleSignature
+	}
+
+	record, err := a.store.Get(request.KeyID)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	secret, err := a.SigningSecret(record.ID)
+	if err != nil {
+		return APIKey{}, err
+	}
+	signature, err := hex.DecodeString(request.Signature)
+	if err != nil {
+		return APIKey{}, ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(request.StringToSign()))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return APIKey{}, ErrInvalidSignature
+	}
+
+	// Only record the nonce once the signature is valid, so others cannot
+	// use up a client's nonces. It is kept until the timestamp leaves the
+	// skew window, after which the request is rejected as stale anyway.
+	fresh, err := a.nonces.Use(record.ID+":"+request.Nonce, timestamp.Add(a.skew))
+	if err != nil {
+		return APIKey{}, fmt.Errorf("failed to check request nonce: %w", err)
+	}
+	if !fresh {
+		return APIKey{}, ErrNonceReused
+	}
+
+	return a.use(record, clientIP)
+}
+
+// MemoryNonceCache keeps nonces in process memory
+type MemoryNonceCache struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryNonceCache creates an empty nonce cache
+func NewMemoryNonceCache() *MemoryNonceCache {
+	return &MemoryNonceCache{
+		nonces:    make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Use records a nonce until expiresAt and reports whether it was unused
+func (n *MemoryNonceCache) Use(nonce string, expiresAt time.Time) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(n.lastSweep) > sweepInterval {
+		for id, exp := range n.nonces {
+			if !exp.After(now) {
+				delete(n.nonces, id)
+			}
+		}
+		n.lastSweep = now
+	}
+
+	if exp, ok := n.nonces[nonce]; ok && exp.After(now) {
+		return false, nil
+	}
+	n.nonces[nonce] = expiresAt
+	return true, nil
+}
+
+// record marks a nonce used until expiresAt, keeping the later expiry if it
+// was already used
+func (n *MemoryNonceCache) record(nonce string, expiresAt time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if exp, ok := n.nonces[nonce]; !ok || expiresAt.After(exp) {
+		n.nonces[nonce] = expiresAt
+	}
+}
+
+// all returns the nonces that have not expired
+func (n *MemoryNonceCache) all() []usedNonce {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	nonces := make([]usedNonce, 0, len(n.nonces))
+	for nonce, exp := range n.nonces {
+		if exp.After(now) {
+			nonces = append(nonces, usedNonce{Nonce: nonce, ExpiresAt: exp})
+		}
+	}
+	return nonces
+}
+
+// nonceStoreName is the replication name of the shared nonce cache
+const nonceStoreName = "nonces"
+
+// usedNonce is a nonce sent to other replicas
+type usedNonce struct {
+	Nonce     string    `json:"nonce"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// SharedNonceCache is a NonceCache replicated between gateway replicas, so
+// a signed request accepted by one replica is rejected as a replay by the
+// others. A request replayed to another replica within the broker's
+// delivery delay may be accepted there.
+type SharedNonceCache struct {
+	local       *MemoryNonceCache
+	replication *Replication
+}
+
+// NewSharedNonceCache creates a nonce cache shared through replication
+func NewSharedNonceCache(replication *Replication) *SharedNonceCache {
+	cache := &SharedNonceCache{local: NewMemoryNonceCache(), replication: replication}
+	replication.register(nonceStoreName, cache)
+	return cache
+}
+
+// Use records a nonce on every replica and reports whether it was unused
+func (n *SharedNonceCache) Use(nonce string, expiresAt time.Time) (bool, error) {
+	fresh, err := n.local.Use(nonce, expiresAt)
+	if err != nil || !fresh {
+		return fresh, err
+	}
+	if err := n.replication.send(nonceStoreName, "use", usedNonce{Nonce: nonce, ExpiresAt: expiresAt}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// applyChange applies a change made by another replica
+func (n *SharedNonceCache) applyChange(change StoreChange) error {
+	if change.Op != "use" {
+		return unknownChange(change)
+	}
+	var used usedNonce
+	if err := json.Unmarshal(change.Data, &used); err != nil {
+		return err
+	}
+	n.local.record(used.Nonce, used.ExpiresAt)
+	return nil
+}
+
+// snapshot returns the nonces this replica has seen that are still in use
+func (n *SharedNonceCache) snapshot() []StoreChange {
+	nonces := n.local.all()
+	changes := make([]StoreChange, len(nonces))
+	for i, used := range nonces {
+		changes[i] = storeChange("use", used)
+	}
+	return changes
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signRequest signs request with secret the way a client does
+func signRequest(request SignedRequest, secret string) SignedRequest {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(request.StringToSign()))
+	request.Signature = hex.EncodeToString(mac.Sum(nil))
+	return request
+}
+
+// newSigningTestKey creates a key in a fresh store using nonces and returns
+// the key manager, the key's record and its signing secret
+func newSigningTestKey(t *testing.T, nonces NonceCache) (*APIKeys, APIKey, string) {
+	t.Helper()
+
+	store, err := NewFileAPIKeyStore(filepath.Join(t.TempDir(), "api-keys.json"))
+	if err != nil {
+		t.Fatalf("NewFileAPIKeyStore: %v", err)
+	}
+	keys := NewAPIKeys(store, []byte("signing-secret"), 30*time.Second, nonces)
+	_, record, err := keys.Create(APIKeyRequest{UserID: "u-alice", Scopes: []string{ScopeTrade}, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	secret, err := keys.SigningSecret(record.ID)
+	if err != nil {
+		t.Fatalf("SigningSecret: %v", err)
+	}
+	return keys, record, secret
+}
+
+func TestVerifySignature(t *testing.T) {
+	keys, record, secret := newSigningTestKey(t, NewMemoryNonceCache())
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request := func(nonce string) SignedRequest {
+		return SignedRequest{
+			KeyID:     record.ID,
+			Timestamp: now,
+			Nonce:     nonce,
+			Method:    "POST",
+			Path:      "/api/v1/trade/execute/order?dry=1",
+			Body:      []byte(`{"symbol":"BTC-USD"}`),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		request SignedRequest
+		want    error
+	}{
+		{"valid", signRequest(request("n1"), secret), nil},
+		{"replayed nonce", signRequest(request("n1"), secret), ErrNonceReused},
+		{"wrong secret", signRequest(request("n2"), "guess"), ErrInvalidSignature},
+		{"tampered body", func() SignedRequest {
+			r := signRequest(request("n3"), secret)
+			r.Body = []byte(`{"symbol":"ETH-USD"}`)
+			return r
+		}(), ErrInvalidSignature},
+		{"tampered path", func() SignedRequest {
+			r := signRequest(request("n4"), secret)
+			r.Path = "/api/v1/trade/execute/order"
+			return r
+		}(), ErrInvalidSignature},
+		{"stale timestamp", func() SignedRequest {
+			r := request("n5")
+			r.Timestamp = strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+			return signRequest(r, secret)
+		}(), ErrStaleSignature},
+		{"future timestamp", func() SignedRequest {
+			r := request("n6")
+			r.Timestamp = strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+			return signRequest(r, secret)
+		}(), ErrStaleSignature},
+		{"missing nonce", signRequest(request(""), secret), ErrNonceRequired},
+		{"missing timestamp", func() SignedRequest {
+			r := request("n7")
+			r.Timestamp = ""
+			return signRequest(r, secret)
+		}(), ErrTimestampRequired},
+		{"unknown key", func() SignedRequest {
+			r := request("n8")
+			r.KeyID = apiKeyPrefix + "000000000000"
+			return signRequest(r, secret)
+		}(), ErrInvalidAPIKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keys.VerifySignature(tt.request, "10.0.0.1")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifySignature() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidSignatureDoesNotUseNonce(t *testing.T) {
+	keys, record, secret := newSigningTestKey(t, NewMemoryNonceCache())
+	request := SignedRequest{KeyID: record.ID, Timestamp: strconv.FormatInt(time.Now().Unix(), 10), Nonce: "n1", Method: "GET", Path: "/"}
+
+	if _, err := keys.VerifySignature(signRequest(request, "guess"), "10.0.0.1"); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("VerifySignature() error = %v, want %v", err, ErrInvalidSignature)
+	}
+	if _, err := keys.VerifySignature(signRequest(request, secret), "10.0.0.1"); err != nil {
+		t.Errorf("nonce of a rejected request cannot be used: %v", err)
+	}
+}
+
+func TestSigningDisabledWithoutSecret(t *testing.T) {
+	store, _ := NewFileAPIKeyStore(filepath.Join(t.TempDir(), "api-keys.json"))
+	keys := NewAPIKeys(store, nil, 30*time.Second, NewMemoryNonceCache())
+	if _, err := keys.SigningSecret("cbk_000000000000"); !errors.Is(err, ErrSigningDisabled) {
+		t.Errorf("SigningSecret() error = %v, want %v", err, ErrSigningDisabled)
+	}
+}
+
+func TestSharedNonceCacheRejectsReplayOnOtherReplica(t *testing.T) {
+	broker := &testBroker{}
+	onA := NewSharedNonceCache(broker.join("a"))
+	onB := NewSharedNonceCache(broker.join("b"))
+	expiresAt := time.Now().Add(time.Minute)
+
+	if fresh, err := onA.Use("k1:n1", expiresAt); err != nil || !fresh {
+		t.Fatalf("Use() on the first replica = %v, %v; want fresh", fresh, err)
+	}
+	if fresh, _ := onB.Use("k1:n1", expiresAt); fresh {
+		t.Error("nonce used on one replica accepted by another")
+	}
+	if fresh, _ := onB.Use("k1:n2", expiresAt); !fresh {
+		t.Error("unused nonce rejected")
+	}
+
+	// A replica that starts later learns the nonces still in use
+	late := broker.join("c")
+	onC := NewSharedNonceCache(late)
+	if err := late.RequestSync(); err != nil {
+		t.Fatalf("RequestSync: %v", err)
+	}
+	if fresh, _ := onC.Use("k1:n1", expiresAt); fresh {
+		t.Error("nonce used before a replica started accepted by it")
+	}
+}
+
+func TestSharedNonceCacheAcrossAPIKeys(t *testing.T) {
+	broker := &testBroker{}
+	keysA, record, secret := newSigningTestKey(t, NewSharedNonceCache(broker.join("a")))
+
+	// The second replica shares the key store, as replicas share the key file
+	keysB := NewAPIKeys(keysA.store, []byte("signing-secret"), 30*time.Second, NewSharedNonceCache(broker.join("b")))
+
+	request := signRequest(SignedRequest{
+		KeyID:     record.ID,
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Nonce:     "n1",
+		Method:    "POST",
+		Path:      "/api/v1/trade/execute/order",
+	}, secret)
+
+	if _, err := keysA.VerifySignature(request, "10.0.0.1"); err != nil {
+		t.Fatalf("VerifySignature on the first replica: %v", err)
+	}
+	if _, err := keysB.VerifySignature(request, "10.0.0.1"); !errors.Is(err, ErrNonceReused) {
+		t.Errorf("replay on another replica error = %v, want %v", err, ErrNonceReused)
+	}
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication methods recorded in the amr claim (RFC 8176)
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+)
+
+// TOTP parameters (RFC 6238). Codes from one step either side of the
+// current one are accepted to allow for clock drift.
+const (
+	totpPeriod  = 30 * time.Second
+	totpDigits  = 6
+	totpModulus = 1000000 // 10^totpDigits
+	totpSkew    = 1
+)
+
+// recoveryCodeCount is how many recovery codes an enrollment gets
+const recoveryCodeCount = 10
+
+// Errors returned by MFA
+var (
+	ErrMFANotEnrolled    = errors.New("mfa not enrolled")
+	ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+	ErrInvalidMFACode    = errors.New("invalid mfa code")
+)
+
+// MFAEnrollment is a user's TOTP second factor. An enrollment is pending
+// until the user confirms it with a valid code. LastStep is the time step
+// of the last accepted code, so a code cannot be used twice. UpdatedAt is
+// set by stores shared between replicas to order changes.
+type MFAEnrollment struct {
+	UserID        string     `json:"userId"`
+	Secret        string     `json:"secret"`
+	Confirmed     bool       `json:"confirmed"`
+	RecoveryCodes []string   `json:"recoveryCodes,omitempty"`
+	LastStep      int64      `json:"lastStep"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	ConfirmedAt   *time.Time `json:"confirmedAt,omitempty"`
+	UpdatedAt     time.Time  `json:"updatedAt,omitempty"`
+}
+
+// MFAStore persists MFA enrollments by user
+type MFAStore interface {
+	// Get returns a user's enrollment
+	Get(userID string) (MFAEnrollment, error)
+	// Save stores a user's enrollment, replacing any previous one
+	Save(enrollment MFAEnrollment) error
+	// Delete removes a user's enrollment
+	Delete(userID string) error
+}
+
+// MFA enrolls users in TOTP and verifies their codes. Checks that update
+// an enrollment are serialized so a code cannot be accepted twice.
+type MFA struct {
+	store  MFAStore
+	issuer string
+	mu     sync.Mutex
+}
+
+// NewMFA creates an MFA manager. issuer names the service in
+// authenticator apps.
+func NewMFA(store MFAStore, issuer string) *MFA {
+	return &MFA{store: store, issuer: issuer}
+}
+
+// Enabled reports whether a user has a confirmed second factor
+func (m *MFA) Enabled(userID string) (bool, error) {
+	enrollment, err := m.store.Get(userID)
+	if errors.Is(err, ErrMFANotEnrolled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return enrollment.Confirmed, nil
+}
+
+// Begin starts an enrollment with a new secret and returns the secret and
+// its otpauth:// provisioning URI, usually shown as a QR code. A pending
+// enrollment is replaced; a confirmed one must be disabled first.
+func (m *MFA) Begin(userID, accountName string) (string, string, error) {
+	if enabled, err := m.Enabled(userID); err != nil {
+		return "", "", err
+	} else if enabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secretBytes := make([]byte, 20)
+	rand.Read(secretBytes)
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secretBytes)
+
+	if err := m.store.Save(MFAEnrollment{UserID: userID, Secret: secret, CreatedAt: time.Now().UTC()}); err != nil {
+		return "", "", fmt.Errorf("failed to store mfa enrollment: %w", err)
+	}
+
+	label := url.PathEscape(m.issuer + ":" + accountName)
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {m.issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(int(totpPeriod.Seconds()))},
+	}
+	return secret, "otpauth://totp/" + label + "?" + params.Encode(), nil
+}
+
+// Confirm completes a pending enrollment with a code from the user's app
+// and returns single-use recovery codes, which are only stored hashed
+func (m *MFA) Confirm(userID, code string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	enrollment, err := m.store.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	if enrollment.Confirmed {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := matchTOTP(enrollment.Secret, code, time.Now(), enrollment.LastStep)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		rand.Read(b)
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(codes[i])
+	}
+
+	now := time.Now().UTC()
+	enrollment.Confirmed = true
+	enrollment.ConfirmedAt = &now
+	enrollment.LastStep = step
+	enrollment.RecoveryCodes = hashes
+	if err := m.store.Save(enrollment); err != nil {
+		return nil, fmt.Errorf("failed to store mfa enrollment: %w", err)
+	}
+	return codes, nil
+}
+
+// Verify checks a TOTP code for a user with a confirmed enrollment
+func (m *MFA) Verify(userID, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	enrollment, err := m.confirmed(userID)
+	if err != nil {
+		return err
+	}
+
+	step, ok := matchTOTP(enrollment.Secret, code, time.Now(), enrollment.LastStep)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	enrollment.LastStep = step
+	return m.store.Save(enrollment)
+}
+
+// UseRecoveryCode accepts one of a user's recovery codes in place of a
+// TOTP code. Each recovery code works once.
+func (m *MFA) UseRecoveryCode(userID, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	enrollment, err := m.confirmed(userID)
+	if err != nil {
+		return err
+	}
+
+	hash := hashToken(strings.ToLower(strings.TrimSpace(code)))
+	for i, stored := range enrollment.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(stored)) == 1 {
+			enrollment.RecoveryCodes = append(enrollment.RecoveryCodes[:i:i], enrollment.RecoveryCodes[i+1:]...)
+			return m.store.Save(enrollment)
+		}
+	}
+	return ErrInvalidMFACode
+}
+
+// RemainingRecoveryCodes returns how many unused recovery codes a user has
+func (m *MFA) RemainingRecoveryCodes(userID string) (int, error) {
+	enrollment, err := m.confirmed(userID)
+	if err != nil {
+		return 0, err
+	}
+	return len(enrollment.RecoveryCodes), nil
+}
+
+// Disable removes a user's second factor
+func (m *MFA) Disable(userID string) error {
+	return m.store.Delete(userID)
+}
+
+// confirmed returns a user's enrollment if it has been confirmed
+func (m *MFA) confirmed(userID string) (MFAEnrollment, error) {
+	enrollment, err := m.store.Get(userID)
+	if err != nil {
+		return MFAEnrollment{}, err
+	}
+	if !enrollment.Confirmed {
+		return MFAEnrollment{}, ErrMFANotEnrolled
+	}
+	return enrollment, nil
+}
+
+// matchTOTP checks code against the steps around now and returns the
+// matching step. Steps at or before lastStep are rejected as replays.
+func matchTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the code for a time step (RFC 4226 truncation)
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"encoding/base32"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// testCode returns the code for the time step offset steps from now
+func testCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("invalid secret: %v", err)
+	}
+	return totpCode(key, time.Now().Unix()/int64(totpPeriod.Seconds())+offset)
+}
+
+// newTestMFA returns an MFA manager backed by a file store in a temporary
+// directory
+func newTestMFA(t *testing.T) *MFA {
+	t.Helper()
+
+	store, err := NewFileMFAStore(filepath.Join(t.TempDir(), "mfa.json"))
+	if err != nil {
+		t.Fatalf("NewFileMFAStore: %v", err)
+	}
+	return NewMFA(store, "CryptoBot")
+}
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	key := []byte("12345678901234567890")
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/int64(totpPeriod.Seconds())); got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := now.Unix() / int64(totpPeriod.Seconds())
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		want     bool
+	}{
+		{"current step", "081804", 0, true},
+		{"next step within skew", "050471", 0, true},
+		{"step outside skew", "287082", 0, false},
+		{"replayed step", "081804", step, false},
+		{"wrong code", "000000", 0, false},
+		{"wrong length", "81804", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := matchTOTP(rfcSecret, tt.code, now, tt.lastStep)
+			if got != tt.want {
+				t.Errorf("matchTOTP(%s) = %v, want %v", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMFAEnrollment(t *testing.T) {
+	mfa := newTestMFA(t)
+
+	secret, uri, err := mfa.Begin("u-alice", "alice")
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if !strings.HasPrefix(uri, "otpauth://totp/CryptoBot:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("provisioning URI = %s", uri)
+	}
+	if enabled, _ := mfa.Enabled("u-alice"); enabled {
+		t.Error("MFA enabled before confirmation")
+	}
+	if err := mfa.Verify("u-alice", testCode(t, secret, 0)); !errors.Is(err, ErrMFANotEnrolled) {
+		t.Errorf("Verify() before confirmation error = %v, want %v", err, ErrMFANotEnrolled)
+	}
+
+	if _, err := mfa.Confirm("u-alice", "000000"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("Confirm() with a wrong code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+	codes, err := mfa.Confirm("u-alice", testCode(t, secret, -1))
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	if enabled, _ := mfa.Enabled("u-alice"); !enabled {
+		t.Error("MFA not enabled after confirmation")
+	}
+	if _, _, err := mfa.Begin("u-alice", "alice"); !errors.Is(err, ErrMFAAlreadyEnabled) {
+		t.Errorf("Begin() when enabled error = %v, want %v", err, ErrMFAAlreadyEnabled)
+	}
+}
+
+func TestMFAVerifyRejectsReplayedCodes(t *testing.T) {
+	mfa := newTestMFA(t)
+	secret, _, _ := mfa.Begin("u-alice", "alice")
+	if _, err := mfa.Confirm("u-alice", testCode(t, secret, -1)); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	code := testCode(t, secret, 0)
+	if err := mfa.Verify("u-alice", code); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := mfa.Verify("u-alice", code); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("Verify() of a used code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+	if err := mfa.Verify("u-alice", testCode(t, secret, -1)); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("Verify() of an older code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+}
+
+func TestMFARecoveryCodes(t *testing.T) {
+	mfa := newTestMFA(t)
+	secret, _, _ := mfa.Begin("u-alice", "alice")
+	codes, err := mfa.Confirm("u-alice", testCode(t, secret, 0))
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	if err := mfa.UseRecoveryCode("u-alice", " "+strings.ToUpper(codes[0])+" "); err != nil {
+		t.Fatalf("UseRecoveryCode: %v", err)
+	}
+	if err := mfa.UseRecoveryCode("u-alice", codes[0]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("UseRecoveryCode() of a used code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+	if remaining, _ := mfa.RemainingRecoveryCodes("u-alice"); remaining != recoveryCodeCount-1 {
+		t.Errorf("%d recovery codes remain, want %d", remaining, recoveryCodeCount-1)
+	}
+
+	if err := mfa.Disable("u-alice"); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	if enabled, _ := mfa.Enabled("u-alice"); enabled {
+		t.Error("MFA enabled after Disable")
+	}
+}
+
+func TestFileMFAStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mfa.json")
+	store, err := NewFileMFAStore(path)
+	if err != nil {
+		t.Fatalf("NewFileMFAStore: %v", err)
+	}
+	mfa := NewMFA(store, "CryptoBot")
+	secret, _, _ := mfa.Begin("u-alice", "alice")
+	if _, err := mfa.Confirm("u-alice", testCode(t, secret, 0)); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	reopened, err := NewFileMFAStore(path)
+	if err != nil {
+		t.Fatalf("NewFileMFAStore: %v", err)
+	}
+	mfa = NewMFA(reopened, "CryptoBot")
+	if enabled, _ := mfa.Enabled("u-alice"); !enabled {
+		t.Fatal("MFA not enabled after reopening the store")
+	}
+
+	// The last accepted step is kept, so a code cannot be replayed across
+	// a restart
+	if err := mfa.Verify("u-alice", testCode(t, secret, 0)); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("Verify() of the confirmation code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+}
+
+// newSharedTestMFA returns an MFA manager with enrollments kept in a file
+// of their own and shared through replication
+func newSharedTestMFA(t *testing.T, replication *Replication, path string) *MFA {
+	t.Helper()
+
+	store, err := NewFileMFAStore(path)
+	if err != nil {
+		t.Fatalf("NewFileMFAStore: %v", err)
+	}
+	return NewMFA(NewSharedMFAStore(store, replication), "CryptoBot")
+}
+
+func TestSharedMFAStoreAcrossReplicas(t *testing.T) {
+	broker := &testBroker{}
+	dir := t.TempDir()
+	onA := newSharedTestMFA(t, broker.join("a"), filepath.Join(dir, "a.json"))
+	onB := newSharedTestMFA(t, broker.join("b"), filepath.Join(dir, "b.json"))
+
+	secret, _, _ := onA.Begin("u-alice", "alice")
+	codes, err := onA.Confirm("u-alice", testCode(t, secret, -1))
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if enabled, _ := onB.Enabled("u-alice"); !enabled {
+		t.Fatal("MFA not enabled on the other replica")
+	}
+
+	code := testCode(t, secret, 0)
+	if err := onA.Verify("u-alice", code); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := onB.Verify("u-alice", code); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("other replica Verify() of a used code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+	if err := onA.UseRecoveryCode("u-alice", codes[0]); err != nil {
+		t.Fatalf("UseRecoveryCode: %v", err)
+	}
+	if err := onB.UseRecoveryCode("u-alice", codes[0]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("other replica UseRecoveryCode() of a used code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+
+	if err := onB.Disable("u-alice"); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	if enabled, _ := onA.Enabled("u-alice"); enabled {
+		t.Error("MFA enabled on the other replica after Disable")
+	}
+}
+
+func TestSharedMFAStoreUpdatesStaleCopies(t *testing.T) {
+	broker := &testBroker{}
+	dir := t.TempDir()
+	onA := newSharedTestMFA(t, broker.join("a"), filepath.Join(dir, "a.json"))
+	newSharedTestMFA(t, broker.join("b"), filepath.Join(dir, "b.json"))
+
+	secret, _, _ := onA.Begin("u-alice", "alice")
+	codes, err := onA.Confirm("u-alice", testCode(t, secret, -1))
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	// Replica b restarts with its copy of the enrollment after a recovery
+	// code was used
+	broker.replicas = broker.replicas[:1]
+	if err := onA.UseRecoveryCode("u-alice", codes[0]); err != nil {
+		t.Fatalf("UseRecoveryCode: %v", err)
+	}
+	restarted := broker.join("b")
+	onB := newSharedTestMFA(t, restarted, filepath.Join(dir, "b.json"))
+	if err := restarted.RequestSync(); err != nil {
+		t.Fatalf("RequestSync: %v", err)
+	}
+
+	if err := onB.UseRecoveryCode("u-alice", codes[0]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("restarted replica UseRecoveryCode() of a used code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+	if remaining, _ := onA.RemainingRecoveryCodes("u-alice"); remaining != recoveryCodeCount-1 {
+		t.Errorf("%d recovery codes remain, want %d", remaining, recoveryCodeCount-1)
+	}
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Errors returned by user stores and Authenticate
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUserDisabled       = errors.New("user disabled")
+)
+
+// User is an account that can log in to the gateway. PasswordHash is a
+// bcrypt or argon2id hash, never a plaintext password. Teams lists the
+// teams whose bots the user may control.
+type User struct {
+	ID           string   `json:"id" yaml:"id"`
+	Username     string   `json:"username" yaml:"username"`
+	PasswordHash string   `json:"passwordHash" yaml:"passwordHash"`
+	Roles        []string `json:"roles" yaml:"roles"`
+	Teams        []string `json:"teams" yaml:"teams"`
+	Disabled     bool     `json:"disabled" yaml:"disabled"`
+}
+
+// UserStore looks up gateway users
+type UserStore interface {
+	// GetUser returns the user with the given username
+	GetUser(username string) (User, error)
+	// GetUserByID returns the user with the given id
+	GetUserByID(id string) (User, error)
+}
+
+// Authenticate checks a username and password against store. Unknown users
+// and wrong passwords both return ErrInvalidCredentials; disabled users
+// return ErrUserDisabled only once the password has been verified.
+func Authenticate(store UserStore, username, password string) (User, error) {
+	user, err := store.GetUser(username)
+	if errors.Is(err, ErrUserNotFound) {
+		// Spend the same time as a real check so usernames cannot be probed
+		VerifyPassword(dummyHash, password)
+		return User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	ok, err := VerifyPassword(user.PasswordHash, password)
+	if err != nil {
+		return User{}, err
+	}
+	if !ok {
+		return User{}, ErrInvalidCredentials
+	}
+	if user.Disabled {
+		return User{}, ErrUserDisabled
+	}
+	return user, nil
+}
+
+// MemoryUserStore is a UserStore backed by a fixed set of users, for tests
+// and local development
+type MemoryUserStore struct {
+	users userIndex
+}
+
+// NewMemoryUserStore creates a store holding users
+func NewMemoryUserStore(users ...User) (*MemoryUserStore, error) {
+	index, err := indexUsers(users)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryUserStore{users: index}, nil
+}
+
+// GetUser returns the user with the given username
+func (s *MemoryUserStore) GetUser(username string) (User, error) {
+	return s.users.byName(username)
+}
+
+// GetUserByID returns the user with the given id
+func (s *MemoryUserStore) GetUserByID(id string) (User, error) {
+	return s.users.byID(id)
+}
+
+// userIndex indexes users by username and id
+type userIndex struct {
+	names map[string]User
+	ids   map[string]User
+}
+
+// indexUsers validates users and builds the lookup maps
+func indexUsers(users []User) (userIndex, error) {
+	index := userIndex{
+		names: make(map[string]User, len(users)),
+		ids:   make(map[string]User, len(users)),
+	}
+
+	for _, user := range users {
+		if user.ID == "" || user.Username == "" {
+			return index, errors.New("user id and username are required")
+		}
+		if _, exists := index.names[user.Username]; exists {
+			return index, fmt.Errorf("duplicate username: %s", user.Username)
+		}
+		if _, exists := index.ids[user.ID]; exists {
+			return index, fmt.Errorf("duplicate user id: %s", user.ID)
+		}
+		if !user.Disabled {
+			if _, err := parseHash(user.PasswordHash); err != nil {
+				return index, fmt.Errorf("user %s: %w", user.Username, err)
+			}
+		}
+		index.names[user.Username] = user
+		index.ids[user.ID] = user
+	}
+	return index, nil
+}
+
+// byName returns the user with the given username
+func (u userIndex) byName(username string) (User, error) {
+	if user, ok := u.names[username]; ok {
+		return user, nil
+	}
+	return User{}, ErrUserNotFound
+}
+
+// byID returns the user with the given id
+func (u userIndex) byID(id string) (User, error) {
+	if user, ok := u.ids[id]; ok {
+		return user, nil
+	}
+	return User{}, ErrUserNotFound
+}
+
+// externalUserStoreName is the replication name of the external user store
+const externalUserStoreName = "external_users"
+
+// ExternalUserStore holds users who signed in through an external identity
+// provider. They have no password and are kept in process memory, so they
+// are known until the gateway restarts or they sign in again. A store
+// created with NewSharedExternalUserStore also knows the users who signed
+// in on other replicas.
+type ExternalUserStore struct {
+	mu          sync.RWMutex
+	users       map[string]User
+	replication *Replication
+}
+
+// NewExternalUserStore creates an empty store
+func NewExternalUserStore() *ExternalUserStore {
+	return &ExternalUserStore{users: make(map[string]User)}
+}
+
+// NewSharedExternalUserStore creates an empty store shared through
+// replication
+func NewSharedExternalUserStore(replication *Replication) *ExternalUserStore {
+	store := &ExternalUserStore{users: make(map[string]User), replication: replication}
+	replication.register(externalUserStoreName, store)
+	return store
+}
+
+// Put adds or replaces a user, on every replica if the store is shared
+func (s *ExternalUserStore) Put(user User) error {
+	s.put(user)
+	if s.replication == nil {
+		return nil
+	}
+	return s.replication.send(externalUserStoreName, "put", user)
+}
+
+// GetUserByID returns the user with the given id
+func (s *ExternalUserStore) GetUserByID(id string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if user, ok := s.users[id]; ok {
+		return user, nil
+	}
+	return User{}, ErrUserNotFound
+}
+
+// put adds or replaces a user on this replica
+func (s *ExternalUserStore) put(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+}
+
+// applyChange applies a change made by another replica
+func (s *ExternalUserStore) applyChange(change StoreChange) error {
+	if change.Op != "put" {
+		return unknownChange(change)
+	}
+	var user User
+	if err := json.Unmarshal(change.Data, &user); err != nil {
+		return err
+	}
+	s.put(user)
+	return nil
+}
+
+// snapshot returns the users this replica knows
+func (s *ExternalUserStore) snapshot() []StoreChange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	changes := make([]StoreChange, 0, len(s.users))
+	for _, user := range s.users {
+		changes = append(changes, storeChange("put", user))
+	}
+	return changes
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+var (
+	testHashOnce sync.Once
+	testHash     string
+)
+
+// testPasswordHash returns a bcrypt hash of "s3cret"
+func testPasswordHash(t *testing.T) string {
+	t.Helper()
+
+	testHashOnce.Do(func() {
+		var err error
+		if testHash, err = HashPassword("s3cret", HashBcrypt); err != nil {
+			t.Fatalf("HashPassword: %v", err)
+		}
+	})
+	return testHash
+}
+
+func TestAuthenticate(t *testing.T) {
+	hash := testPasswordHash(t)
+	store, err := NewMemoryUserStore(
+		User{ID: "u-alice", Username: "alice", PasswordHash: hash, Roles: []string{"user"}},
+		User{ID: "u-dave", Username: "dave", PasswordHash: hash, Disabled: true},
+	)
+	if err != nil {
+		t.Fatalf("NewMemoryUserStore: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{"valid credentials", "alice", "s3cret", nil},
+		{"wrong password", "alice", "wrong", ErrInvalidCredentials},
+		{"unknown user", "mallory", "s3cret", ErrInvalidCredentials},
+		{"disabled user with valid password", "dave", "s3cret", ErrUserDisabled},
+		{"disabled user with wrong password", "dave", "wrong", ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := Authenticate(store, tt.username, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && user.ID != "u-alice" {
+				t.Errorf("Authenticate() user = %s, want u-alice", user.ID)
+			}
+		})
+	}
+}
+
+func TestNewMemoryUserStoreValidatesUsers(t *testing.T) {
+	hash := testPasswordHash(t)
+
+	tests := []struct {
+		name  string
+		users []User
+		valid bool
+	}{
+		{"valid", []User{{ID: "1", Username: "a", PasswordHash: hash}}, true},
+		{"missing id", []User{{Username: "a", PasswordHash: hash}}, false},
+		{"missing username", []User{{ID: "1", PasswordHash: hash}}, false},
+		{"duplicate username", []User{{ID: "1", Username: "a", PasswordHash: hash}, {ID: "2", Username: "a", PasswordHash: hash}}, false},
+		{"duplicate id", []User{{ID: "1", Username: "a", PasswordHash: hash}, {ID: "1", Username: "b", PasswordHash: hash}}, false},
+		{"plaintext password", []User{{ID: "1", Username: "a", PasswordHash: "s3cret"}}, false},
+		{"disabled user without hash", []User{{ID: "1", Username: "a", Disabled: true}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMemoryUserStore(tt.users...)
+			if (err == nil) != tt.valid {
+				t.Errorf("NewMemoryUserStore() error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestMemoryUserStoreLookups(t *testing.T) {
+	store, err := NewMemoryUserStore(User{ID: "u-alice", Username: "alice", PasswordHash: testPasswordHash(t)})
+	if err != nil {
+		t.Fatalf("NewMemoryUserStore: %v", err)
+	}
+
+	if user, err := store.GetUser("alice"); err != nil || user.ID != "u-alice" {
+		t.Errorf("GetUser(alice) = %v, %v", user.ID, err)
+	}
+	if user, err := store.GetUserByID("u-alice"); err != nil || user.Username != "alice" {
+		t.Errorf("GetUserByID(u-alice) = %v, %v", user.Username, err)
+	}
+	if _, err := store.GetUser("bob"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUser(bob) error = %v, want ErrUserNotFound", err)
+	}
+}
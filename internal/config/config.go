@@ -2,8 +2,10 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 )
 
 // Config represents the complete gateway configuration
@@ -15,10 +17,276 @@ type Config struct {
 
 // APIGatewayConfig contains basic gateway settings
 type APIGatewayConfig struct {
-	ListenPort   int      `json:"listenPort"`
-	LogLevel     string   `json:"logLevel"`
-	CorsOrigins  []string `json:"corsOrigins"`
-	JWTSecretKey string   `json:"jwtSecretKey"`
+	ListenPort      int                   `json:"listenPort"`
+	LogLevel        string                `json:"logLevel"`
+	CorsOrigins     []string              `json:"corsOrigins"`
+	TrustedProxies  []string              `json:"trustedProxies"`
+	JWTSecretKey    string                `json:"jwtSecretKey"`
+	Signing         SigningConfig         `json:"signing"`
+	Authorization   AuthorizationConfig   `json:"authorization"`
+	WebSocket       WebSocketConfig       `json:"webSocket"`
+	OfflineQueue    OfflineQueueConfig    `json:"offlineQueue"`
+	Users           UsersConfig           `json:"users"`
+	Tokens          TokenConfig           `json:"tokens"`
+	Revocation      RevocationConfig      `json:"revocation"`
+	Replication     ReplicationConfig     `json:"replication"`
+	APIKeys         APIKeysConfig         `json:"apiKeys"`
+	OIDC            OIDCConfig            `json:"oidc"`
+	MFA             MFAConfig             `json:"mfa"`
+	LoginProtection LoginProtectionConfig `json:"loginProtection"`
+	Bots            BotsConfig            `json:"bots"`
+}
+
+// SigningConfig lists the asymmetric keys that sign and verify tokens.
+// ActiveKeyID signs new tokens; the other keys keep verifying until their
+// RetiresAt. With no keys, tokens are signed with HS256 and JWTSecretKey,
+// which is only suitable for development.
+type SigningConfig struct {
+	ActiveKeyID string             `json:"activeKeyId"`
+	Keys        []SigningKeyConfig `json:"keys"`
+}
+
+// SigningKeyConfig is an RSA (RS256) or ECDSA P-256 (ES256) key in a PEM
+// file, given as a private key or, for keys that only verify, a public key
+type SigningKeyConfig struct {
+	ID        string    `json:"id"`
+	File      string    `json:"file"`
+	RetiresAt time.Time `json:"retiresAt"`
+}
+
+// AuthorizationConfig maps roles to the permissions they grant and lists
+// the roles or permissions each command requires. The "*" permission grants
+// everything.
+type AuthorizationConfig struct {
+	RolePermissions map[string][]string `json:"rolePermissions"`
+	Commands        map[string][]string `json:"commands"`
+}
+
+// UsersConfig locates the users file. Its format is chosen by extension
+// (.yaml/.yml or .json) and it is reloaded when it changes.
+type UsersConfig struct {
+	File          string `json:"file"`
+	ReloadSeconds int    `json:"reloadSeconds"`
+}
+
+// TokenConfig sets credential lifetimes. Access tokens are short-lived JWTs;
+// refresh tokens are opaque, rotate on every use and cannot extend a login
+// session beyond SessionMaxHours.
+//
+// Issuer and Audience are set on issued tokens and, when not empty, required
+// on tokens presented to the gateway. ClockSkewSeconds is the leeway allowed
+// when checking exp, nbf and iat. With NotBeforeRequired, tokens without an
+// nbf claim are rejected.
+//
+// InternalTokenSeconds is the lifetime of tokens minted for internal
+// services that take the caller's identity as a token.
+type TokenConfig struct {
+	AccessTokenMinutes   int    `json:"accessTokenMinutes"`
+	RefreshTokenHours    int    `json:"refreshTokenHours"`
+	SessionMaxHours      int    `json:"sessionMaxHours"`
+	Issuer               string `json:"issuer"`
+	Audience             string `json:"audience"`
+	ClockSkewSeconds     int    `json:"clockSkewSeconds"`
+	NotBeforeRequired    bool   `json:"notBeforeRequired"`
+	InternalTokenSeconds int    `json:"internalTokenSeconds"`
+}
+
+// RevocationConfig selects where revoked tokens are tracked. The "memory"
+// backend is local to one replica; "shared" replicates revocations to the
+// other replicas over Topic on the message broker.
+type RevocationConfig struct {
+	Backend string `json:"backend"`
+	Topic   string `json:"topic"`
+}
+
+// ReplicationConfig selects where login state such as refresh tokens is
+// kept. The "memory" backend is local to one replica, so clients must keep
+// talking to the replica they logged in on; "shared" replicates the state
+// to the other replicas over Topic on the message broker.
+type ReplicationConfig struct {
+	Backend string `json:"backend"`
+	Topic   string `json:"topic"`
+}
+
+// APIKeysConfig controls API keys for scripts and bots. Keys are stored
+// hashed in File. ScopePermissions lists the permissions each scope allows;
+// a key's caller also needs them through their own roles.
+//
+// Requests may instead be signed with a per-key secret derived from
+// SigningSecret; signing is disabled when it is empty. Signed timestamps may
+// be off by SignatureSkewSeconds. API key requests to paths starting with
+// one of SignedPaths must be signed.
+type APIKeysConfig struct {
+	File                 string              `json:"file"`
+	MaxPerUser           int                 `json:"maxPerUser"`
+	MaxLifetimeDays      int                 `json:"maxLifetimeDays"`
+	ScopePermissions     map[string][]string `json:"scopePermissions"`
+	SigningSecret        string              `json:"signingSecret"`
+	SignatureSkewSeconds int                 `json:"signatureSkewSeconds"`
+	SignedPaths          []string            `json:"signedPaths"`
+}
+
+// OIDCConfig enables login through an external OpenID Connect provider.
+// Users' GroupsClaim values are mapped to gateway roles by GroupRoles; users
+// whose groups map to no role cannot sign in. Usernames are read from
+// UsernameClaim, falling back to the email and then the subject.
+// StateSecret signs the cookie that carries a pending login to the
+// callback; it defaults to JWTSecretKey and must be the same on every
+// replica.
+type OIDCConfig struct {
+	Enabled       bool                `json:"enabled"`
+	IssuerURL     string              `json:"issuerUrl"`
+	ClientID      string              `json:"clientId"`
+	ClientSecret  string              `json:"clientSecret"`
+	RedirectURL   string              `json:"redirectUrl"`
+	Scopes        []string            `json:"scopes"`
+	GroupsClaim   string              `json:"groupsClaim"`
+	UsernameClaim string              `json:"usernameClaim"`
+	GroupRoles    map[string][]string `json:"groupRoles"`
+	StateSecret   string              `json:"stateSecret"`
+}
+
+// MFAConfig controls TOTP second factors. Enrollments, including their
+// secrets, are stored in File; Issuer names the gateway in authenticator
+// apps.
+type MFAConfig struct {
+	File   string       `json:"file"`
+	Issuer string       `json:"issuer"`
+	StepUp StepUpConfig `json:"stepUp"`
+}
+
+// StepUpConfig lists sensitive operations that need a second factor from
+// the last MaxAgeMinutes: requests to paths starting with one of Paths and
+// the given Commands over HTTP and WebSocket. API keys have no second
+// factor; APIKeys is "exclude" to refuse them these operations, or "signed"
+// to allow them only in signed requests.
+type StepUpConfig struct {
+	MaxAgeMinutes int      `json:"maxAgeMinutes"`
+	Paths         []string `json:"paths"`
+	Commands      []string `json:"commands"`
+	APIKeys       string   `json:"apiKeys"`
+}
+
+// LoginProtectionConfig throttles password guessing at /auth/login.
+// Failures are counted per username and per client address over
+// WindowMinutes. After each failure the username's next attempt must wait
+// BaseDelayMs, doubling per failure up to MaxDelayMs. Reaching MaxFailures
+// locks the username or address out for LockoutMinutes.
+type LoginProtectionConfig struct {
+	WindowMinutes int           `json:"windowMinutes"`
+	BaseDelayMs   int           `json:"baseDelayMs"`
+	MaxDelayMs    int           `json:"maxDelayMs"`
+	Username      LockoutConfig `json:"username"`
+	IP            LockoutConfig `json:"ip"`
+}
+
+// LockoutConfig sets when a username or client address is locked out
+type LockoutConfig struct {
+	MaxFailures    int `json:"maxFailures"`
+	LockoutMinutes int `json:"lockoutMinutes"`
+}
+
+// BotsConfig controls who may control each bot. Ownership and sharing
+// grants are stored in File. Owners are also learned from events on
+// RegistryTopic that carry a botId with an ownerId or teamId.
+type BotsConfig struct {
+	File          string `json:"file"`
+	RegistryTopic string `json:"registryTopic"`
+}
+
+// OfflineQueueConfig controls persistence of private events for offline
+// users. Only events on Channels are queued. Path is a log local to each
+// replica, so it must be on a volume that survives restarts of the replica.
+type OfflineQueueConfig struct {
+	Enabled    bool     `json:"enabled"`
+	Path       string   `json:"path"`
+	MaxPerUser int      `json:"maxPerUser"`
+	TTLHours   int      `json:"ttlHours"`
+	Channels   []string `json:"channels"`
+}
+
+// WebSocketConfig contains settings for the WebSocket hub
+type WebSocketConfig struct {
+	SendBufferSize            int                      `json:"sendBufferSize"`
+	DefaultPolicy             string                   `json:"defaultPolicy"`
+	ChannelPolicies           map[string]ChannelPolicy `json:"channelPolicies"`
+	Compression               CompressionConfig        `json:"compression"`
+	ReplayBufferSize          int                      `json:"replayBufferSize"`
+	SSEKeepAliveSeconds       int                      `json:"sseKeepAliveSeconds"`
+	Limits                    ConnectionLimits         `json:"limits"`
+	TokenExpiryWarningSeconds int                      `json:"tokenExpiryWarningSeconds"`
+	Presence                  PresenceConfig           `json:"presence"`
+	Inbound                   InboundConfig            `json:"inbound"`
+	Shutdown                  ShutdownConfig           `json:"shutdown"`
+	Admin                     AdminConfig              `json:"admin"`
+}
+
+// AdminConfig controls admin requests that list or disconnect sessions on
+// every replica. Replicas exchange requests and replies over Topic, and a
+// request waits up to TimeoutMs for the replicas known from presence.
+type AdminConfig struct {
+	Topic     string `json:"topic"`
+	TimeoutMs int    `json:"timeoutMs"`
+}
+
+// ShutdownConfig controls how clients are disconnected when the gateway
+// stops. Pending messages are flushed for up to DrainTimeoutSeconds, and each
+// client is told to wait a random delay between RetryAfterMinMs and
+// RetryAfterMaxMs before reconnecting so replicas are not stampeded.
+type ShutdownConfig struct {
+	DrainTimeoutSeconds int `json:"drainTimeoutSeconds"`
+	RetryAfterMinMs     int `json:"retryAfterMinMs"`
+	RetryAfterMaxMs     int `json:"retryAfterMaxMs"`
+}
+
+// InboundConfig limits messages sent by WebSocket clients. MaxBytesByType
+// overrides DefaultMaxBytes for individual message types. Clients with more
+// than MaxViolations rejected messages within ViolationWindowSeconds are
+// disconnected.
+type InboundConfig struct {
+	MessagesPerSecond      float64        `json:"messagesPerSecond"`
+	Burst                  int            `json:"burst"`
+	DefaultMaxBytes        int            `json:"defaultMaxBytes"`
+	MaxBytesByType         map[string]int `json:"maxBytesByType"`
+	MaxViolations          int            `json:"maxViolations"`
+	ViolationWindowSeconds int            `json:"violationWindowSeconds"`
+}
+
+// PresenceConfig controls how presence is shared between gateway replicas.
+// ReplicaID defaults to the POD_NAME environment variable or the hostname.
+type PresenceConfig struct {
+	ReplicaID        string `json:"replicaId"`
+	Topic            string `json:"topic"`
+	HeartbeatSeconds int    `json:"heartbeatSeconds"`
+}
+
+// ConnectionLimits caps WebSocket and event stream connections. Zero
+// values disable the corresponding limit.
+type ConnectionLimits struct {
+	MaxConnections    int     `json:"maxConnections"`
+	MaxPerUser        int     `json:"maxPerUser"`
+	MaxPerIP          int     `json:"maxPerIp"`
+	UpgradesPerSecond float64 `json:"upgradesPerSecond"`
+	UpgradeBurst      int     `json:"upgradeBurst"`
+}
+
+// CompressionConfig controls permessage-deflate on WebSocket connections.
+// Messages smaller than Threshold bytes are sent uncompressed. Level is a
+// pointer so an explicit 0 (no compression) can be told apart from unset,
+// which defaults to 1.
+type CompressionConfig struct {
+	Enabled   bool `json:"enabled"`
+	Level     *int `json:"level,omitempty"`
+	Threshold int  `json:"threshold"`
+}
+
+// ChannelPolicy describes how a slow client's queue is handled for a channel.
+// Policy is one of "disconnect", "drop-oldest", "drop-newest" or "conflate".
+// ConflateKey names the data field used to coalesce messages when conflating,
+// and is required for it. Other policies are rejected when loading.
+type ChannelPolicy struct {
+	Policy      string `json:"policy"`
+	ConflateKey string `json:"conflateKey"`
 }
 
 // ServiceDependencies contains information about internal services
@@ -35,11 +303,22 @@ type MessageBroker struct {
 	PublishQueues    []string `json:"publishQueues"`
 }
 
-// InternalService represents a microservice in the cluster
+// InternalService represents a microservice in the cluster. Require lists
+// the roles or permissions that may call it (any one suffices; empty allows
+// every user signed in with a token but no API key), and MethodRequire
+// overrides it per HTTP method.
+//
+// Identity selects how the caller is passed to the service: "headers" sets
+// X-User-ID, X-Username and X-User-Roles, and "token" sends a short-lived
+// token signed by the gateway for Audience, which defaults to Name.
 type InternalService struct {
-	Name        string `json:"name"`
-	RoutePrefix string `json:"routePrefix"`
-	TargetURL   string `json:"targetUrl"`
+	Name          string              `json:"name"`
+	RoutePrefix   string              `json:"routePrefix"`
+	TargetURL     string              `json:"targetUrl"`
+	Require       []string            `json:"require"`
+	MethodRequire map[string][]string `json:"methodRequire"`
+	Identity      string              `json:"identity"`
+	Audience      string              `json:"audience"`
 }
 
 // UIService represents the UI service configuration
@@ -92,6 +371,22 @@ func LoadConfig(configPath string) (*Config, error) {
 		config.APIGatewayConfig.JWTSecretKey = jwtSecret
 	}
 
+	if signingSecret := os.Getenv("API_KEY_SIGNING_SECRET"); signingSecret != "" {
+		config.APIGatewayConfig.APIKeys.SigningSecret = signingSecret
+	}
+
+	if oidcSecret := os.Getenv("OIDC_CLIENT_SECRET"); oidcSecret != "" {
+		config.APIGatewayConfig.OIDC.ClientSecret = oidcSecret
+	}
+
+	if stateSecret := os.Getenv("OIDC_STATE_SECRET"); stateSecret != "" {
+		config.APIGatewayConfig.OIDC.StateSecret = stateSecret
+	}
+
+	if usersFile := os.Getenv("USERS_FILE"); usersFile != "" {
+		config.APIGatewayConfig.Users.File = usersFile
+	}
+
 	if brokerURL := os.Getenv("MESSAGE_BROKER_URL"); brokerURL != "" {
 		config.ServiceDependencies.MessageBroker.URL = brokerURL
 	}
@@ -104,9 +399,259 @@ func LoadConfig(configPath string) (*Config, error) {
 		config.ExternalDependencies.CoinbaseAPI.APISecretSecretRef = coinbaseSecret
 	}
 
+	applyDefaults(&config)
+	if err := validate(&config); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
 	return &config, nil
 }
 
+// applyDefaults fills in settings that were not provided
+func applyDefaults(config *Config) {
+	users := &config.APIGatewayConfig.Users
+	if users.File == "" {
+		users.File = "./config/users.yaml"
+	}
+	if users.ReloadSeconds <= 0 {
+		users.ReloadSeconds = 10
+	}
+
+	tokens := &config.APIGatewayConfig.Tokens
+	if tokens.AccessTokenMinutes <= 0 {
+		tokens.AccessTokenMinutes = 15
+	}
+	if tokens.RefreshTokenHours <= 0 {
+		tokens.RefreshTokenHours = 24
+	}
+	if tokens.SessionMaxHours <= 0 {
+		tokens.SessionMaxHours = 720
+	}
+	if tokens.ClockSkewSeconds < 0 {
+		tokens.ClockSkewSeconds = 0
+	}
+	if tokens.InternalTokenSeconds <= 0 {
+		tokens.InternalTokenSeconds = 60
+	}
+
+	for i := range config.ServiceDependencies.InternalServices {
+		service := &config.ServiceDependencies.InternalServices[i]
+		if service.Identity == "" {
+			service.Identity = "headers"
+		}
+		if service.Audience == "" {
+			service.Audience = service.Name
+		}
+	}
+
+	revocation := &config.APIGatewayConfig.Revocation
+	if revocation.Backend == "" {
+		revocation.Backend = "shared"
+	}
+	if revocation.Topic == "" {
+		revocation.Topic = "topic://gateway.revocations"
+	}
+
+	replication := &config.APIGatewayConfig.Replication
+	if replication.Backend == "" {
+		replication.Backend = "shared"
+	}
+	if replication.Topic == "" {
+		replication.Topic = "topic://gateway.state"
+	}
+
+	apiKeys := &config.APIGatewayConfig.APIKeys
+	if apiKeys.File == "" {
+		apiKeys.File = "./data/api-keys.json"
+	}
+	if apiKeys.MaxPerUser <= 0 {
+		apiKeys.MaxPerUser = 10
+	}
+	if apiKeys.MaxLifetimeDays <= 0 {
+		apiKeys.MaxLifetimeDays = 365
+	}
+	if apiKeys.SignatureSkewSeconds <= 0 {
+		apiKeys.SignatureSkewSeconds = 30
+	}
+	if apiKeys.ScopePermissions == nil {
+		apiKeys.ScopePermissions = map[string][]string{
+			"read":     {"report:read", "portfolio:read", "order:read"},
+			"trade":    {"trade:execute", "order:cancel"},
+			"commands": {"bot:control"},
+		}
+	}
+
+	oidc := &config.APIGatewayConfig.OIDC
+	if oidc.Scopes == nil {
+		oidc.Scopes = []string{"openid", "profile", "email", "groups"}
+	}
+	if oidc.GroupsClaim == "" {
+		oidc.GroupsClaim = "groups"
+	}
+	if oidc.UsernameClaim == "" {
+		oidc.UsernameClaim = "preferred_username"
+	}
+	if oidc.StateSecret == "" {
+		oidc.StateSecret = config.APIGatewayConfig.JWTSecretKey
+	}
+
+	mfa := &config.APIGatewayConfig.MFA
+	if mfa.File == "" {
+		mfa.File = "./data/mfa.json"
+	}
+	if mfa.Issuer == "" {
+		mfa.Issuer = "CryptoBot"
+	}
+	if mfa.StepUp.MaxAgeMinutes <= 0 {
+		mfa.StepUp.MaxAgeMinutes = 5
+	}
+	if mfa.StepUp.APIKeys == "" {
+		mfa.StepUp.APIKeys = "exclude"
+	}
+
+	login := &config.APIGatewayConfig.LoginProtection
+	if login.WindowMinutes <= 0 {
+		login.WindowMinutes = 15
+	}
+	if login.BaseDelayMs <= 0 {
+		login.BaseDelayMs = 500
+	}
+	if login.MaxDelayMs < login.BaseDelayMs {
+		login.MaxDelayMs = 8000
+	}
+	if login.Username.MaxFailures <= 0 {
+		login.Username.MaxFailures = 5
+	}
+	if login.Username.LockoutMinutes <= 0 {
+		login.Username.LockoutMinutes = 15
+	}
+	if login.IP.MaxFailures <= 0 {
+		login.IP.MaxFailures = 50
+	}
+	if login.IP.LockoutMinutes <= 0 {
+		login.IP.LockoutMinutes = 15
+	}
+
+	bots := &config.APIGatewayConfig.Bots
+	if bots.File == "" {
+		bots.File = "./data/bots.json"
+	}
+	if bots.RegistryTopic == "" {
+		bots.RegistryTopic = "topic://system.registry.online"
+	}
+
+	offlineQueue := &config.APIGatewayConfig.OfflineQueue
+	if offlineQueue.Path == "" {
+		offlineQueue.Path = "./data/offline-events.log"
+	}
+	if offlineQueue.MaxPerUser <= 0 {
+		offlineQueue.MaxPerUser = 500
+	}
+	if offlineQueue.TTLHours <= 0 {
+		offlineQueue.TTLHours = 168
+	}
+	if offlineQueue.Channels == nil {
+		offlineQueue.Channels = []string{"trades.filled"}
+	}
+
+	ws := &config.APIGatewayConfig.WebSocket
+	if ws.SendBufferSize <= 0 {
+		ws.SendBufferSize = 256
+	}
+	if ws.DefaultPolicy == "" {
+		ws.DefaultPolicy = "disconnect"
+	}
+	if ws.Compression.Level == nil {
+		level := 1
+		ws.Compression.Level = &level
+	}
+	if ws.ReplayBufferSize <= 0 {
+		ws.ReplayBufferSize = 1000
+	}
+	if ws.SSEKeepAliveSeconds <= 0 {
+		ws.SSEKeepAliveSeconds = 15
+	}
+	if ws.TokenExpiryWarningSeconds <= 0 {
+		ws.TokenExpiryWarningSeconds = 60
+	}
+	if ws.Presence.ReplicaID == "" {
+		ws.Presence.ReplicaID = os.Getenv("POD_NAME")
+	}
+	if ws.Presence.ReplicaID == "" {
+		ws.Presence.ReplicaID, _ = os.Hostname()
+	}
+	if ws.Presence.Topic == "" {
+		ws.Presence.Topic = "topic://gateway.presence"
+	}
+	if ws.Presence.HeartbeatSeconds <= 0 {
+		ws.Presence.HeartbeatSeconds = 15
+	}
+	if ws.Admin.Topic == "" {
+		ws.Admin.Topic = "topic://gateway.admin"
+	}
+	if ws.Admin.TimeoutMs <= 0 {
+		ws.Admin.TimeoutMs = 2000
+	}
+	if ws.Inbound.MessagesPerSecond <= 0 {
+		ws.Inbound.MessagesPerSecond = 10
+	}
+	if ws.Inbound.Burst <= 0 {
+		ws.Inbound.Burst = 20
+	}
+	if ws.Inbound.DefaultMaxBytes <= 0 {
+		ws.Inbound.DefaultMaxBytes = 512
+	}
+	if ws.Inbound.MaxBytesByType == nil {
+		ws.Inbound.MaxBytesByType = map[string]int{"start_bot": 4096, "reauth": 4096, "mark_read": 4096}
+	}
+	if ws.Inbound.MaxViolations <= 0 {
+		ws.Inbound.MaxViolations = 10
+	}
+	if ws.Inbound.ViolationWindowSeconds <= 0 {
+		ws.Inbound.ViolationWindowSeconds = 60
+	}
+	if ws.Shutdown.DrainTimeoutSeconds <= 0 {
+		ws.Shutdown.DrainTimeoutSeconds = 10
+	}
+	if ws.Shutdown.RetryAfterMinMs <= 0 {
+		ws.Shutdown.RetryAfterMinMs = 1000
+	}
+	if ws.Shutdown.RetryAfterMaxMs < ws.Shutdown.RetryAfterMinMs {
+		ws.Shutdown.RetryAfterMaxMs = ws.Shutdown.RetryAfterMinMs + 9000
+	}
+}
+
+// backpressurePolicies are the WebSocket queue policies a channel may use
+var backpressurePolicies = map[string]bool{
+	"disconnect":  true,
+	"drop-oldest": true,
+	"drop-newest": true,
+	"conflate":    true,
+}
+
+// validate rejects settings that cannot work, after defaults are applied
+func validate(config *Config) error {
+	ws := config.APIGatewayConfig.WebSocket
+	if level := ws.Compression.Level; level != nil && (*level < -2 || *level > 9) {
+		return fmt.Errorf("webSocket.compression.level: %d is outside -2..9", *level)
+	}
+	if !backpressurePolicies[ws.DefaultPolicy] {
+		return fmt.Errorf("webSocket.defaultPolicy: unknown policy %q", ws.DefaultPolicy)
+	}
+	if ws.DefaultPolicy == "conflate" {
+		return errors.New("webSocket.defaultPolicy: conflate needs a conflateKey, so it may only be set per channel")
+	}
+	for channel, policy := range ws.ChannelPolicies {
+		if !backpressurePolicies[policy.Policy] {
+			return fmt.Errorf("webSocket.channelPolicies.%s: unknown policy %q", channel, policy.Policy)
+		}
+		if policy.Policy == "conflate" && policy.ConflateKey == "" {
+			return fmt.Errorf("webSocket.channelPolicies.%s: conflate requires a conflateKey", channel)
+		}
+	}
+	return nil
+}
+
 // GetServiceByRoutePrefix finds an internal service by its route prefix
 func (c *Config) GetServiceByRoutePrefix(prefix string) *InternalService {
 	for _, service := range c.ServiceDependencies.InternalServices {
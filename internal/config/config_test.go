@@ -0,0 +1,66 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateChannelPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback string
+		policies map[string]ChannelPolicy
+		wantErr  string
+	}{
+		{"known policies", "drop-oldest", map[string]ChannelPolicy{
+			"market.data.live": {Policy: "conflate", ConflateKey: "symbol"},
+			"trades.filled":    {Policy: "disconnect"},
+		}, ""},
+		{"unknown default policy", "drop-all", nil, "unknown policy"},
+		{"conflate as the default policy", "conflate", nil, "conflateKey"},
+		{"unknown channel policy", "disconnect", map[string]ChannelPolicy{"orders": {Policy: "dropoldest"}}, "unknown policy"},
+		{"conflate without a key", "disconnect", map[string]ChannelPolicy{"market.data.live": {Policy: "conflate"}}, "conflateKey"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			config.APIGatewayConfig.WebSocket.DefaultPolicy = tt.fallback
+			config.APIGatewayConfig.WebSocket.ChannelPolicies = tt.policies
+
+			err := validate(&config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompressionLevelDefault(t *testing.T) {
+	zero := 0
+	tests := []struct {
+		name  string
+		level *int
+		want  int
+	}{
+		{"unset", nil, 1},
+		{"explicit zero", &zero, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			config.APIGatewayConfig.WebSocket.Compression.Level = tt.level
+			applyDefaults(&config)
+			if got := *config.APIGatewayConfig.WebSocket.Compression.Level; got != tt.want {
+				t.Errorf("level = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"net/http"
+
+	"cryptobot-api-gateway/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// DisconnectRequest carries the reason sent to disconnected clients
+type DisconnectRequest struct {
+	Reason string `json:"reason"`
+}
+
+// setupAdminRoutes adds admin-only routes to the router
+func (g *Gateway) setupAdminRoutes(router *gin.Engine) {
+	admin := router.Group("/admin")
+	{
+		admin.Use(g.authMiddleware(), g.requireRole("admin"))
+		admin.GET("/sessions", g.handleListSessions)
+		admin.DELETE("/sessions/:id", g.handleDisconnectSession)
+		admin.DELETE("/users/:userId/sessions", g.handleDisconnectUser)
+		admin.GET("/login-sessions", g.handleListLoginSessions)
+		admin.DELETE("/login-sessions/:id", g.handleRevokeLoginSession)
+		admin.GET("/bots", g.handleListBots)
+		admin.PUT("/bots/:botId/owner", g.handleSetBotOwner)
+		admin.POST("/bots/:botId/shares", g.handleShareBot)
+		admin.DELETE("/bots/:botId/shares/:userId", g.handleUnshareBot)
+		admin.GET("/lockouts", g.handleListLockouts)
+		admin.DELETE("/lockouts/users/:username", g.handleUnlockUser)
+		admin.DELETE("/lockouts/ips/:ip", g.handleUnlockIP)
+	}
+}
+
+// handleListSessions lists live WebSocket and SSE sessions on every replica,
+// optionally filtered by ?userId=. Replicas that did not answer in time are
+// listed under missingReplicas.
+func (g *Gateway) handleListSessions(c *gin.Context) {
+	userID := c.Query("userId")
+
+	result := g.wsHub.ListSessions()
+	sessions := make([]websocket.ClientStats, 0)
+	for _, session := range result.Sessions {
+		if userID == "" || session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+
+	g.auditAdminAction(c, "list_sessions", logrus.Fields{"filter_user_id": userID, "count": len(sessions), "missing_replicas": result.Missing})
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions, "replicas": result.Replicas, "missingReplicas": result.Missing})
+}
+
+// handleDisconnectSession force-disconnects a single session on whichever
+// replica holds it
+func (g *Gateway) handleDisconnectSession(c *gin.Context) {
+	sessionID := c.Param("id")
+	reason := disconnectReason(c)
+
+	result := g.wsHub.DisconnectClientEverywhere(sessionID, reason)
+	if result.Disconnected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found", "missingReplicas": result.Missing})
+		return
+	}
+
+	g.auditAdminAction(c, "disconnect_session", logrus.Fields{"session_id": sessionID, "reason": reason})
+	c.JSON(http.StatusOK, gin.H{"message": "Session disconnected", "sessionId": sessionID})
+}
+
+// handleDisconnectUser force-disconnects all sessions of a user on every
+// replica
+func (g *Gateway) handleDisconnectUser(c *gin.Context) {
+	userID := c.Param("userId")
+	reason := disconnectReason(c)
+
+	result := g.wsHub.DisconnectUserEverywhere(userID, reason)
+
+	g.auditAdminAction(c, "disconnect_user", logrus.Fields{"target_user_id": userID, "reason": reason, "count": result.Disconnected, "missing_replicas": result.Missing})
+	c.JSON(http.StatusOK, gin.H{"message": "User sessions disconnected", "userId": userID, "disconnected": result.Disconnected, "missingReplicas": result.Missing})
+}
+
+// disconnectReason reads the reason from the request body, falling back to a
+// default. It is shortened to fit in a WebSocket close frame.
+func disconnectReason(c *gin.Context) string {
+	var request DisconnectRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.Reason == "" {
+		return "Disconnected by administrator"
+	}
+	return websocket.TruncateCloseReason(request.Reason)
+}
+
+// auditAdminAction records an admin action in the log
+func (g *Gateway) auditAdminAction(c *gin.Context, action string, fields logrus.Fields) {
+	admin := CurrentUser(c)
+
+	fields["action"] = action
+	fields["admin_user_id"] = admin.UserID
+	fields["admin_username"] = admin.Username
+	fields["client_ip"] = c.ClientIP()
+	g.logger.WithFields(fields).Info("Admin action")
+}
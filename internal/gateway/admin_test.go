@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/config"
+	"cryptobot-api-gateway/internal/websocket"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+func TestWebSocketMetricsRequireAdmin(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	tests := []struct {
+		username string
+		want     int
+	}{
+		{"bob", http.StatusForbidden},
+		{"alice", http.StatusForbidden},
+		{"root", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			status, _ := tg.do(t, http.MethodGet, "/metrics/websocket", nil, bearer(tg.token(t, tt.username)))
+			if status != tt.want {
+				t.Errorf("got status %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisconnectSessionTruncatesLongReason(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	conn := tg.dial(t, "/ws?token="+tg.token(t, "bob"), nil)
+	eventually(t, func() bool { return tg.wsHub.GetClientCount() == 1 })
+	sessionID := tg.wsHub.GetClientStats()[0].ID
+
+	reason := strings.Repeat("ü", 100)
+	status, _ := tg.do(t, http.MethodDelete, "/admin/sessions/"+sessionID, DisconnectRequest{Reason: reason}, bearer(tg.token(t, "root")))
+	if status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		closeErr, ok := err.(*gorilla.CloseError)
+		if !ok {
+			t.Fatalf("connection ended without a close frame: %v", err)
+		}
+		if closeErr.Code != websocket.CloseAdminDisconnect {
+			t.Errorf("got close code %d, want %d", closeErr.Code, websocket.CloseAdminDisconnect)
+		}
+		if want := websocket.TruncateCloseReason(reason); closeErr.Text != want {
+			t.Errorf("got close reason %q, want %q", closeErr.Text, want)
+		}
+		return
+	}
+}
+
+func TestListSessionsLabelsReplicas(t *testing.T) {
+	tg := newTestGateway(t, func(cfg *config.Config) {
+		cfg.APIGatewayConfig.WebSocket.Presence.ReplicaID = "gw-1"
+	})
+
+	tg.dial(t, "/ws?token="+tg.token(t, "bob"), nil)
+	eventually(t, func() bool { return tg.wsHub.GetClientCount() == 1 })
+
+	status, body := tg.do(t, http.MethodGet, "/admin/sessions", nil, bearer(tg.token(t, "root")))
+	sessions, _ := body["sessions"].([]interface{})
+	if status != http.StatusOK || len(sessions) != 1 {
+		t.Fatalf("list sessions = %d %v, want one session", status, body)
+	}
+	if session, _ := sessions[0].(map[string]interface{}); session["replicaId"] != "gw-1" {
+		t.Errorf("session = %v, want it labelled with replica gw-1", session)
+	}
+	if replicas, _ := body["replicas"].([]interface{}); len(replicas) != 1 || replicas[0] != "gw-1" {
+		t.Errorf("replicas = %v, want [gw-1]", body["replicas"])
+	}
+	if missing, _ := body["missingReplicas"].([]interface{}); len(missing) != 0 {
+		t.Errorf("missingReplicas = %v, want none", missing)
+	}
+}
@@ -0,0 +1,336 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// CreateAPIKeyRequest describes an API key to create. ExpiresAt defaults
+// to the longest lifetime allowed.
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name" binding:"required"`
+	Scopes     []string   `json:"scopes" binding:"required,min=1"`
+	AllowedIPs []string   `json:"allowedIps"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+}
+
+// APIKeyInfo describes an API key without its secret
+type APIKeyInfo struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowedIps"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// CreateAPIKeyResponse returns a new key. Key and SigningSecret are only
+// ever shown here; SigningSecret is empty when request signing is disabled.
+type CreateAPIKeyResponse struct {
+	Key           string `json:"key"`
+	SigningSecret string `json:"signingSecret,omitempty"`
+	APIKeyInfo
+}
+
+// Headers of a signed API key request
+const (
+	headerAPIKeyID     = "X-API-Key-ID"
+	headerAPITimestamp = "X-API-Timestamp"
+	headerAPINonce     = "X-API-Nonce"
+	headerAPISignature = "X-API-Signature"
+)
+
+// maxSignedBodyBytes bounds the body read to verify a request signature
+const maxSignedBodyBytes = 1 << 20
+
+// setupAPIKeyRoutes adds API key management routes to the router
+func (g *Gateway) setupAPIKeyRoutes(router *gin.Engine) {
+	apiKeys := router.Group("/api-keys")
+	{
+		apiKeys.Use(g.authMiddleware(), g.rejectAPIKeys())
+		apiKeys.POST("", g.requireFreshMFA(), g.handleCreateAPIKey)
+		apiKeys.GET("", g.handleListAPIKeys)
+		apiKeys.DELETE("/:id", g.handleRevokeAPIKey)
+	}
+}
+
+// authenticateAPIKey authenticates a request that presents an API key
+func (g *Gateway) authenticateAPIKey(c *gin.Context, apiKey string) {
+	key, err := g.apiKeys.Authenticate(apiKey, c.ClientIP())
+	switch {
+	case err != nil && isAPIKeyRejection(err):
+		g.logger.WithFields(logrus.Fields{"client_ip": c.ClientIP(), "reason": err.Error()}).Warn("API key rejected")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	case err != nil:
+		g.logger.Errorf("Failed to authenticate API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+		c.Abort()
+		return
+	}
+
+	g.setAPIKeyCaller(c, key, false)
+}
+
+// authenticateSignedRequest authenticates a request signed with an API
+// key's signing secret. The body is restored for later handlers.
+func (g *Gateway) authenticateSignedRequest(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSignedBodyBytes))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large to sign"})
+		c.Abort()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	key, err := g.apiKeys.VerifySignature(auth.SignedRequest{
+		KeyID:     c.GetHeader(headerAPIKeyID),
+		Timestamp: c.GetHeader(headerAPITimestamp),
+		Nonce:     c.GetHeader(headerAPINonce),
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.RequestURI(),
+		Body:      body,
+		Signature: c.GetHeader(headerAPISignature),
+	}, c.ClientIP())
+	if err != nil {
+		if !isAPIKeyRejection(err) {
+			g.logger.Errorf("Failed to verify signed request: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+			c.Abort()
+			return
+		}
+		g.logger.WithFields(logrus.Fields{
+			"client_ip":  c.ClientIP(),
+			"api_key_id": c.GetHeader(headerAPIKeyID),
+			"reason":     err.Error(),
+		}).Warn("Signed request rejected")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid request signature"})
+		c.Abort()
+		return
+	}
+
+	g.setAPIKeyCaller(c, key, true)
+}
+
+// setAPIKeyCaller continues an API key request on behalf of the key's
+// owner, whose current roles apply
+func (g *Gateway) setAPIKeyCaller(c *gin.Context, key auth.APIKey, signed bool) {
+	user, err := g.lookupUser(key.UserID)
+	if err != nil {
+		g.logger.Warnf("API key %s belongs to unknown user %s: %v", key.ID, key.UserID, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		c.Abort()
+		return
+	}
+
+	if !signed && g.requiresSignature(c.Request.URL.Path) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Request signature required"})
+		c.Abort()
+		return
+	}
+	if key.ReadOnly() && !isSafeMethod(c.Request.Method) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is read-only"})
+		c.Abort()
+		return
+	}
+
+	c.Set(currentUserKey, &auth.Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Roles:    user.Roles,
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+		Signed:   signed,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID,
+			ExpiresAt: jwt.NewNumericDate(key.ExpiresAt),
+		},
+	})
+	if !g.checkStepUp(c, "") {
+		return
+	}
+	c.Next()
+}
+
+// requiresSignature reports whether API key requests to path must be signed
+func (g *Gateway) requiresSignature(path string) bool {
+	for _, prefix := range g.config.APIGatewayConfig.APIKeys.SignedPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAPIKeyRejection reports whether err means the caller's credentials
+// were refused, rather than that they could not be checked
+func isAPIKeyRejection(err error) bool {
+	for _, rejection := range []error{
+		auth.ErrInvalidAPIKey, auth.ErrAPIKeyExpired, auth.ErrAPIKeyIPNotAllowed,
+		auth.ErrSigningDisabled, auth.ErrInvalidSignature, auth.ErrStaleSignature,
+		auth.ErrNonceReused, auth.ErrNonceRequired, auth.ErrTimestampRequired,
+	} {
+		if errors.Is(err, rejection) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectAPIKeys rejects callers authenticated with an API key, for routes
+// that need a user's own session or that no scope grants. It must run after
+// authMiddleware.
+func (g *Gateway) rejectAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if CurrentUser(c).APIKeyID != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not available to API keys"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// handleCreateAPIKey issues an API key for the caller
+func (g *Gateway) handleCreateAPIKey(c *gin.Context) {
+	var request CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := CurrentUser(c)
+	cfg := g.config.APIGatewayConfig.APIKeys
+
+	existing, err := g.apiKeys.List(user.UserID)
+	if err != nil {
+		g.logger.Errorf("Failed to list API keys for user %s: %v", user.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	if len(existing) >= cfg.MaxPerUser {
+		c.JSON(http.StatusConflict, gin.H{"error": "API key limit reached"})
+		return
+	}
+
+	maxExpiry := time.Now().Add(time.Duration(cfg.MaxLifetimeDays) * 24 * time.Hour)
+	expiresAt := maxExpiry
+	if request.ExpiresAt != nil {
+		if request.ExpiresAt.After(maxExpiry) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry exceeds the maximum API key lifetime"})
+			return
+		}
+		expiresAt = *request.ExpiresAt
+	}
+
+	key, record, err := g.apiKeys.Create(auth.APIKeyRequest{
+		UserID:     user.UserID,
+		Name:       request.Name,
+		Scopes:     request.Scopes,
+		AllowedIPs: request.AllowedIPs,
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.logger.WithFields(logrus.Fields{
+		"user_id":    user.UserID,
+		"api_key_id": record.ID,
+		"scopes":     record.Scopes,
+	}).Info("API key created")
+	response := CreateAPIKeyResponse{Key: key, APIKeyInfo: apiKeyInfo(record)}
+	if secret, err := g.apiKeys.SigningSecret(record.ID); err == nil {
+		response.SigningSecret = secret
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// handleListAPIKeys lists the caller's API keys
+func (g *Gateway) handleListAPIKeys(c *gin.Context) {
+	keys, err := g.apiKeys.List(CurrentUser(c).UserID)
+	if err != nil {
+		g.logger.Errorf("Failed to list API keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load API keys"})
+		return
+	}
+
+	infos := make([]APIKeyInfo, len(keys))
+	for i, key := range keys {
+		infos[i] = apiKeyInfo(key)
+	}
+	c.JSON(http.StatusOK, gin.H{"apiKeys": infos})
+}
+
+// handleRevokeAPIKey revokes one of the caller's API keys. Admins may
+// revoke any key.
+func (g *Gateway) handleRevokeAPIKey(c *gin.Context) {
+	user := CurrentUser(c)
+	id := c.Param("id")
+
+	key, err := g.apiKeys.Get(id)
+	if errors.Is(err, auth.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Errorf("Failed to load API key %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	// Other users' keys are reported as missing so their IDs cannot be probed
+	if key.UserID != user.UserID && !g.authorized(user.Roles, []string{"admin"}) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	if err := g.apiKeys.Revoke(id); err != nil {
+		g.logger.Errorf("Failed to revoke API key %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	g.logger.WithFields(logrus.Fields{
+		"user_id":       user.UserID,
+		"api_key_id":    id,
+		"owner_user_id": key.UserID,
+	}).Info("API key revoked")
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked", "id": id})
+}
+
+// apiKeyInfo describes a stored key for API responses
+func apiKeyInfo(key auth.APIKey) APIKeyInfo {
+	return APIKeyInfo{
+		ID:         key.ID,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		AllowedIPs: key.AllowedIPs,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+// isSafeMethod reports whether an HTTP method does not change state
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -0,0 +1,228 @@
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/config"
+)
+
+// createAPIKey creates an API key for username through the API and returns it
+func (tg *testGateway) createAPIKey(t *testing.T, username string, request CreateAPIKeyRequest) (string, string) {
+	t.Helper()
+
+	status, body := tg.do(t, http.MethodPost, "/api-keys", request, bearer(tg.steppedUpToken(t, username)))
+	if status != http.StatusCreated {
+		t.Fatalf("create api key = %d %v, want %d", status, body, http.StatusCreated)
+	}
+	return body["key"].(string), body["id"].(string)
+}
+
+// apiKeyHeader returns an X-API-Key header carrying key
+func apiKeyHeader(key string) http.Header {
+	return http.Header{"X-Api-Key": {key}}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	backend := newTestBackend(t)
+	tg := newTestGateway(t, func(cfg *config.Config) {
+		cfg.APIGatewayConfig.APIKeys.ScopePermissions = map[string][]string{
+			"read":  {"report:read"},
+			"trade": {"trade:execute", "order:cancel"},
+		}
+		trade := backend.service("buy-sell-engine", "/v1/trade/execute")
+		trade.Require = []string{"trade:execute"}
+		reports := backend.service("report-engine", "/v1/reports")
+		reports.Require = []string{"report:read"}
+		orders := backend.service("order-monitor-service", "/v1/orders/active")
+		cfg.ServiceDependencies.InternalServices = []config.InternalService{trade, reports, orders}
+	})
+
+	readKey, _ := tg.createAPIKey(t, "alice", CreateAPIKeyRequest{Name: "read", Scopes: []string{"read"}})
+	tradeKey, _ := tg.createAPIKey(t, "alice", CreateAPIKeyRequest{Name: "trade", Scopes: []string{"read", "trade"}})
+	bobTradeKey, _ := tg.createAPIKey(t, "bob", CreateAPIKeyRequest{Name: "trade", Scopes: []string{"trade"}})
+
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		want   int
+	}{
+		{"read key reads reports", readKey, http.MethodGet, "/api/v1/reports/daily", http.StatusOK},
+		{"read key cannot change state", readKey, http.MethodPost, "/api/v1/reports/daily", http.StatusForbidden},
+		{"read key cannot trade", readKey, http.MethodPost, "/api/v1/trade/execute/order", http.StatusForbidden},
+		{"trade key trades", tradeKey, http.MethodPost, "/api/v1/trade/execute/order", http.StatusOK},
+		{"trade scope beyond the owner's roles", bobTradeKey, http.MethodPost, "/api/v1/trade/execute/order", http.StatusForbidden},
+		{"key cannot manage keys", tradeKey, http.MethodGet, "/api-keys", http.StatusForbidden},
+		{"service without a requirement", readKey, http.MethodGet, "/api/v1/orders/active/1", http.StatusForbidden},
+		{"event stream", readKey, http.MethodGet, "/events/stream", http.StatusForbidden},
+		{"external proxy", readKey, http.MethodGet, "/external/coinbase/products", http.StatusForbidden},
+		{"unknown key", "cbk_000000000000_secret", http.MethodGet, "/api/v1/reports/daily", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := tg.do(t, tt.method, tt.path, nil, apiKeyHeader(tt.key))
+			if status != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, status, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	tg := newTestGateway(t, func(cfg *config.Config) {
+		cfg.APIGatewayConfig.APIKeys.MaxPerUser = 1
+		cfg.APIGatewayConfig.APIKeys.MaxLifetimeDays = 30
+	})
+	header := bearer(tg.steppedUpToken(t, "alice"))
+	tooLate := time.Now().Add(60 * 24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		request CreateAPIKeyRequest
+		want    int
+	}{
+		{"missing scopes", CreateAPIKeyRequest{Name: "k"}, http.StatusBadRequest},
+		{"unknown scope", CreateAPIKeyRequest{Name: "k", Scopes: []string{"admin"}}, http.StatusBadRequest},
+		{"invalid allowlist", CreateAPIKeyRequest{Name: "k", Scopes: []string{"read"}, AllowedIPs: []string{"nowhere"}}, http.StatusBadRequest},
+		{"expiry beyond the maximum lifetime", CreateAPIKeyRequest{Name: "k", Scopes: []string{"read"}, ExpiresAt: &tooLate}, http.StatusBadRequest},
+		{"valid", CreateAPIKeyRequest{Name: "k", Scopes: []string{"read"}}, http.StatusCreated},
+		{"over the per-user limit", CreateAPIKeyRequest{Name: "k2", Scopes: []string{"read"}}, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := tg.do(t, http.MethodPost, "/api-keys", tt.request, header)
+			if status != tt.want {
+				t.Errorf("create api key = %d %v, want %d", status, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateAPIKeyRequiresRecentMFA(t *testing.T) {
+	tg := newTestGateway(t, nil)
+	request := CreateAPIKeyRequest{Name: "k", Scopes: []string{"read"}}
+	stale, _, _ := tg.generateJWTToken(tg.user(t, "alice"), "", time.Now().Add(-time.Hour), []string{auth.AMRPassword, auth.AMROTP, auth.AMRMFA})
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"password only", tg.token(t, "alice"), http.StatusUnauthorized},
+		{"second factor an hour ago", stale, http.StatusUnauthorized},
+		{"second factor just now", tg.steppedUpToken(t, "alice"), http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := tg.do(t, http.MethodPost, "/api-keys", request, bearer(tt.token)); status != tt.want {
+				t.Errorf("create api key = %d %v, want %d", status, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAllowlist(t *testing.T) {
+	backend := newTestBackend(t)
+	tg := newTestGateway(t, func(cfg *config.Config) {
+		cfg.ServiceDependencies.InternalServices = []config.InternalService{backend.service("report-engine", "/v1/reports", "report:read")}
+	})
+
+	allowed, _ := tg.createAPIKey(t, "alice", CreateAPIKeyRequest{Name: "local", Scopes: []string{"read"}, AllowedIPs: []string{"127.0.0.0/8"}})
+	denied, _ := tg.createAPIKey(t, "alice", CreateAPIKeyRequest{Name: "remote", Scopes: []string{"read"}, AllowedIPs: []string{"203.0.113.7"}})
+
+	if status, _ := tg.do(t, http.MethodGet, "/api/v1/reports/daily", nil, apiKeyHeader(allowed)); status != http.StatusOK {
+		t.Errorf("key used from an allowed address = %d, want %d", status, http.StatusOK)
+	}
+	if status, _ := tg.do(t, http.MethodGet, "/api/v1/reports/daily", nil, apiKeyHeader(denied)); status != http.StatusUnauthorized {
+		t.Errorf("key used from another address = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	tg := newTestGateway(t, nil)
+	key, id := tg.createAPIKey(t, "alice", CreateAPIKeyRequest{Name: "k", Scopes: []string{"read"}})
+
+	if status, _ := tg.do(t, http.MethodDelete, "/api-keys/"+id, nil, bearer(tg.token(t, "bob"))); status != http.StatusNotFound {
+		t.Errorf("revoke by another user = %d, want %d", status, http.StatusNotFound)
+	}
+	if status, _ := tg.do(t, http.MethodDelete, "/api-keys/"+id, nil, bearer(tg.token(t, "alice"))); status != http.StatusOK {
+		t.Errorf("revoke by the owner = %d, want %d", status, http.StatusOK)
+	}
+	if status, _ := tg.do(t, http.MethodGet, "/presence", nil, apiKeyHeader(key)); status != http.StatusUnauthorized {
+		t.Errorf("revoked key = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestSignedPathsRequireSignature(t *testing.T) {
+	backend := newTestBackend(t)
+	tg := newTestGateway(t, func(cfg *config.Config) {
+		cfg.APIGatewayConfig.APIKeys.ScopePermissions = map[string][]string{"trade": {"trade:execute"}}
+		cfg.APIGatewayConfig.APIKeys.SignedPaths = []string{"/api/v1/trade"}
+		cfg.ServiceDependencies.InternalServices = []config.InternalService{backend.service("buy-sell-engine", "/v1/trade/execute", "trade:execute")}
+	})
+	key, id := tg.createAPIKey(t, "alice", CreateAPIKeyRequest{Name: "bot", Scopes: []string{"trade"}})
+
+	if status, _ := tg.do(t, http.MethodPost, "/api/v1/trade/execute/order", nil, apiKeyHeader(key)); status != http.StatusUnauthorized {
+		t.Errorf("unsigned request = %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	secret, err := tg.apiKeys.SigningSecret(id)
+	if err != nil {
+		t.Fatalf("SigningSecret: %v", err)
+	}
+	request := auth.SignedRequest{
+		KeyID:     id,
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Nonce:     "n1",
+		Method:    http.MethodPost,
+		Path:      "/api/v1/trade/execute/order",
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(request.StringToSign()))
+	header := http.Header{
+		headerAPIKeyID:     {id},
+		headerAPITimestamp: {request.Timestamp},
+		headerAPINonce:     {request.Nonce},
+		headerAPISignature: {hex.EncodeToString(mac.Sum(nil))},
+	}
+
+	if status, _ := tg.do(t, http.MethodPost, "/api/v1/trade/execute/order", nil, header); status != http.StatusOK {
+		t.Errorf("signed request = %d, want %d", status, http.StatusOK)
+	}
+	if status, _ := tg.do(t, http.MethodPost, "/api/v1/trade/execute/order", nil, header); status != http.StatusUnauthorized {
+		t.Errorf("replayed request = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRevokeAPIKeyOnAnotherReplica(t *testing.T) {
+	backend := newTestBackend(t)
+	broker := &testBroker{}
+	configure := func(cfg *config.Config) {
+		cfg.ServiceDependencies.InternalServices = []config.InternalService{backend.service("report-engine", "/v1/reports", "report:read")}
+	}
+	onA := newReplicaTestGateway(t, broker.join("a"), configure)
+	onB := newReplicaTestGateway(t, broker.join("b"), configure)
+
+	key, id := onA.createAPIKey(t, "alice", CreateAPIKeyRequest{Name: "k", Scopes: []string{"read"}})
+	if status, _ := onB.do(t, http.MethodGet, "/api/v1/reports/daily", nil, apiKeyHeader(key)); status != http.StatusOK {
+		t.Fatalf("key on another replica = %d, want %d", status, http.StatusOK)
+	}
+
+	if status, _ := onB.do(t, http.MethodDelete, "/api-keys/"+id, nil, bearer(onB.token(t, "alice"))); status != http.StatusOK {
+		t.Fatalf("revoke on another replica = %d, want %d", status, http.StatusOK)
+	}
+	if status, _ := onA.do(t, http.MethodGet, "/api/v1/reports/daily", nil, apiKeyHeader(key)); status != http.StatusUnauthorized {
+		t.Errorf("key revoked on another replica = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
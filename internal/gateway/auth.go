@@ -1,23 +1,37 @@
 package gateway
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
+	"cryptobot-api-gateway/internal/auth"
+
 	"github.com/gin-gonic/gin"
 )
 
-// LoginRequest represents a login request
+// LoginRequest represents a login request. Users with MFA enabled also
+// send a TOTP code or one of their recovery codes.
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Username     string `json:"username" binding:"required"`
+	Password     string `json:"password" binding:"required"`
+	OTP          string `json:"otp"`
+	RecoveryCode string `json:"recoveryCode"`
 }
 
-// LoginResponse represents a login response
+// LoginResponse represents a login response. Token is a short-lived access
+// token; RefreshToken is exchanged at /auth/refresh for a new pair.
 type LoginResponse struct {
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expiresAt"`
-	User      UserInfo  `json:"user"`
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	RefreshToken     string    `json:"refreshToken"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+	User             UserInfo  `json:"user"`
+}
+
+// RefreshRequest represents a token refresh request
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
 }
 
 // UserInfo represents user information
@@ -29,12 +43,14 @@ type UserInfo struct {
 
 // setupAuthRoutes adds authentication routes to the router
 func (g *Gateway) setupAuthRoutes(router *gin.Engine) {
-	auth := router.Group("/auth")
+	authRoutes := router.Group("/auth")
 	{
-		auth.POST("/login", g.handleLogin)
-		auth.POST("/refresh", g.authMiddleware(), g.handleRefreshToken)
-		auth.POST("/logout", g.authMiddleware(), g.handleLogout)
+		authRoutes.POST("/login", g.handleLogin)
+		authRoutes.POST("/refresh", g.handleRefreshToken)
+		authRoutes.POST("/logout", g.authMiddleware(), g.rejectAPIKeys(), g.handleLogout)
 	}
+	g.setupMFARoutes(router)
+	g.setupSessionRoutes(router)
 }
 
 // handleLogin processes login requests and returns JWT token
@@ -46,100 +62,148 @@ func (g *Gateway) handleLogin(c *gin.Context) {
 		return
 	}
 
-	// In production, validate against a real user database
-	// This is a simple example for demonstration
-	if !g.validateCredentials(request.Username, request.Password) {
+	if !g.allowLogin(c, request.Username) {
+		return
+	}
+
+	user, err := auth.Authenticate(g.userStore, request.Username, request.Password)
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		g.loginFailed(c, request.Username, "invalid_credentials")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
+	case errors.Is(err, auth.ErrUserDisabled):
+		g.loginFailed(c, request.Username, "account_disabled")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		return
+	case err != nil:
+		g.logger.Errorf("Failed to authenticate user %s: %v", request.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+		return
+	}
+
+	amr, ok := g.loginAMR(c, user.ID, request)
+	if !ok {
+		return
+	}
+
+	refreshToken, session, err := g.refreshTokens.Issue(user.ID, time.Now(), amr)
+	if err != nil {
+		g.logger.Errorf("Failed to issue refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
 	}
 
-	// Generate JWT token
-	userID := "user123"                 // In production, get from database
-	roles := []string{"user", "trader"} // In production, get from database
+	g.loginSucceeded(c, user)
+	g.startSession(c, session)
+	g.respondWithTokens(c, user, refreshToken, session)
+}
 
-	token, err := g.generateJWTToken(userID, request.Username, roles)
+// respondWithTokens issues an access token for user in the refresh token's
+// session and returns both
+func (g *Gateway) respondWithTokens(c *gin.Context, user auth.User, refreshToken string, session auth.RefreshToken) {
+	token, expiresAt, err := g.generateJWTToken(user, session.FamilyID, session.AuthTime, session.AMR)
 	if err != nil {
 		g.logger.Errorf("Failed to generate JWT token: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	expiresAt := time.Now().Add(24 * time.Hour)
-
 	response := LoginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt,
+		Token:            token,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
 		User: UserInfo{
-			ID:       userID,
-			Username: request.Username,
-			Roles:    roles,
+			ID:       user.ID,
+			Username: user.Username,
+			Roles:    user.Roles,
 		},
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// handleRefreshToken refreshes an existing JWT token
+// handleRefreshToken exchanges a refresh token for a new access and refresh
+// token. The user is looked up again so disabled accounts and role changes
+// take effect.
 func (g *Gateway) handleRefreshToken(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	var request RefreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	username, exists := c.Get("username")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	refreshToken, session, err := g.refreshTokens.Rotate(request.RefreshToken)
+	switch {
+	case errors.Is(err, auth.ErrRefreshTokenReused):
+		g.logger.Warnf("Refresh token reused for user %s, revoking session %s", session.UserID, session.FamilyID)
+		if err := g.revokeSession(session.FamilyID); err != nil {
+			g.logger.Errorf("Failed to revoke session %s: %v", session.FamilyID, err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	case errors.Is(err, auth.ErrInvalidRefreshToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	case err != nil:
+		g.logger.Errorf("Failed to rotate refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
 		return
 	}
 
-	roles, exists := c.Get("roles")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	user, err := g.lookupUser(session.UserID)
+	if errors.Is(err, auth.ErrUserNotFound) || (err == nil && user.Disabled) {
+		g.refreshTokens.Revoke(session.FamilyID)
+		g.sessions.End(session.FamilyID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
 		return
 	}
-
-	// Generate new token
-	token, err := g.generateJWTToken(userID.(string), username.(string), roles.([]string))
 	if err != nil {
-		g.logger.Errorf("Failed to generate JWT token: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		g.logger.Errorf("Failed to look up user %s: %v", session.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
 		return
 	}
 
-	expiresAt := time.Now().Add(24 * time.Hour)
-
-	response := LoginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt,
-		User: UserInfo{
-			ID:       userID.(string),
-			Username: username.(string),
-			Roles:    roles.([]string),
-		},
+	if err := g.sessions.Refreshed(session, c.ClientIP()); err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+		g.logger.Errorf("Failed to record refresh of session %s: %v", session.FamilyID, err)
 	}
-
-	c.JSON(http.StatusOK, response)
+	g.respondWithTokens(c, user, refreshToken, session)
 }
 
-// handleLogout processes logout requests
+// handleLogout revokes the caller's access token and ends its session, so
+// neither the session's access tokens nor its refresh tokens can be used
+// again on any replica
 func (g *Gateway) handleLogout(c *gin.Context) {
-	// In production, you might want to blacklist the token
-	// For now, we'll just return success
+	claims := CurrentUser(c)
+
+	if err := g.revokeToken(claims); err != nil {
+		g.logger.Errorf("Failed to revoke token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	if claims.SessionID != "" {
+		if err := g.revokeSession(claims.SessionID); err != nil {
+			g.logger.Errorf("Failed to revoke session %s: %v", claims.SessionID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
 }
 
-// validateCredentials validates user credentials
-// In production, this should check against a secure user database
-func (g *Gateway) validateCredentials(username, password string) bool {
-	// This is a simple example - DO NOT use in production!
-	// In production, use proper password hashing (bcrypt) and a database
-	validUsers := map[string]string{
-		"admin":  "admin123",
-		"trader": "trader123",
-		"demo":   "demo123",
+// revokeSession ends a login session: its refresh tokens are dropped,
+// access tokens already issued for it are revoked until they would have
+// expired and its WebSocket clients are disconnected
+func (g *Gateway) revokeSession(sessionID string) error {
+	if err := g.refreshTokens.Revoke(sessionID); err != nil {
+		return err
 	}
-
-	expectedPassword, exists := validUsers[username]
-	return exists && expectedPassword == password
+	if err := g.sessions.End(sessionID); err != nil {
+		return err
+	}
+	g.wsHub.DisconnectSession(sessionID, sessionRevokedReason)
+	return g.revocations.Revoke(sessionID, time.Now().Add(g.accessTokenLifetime()))
 }
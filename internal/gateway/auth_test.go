@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"net/http"
+	"testing"
+)
+
+// login logs a test user in and returns the response
+func (tg *testGateway) login(t *testing.T, username, password string) (int, map[string]interface{}) {
+	t.Helper()
+	return tg.do(t, http.MethodPost, "/auth/login", LoginRequest{Username: username, Password: password}, nil)
+}
+
+func TestLogin(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     int
+	}{
+		{"valid credentials", "alice", testPassword, http.StatusOK},
+		{"wrong password", "bob", "wrong", http.StatusUnauthorized},
+		{"unknown user", "mallory", testPassword, http.StatusUnauthorized},
+		{"disabled user", "dave", testPassword, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := tg.login(t, tt.username, tt.password)
+			if status != tt.want {
+				t.Fatalf("got status %d, want %d: %v", status, tt.want, body)
+			}
+			if status != http.StatusOK {
+				return
+			}
+
+			token, _ := body["token"].(string)
+			claims, err := tg.parseToken(token)
+			if err != nil {
+				t.Fatalf("login returned an invalid token: %v", err)
+			}
+			if claims.UserID != "u-alice" || claims.SessionID == "" {
+				t.Errorf("got claims for user %q in session %q", claims.UserID, claims.SessionID)
+			}
+			if body["refreshToken"] == "" {
+				t.Error("login returned no refresh token")
+			}
+		})
+	}
+}
+
+func TestLogoutRevokesAccessAndRefreshTokens(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	_, body := tg.login(t, "alice", testPassword)
+	token, _ := body["token"].(string)
+	refreshToken, _ := body["refreshToken"].(string)
+
+	if status, _ := tg.do(t, http.MethodGet, "/auth/sessions", nil, bearer(token)); status != http.StatusOK {
+		t.Fatalf("token rejected before logout with status %d", status)
+	}
+	if status, _ := tg.do(t, http.MethodPost, "/auth/logout", nil, bearer(token)); status != http.StatusOK {
+		t.Fatalf("logout failed with status %d", status)
+	}
+
+	status, body := tg.do(t, http.MethodGet, "/auth/sessions", nil, bearer(token))
+	if status != http.StatusUnauthorized || body["error"] != "Token revoked" {
+		t.Errorf("token after logout got %d %v, want 401 Token revoked", status, body)
+	}
+	if status, _ := tg.do(t, http.MethodPost, "/auth/refresh", RefreshRequest{RefreshToken: refreshToken}, nil); status != http.StatusUnauthorized {
+		t.Errorf("refresh after logout got status %d, want 401", status)
+	}
+}
+
+func TestRefreshRotatesAndDetectsReuse(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	_, body := tg.login(t, "alice", testPassword)
+	first, _ := body["refreshToken"].(string)
+
+	status, body := tg.do(t, http.MethodPost, "/auth/refresh", RefreshRequest{RefreshToken: first}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("refresh got status %d: %v", status, body)
+	}
+	token, _ := body["token"].(string)
+	if second, _ := body["refreshToken"].(string); second == "" || second == first {
+		t.Fatalf("refresh returned refresh token %q, want a new one", second)
+	}
+
+	// Replaying the first refresh token revokes the whole session
+	if status, _ := tg.do(t, http.MethodPost, "/auth/refresh", RefreshRequest{RefreshToken: first}, nil); status != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token got status %d, want 401", status)
+	}
+	if status, _ := tg.do(t, http.MethodGet, "/auth/sessions", nil, bearer(token)); status != http.StatusUnauthorized {
+		t.Errorf("access token of the revoked session got status %d, want 401", status)
+	}
+}
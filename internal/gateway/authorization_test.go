@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/config"
+	"cryptobot-api-gateway/internal/websocket"
+)
+
+func TestAuthorized(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	tests := []struct {
+		name     string
+		roles    []string
+		required []string
+		want     bool
+	}{
+		{"no requirement", []string{"user"}, nil, true},
+		{"required role", []string{"user", "trader"}, []string{"trader"}, true},
+		{"permission of a role", []string{"trader"}, []string{"trade:execute"}, true},
+		{"any one requirement suffices", []string{"user"}, []string{"trade:execute", "report:read"}, true},
+		{"missing permission", []string{"user"}, []string{"trade:execute"}, false},
+		{"missing role", []string{"user"}, []string{"admin"}, false},
+		{"wildcard permission", []string{"admin"}, []string{"order:cancel"}, true},
+		{"no roles", nil, []string{"report:read"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tg.authorized(tt.roles, tt.required); got != tt.want {
+				t.Errorf("authorized(%v, %v) = %v, want %v", tt.roles, tt.required, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizedUserLimitsAPIKeysToScopes(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	trader := []string{"user", "trader"}
+	tests := []struct {
+		name     string
+		claims   auth.Claims
+		required []string
+		want     bool
+	}{
+		{"session token", auth.Claims{Roles: trader}, []string{"trade:execute"}, true},
+		{"key with the scope", auth.Claims{Roles: trader, APIKeyID: "k1", Scopes: []string{"trade"}}, []string{"trade:execute"}, true},
+		{"key without the scope", auth.Claims{Roles: trader, APIKeyID: "k1", Scopes: []string{"read"}}, []string{"trade:execute"}, false},
+		{"scope beyond the owner's roles", auth.Claims{Roles: []string{"user"}, APIKeyID: "k1", Scopes: []string{"trade"}}, []string{"trade:execute"}, false},
+		{"key never satisfies a role", auth.Claims{Roles: trader, APIKeyID: "k1", Scopes: []string{"trade"}}, []string{"trader"}, false},
+		{"session token with no requirement", auth.Claims{Roles: trader}, nil, true},
+		{"key with no requirement", auth.Claims{Roles: trader, APIKeyID: "k1", Scopes: []string{"read", "trade"}}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tg.authorizedUser(&tt.claims, tt.required); got != tt.want {
+				t.Errorf("authorizedUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceAccess(t *testing.T) {
+	backend := newTestBackend(t)
+	tg := newTestGateway(t, func(cfg *config.Config) {
+		orders := backend.service("order-monitor-service", "/v1/orders/active")
+		orders.MethodRequire = map[string][]string{http.MethodDelete: {"order:cancel"}}
+		reports := backend.service("report-engine", "/v1/reports")
+		reports.Require = []string{"report:read"}
+		admin := backend.service("admin-service", "/v1/admin")
+		admin.Require = []string{"admin"}
+		cfg.ServiceDependencies.InternalServices = []config.InternalService{orders, reports, admin}
+	})
+
+	tests := []struct {
+		name   string
+		user   string
+		method string
+		path   string
+		want   int
+	}{
+		{"open service", "bob", http.MethodGet, "/api/v1/orders/active/1", http.StatusOK},
+		{"method requirement denied", "bob", http.MethodDelete, "/api/v1/orders/active/1", http.StatusForbidden},
+		{"method requirement granted", "alice", http.MethodDelete, "/api/v1/orders/active/1", http.StatusOK},
+		{"permission of the user role", "bob", http.MethodGet, "/api/v1/reports/daily", http.StatusOK},
+		{"role requirement denied", "alice", http.MethodGet, "/api/v1/admin/users", http.StatusForbidden},
+		{"role requirement granted", "root", http.MethodGet, "/api/v1/admin/users", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := tg.do(t, tt.method, tt.path, nil, bearer(tg.token(t, tt.user)))
+			if status != tt.want {
+				t.Errorf("%s %s as %s = %d, want %d", tt.method, tt.path, tt.user, status, tt.want)
+			}
+		})
+	}
+
+	if status, _ := tg.do(t, http.MethodGet, "/api/v1/orders/active/1", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("anonymous request = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestCommandAuthorization(t *testing.T) {
+	tg := newTestGateway(t, nil)
+	request := map[string]interface{}{
+		"symbol":    "BTC-USD",
+		"startDate": time.Now().Add(-time.Hour),
+		"endDate":   time.Now(),
+	}
+
+	// Without a broker an authorized command fails to publish instead
+	status, _ := tg.do(t, http.MethodPost, "/commands/fetch-history", request, bearer(tg.token(t, "bob")))
+	if status != http.StatusForbidden {
+		t.Errorf("fetch-history by a user = %d, want %d", status, http.StatusForbidden)
+	}
+	status, _ = tg.do(t, http.MethodPost, "/commands/fetch-history", request, bearer(tg.token(t, "alice")))
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("fetch-history by a trader = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestAuthorizeWebSocketCommand(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	tests := []struct {
+		name    string
+		roles   []string
+		command string
+		allowed bool
+	}{
+		{"trader starts a bot", []string{"user", "trader"}, "start_bot", true},
+		{"user starts a bot", []string{"user"}, "start_bot", false},
+		{"command without requirement", []string{"user"}, "mark_read", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tg.authorizeCommand(websocket.Identity{UserID: "u-test", Roles: tt.roles, Authenticated: true}, tt.command)
+			if (err == nil) != tt.allowed {
+				t.Errorf("authorizeCommand() = %v, allowed %v", err, tt.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// BotOwnerRequest sets the user or team that owns a bot
+type BotOwnerRequest struct {
+	OwnerID string `json:"ownerId"`
+	TeamID  string `json:"teamId"`
+}
+
+// BotShareRequest gives a user access to a bot
+type BotShareRequest struct {
+	UserID string `json:"userId" binding:"required"`
+}
+
+// botRegistryEvent is the part of a bot registry event naming its owner
+type botRegistryEvent struct {
+	BotID   string `json:"botId"`
+	OwnerID string `json:"ownerId"`
+	TeamID  string `json:"teamId"`
+}
+
+// authorizeBot checks that a caller may control a bot. Admins may control
+// every bot; other users only bots they or their team own, or that were
+// shared with them.
+func (g *Gateway) authorizeBot(userID, username string, admin bool, botID, transport string) error {
+	if admin {
+		return nil
+	}
+
+	user, err := g.lookupUser(userID)
+	if err == nil {
+		err = g.bots.Authorize(botID, user)
+	}
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, auth.ErrBotNoOwner), errors.Is(err, auth.ErrBotForbidden), errors.Is(err, auth.ErrUserNotFound):
+		g.auditDenied(logrus.Fields{
+			"user_id":   userID,
+			"username":  username,
+			"resource":  "bot:" + botID,
+			"reason":    err.Error(),
+			"transport": transport,
+		})
+		return &commandError{http.StatusForbidden, "Not allowed to control this bot"}
+	default:
+		g.logger.Errorf("Failed to check access to bot %s: %v", botID, err)
+		return &commandError{http.StatusInternalServerError, "Failed to check bot access"}
+	}
+}
+
+// authorizeBotRequest checks that the caller of an HTTP command may
+// control a bot
+func (g *Gateway) authorizeBotRequest(c *gin.Context, botID string) error {
+	user := CurrentUser(c)
+	return g.authorizeBot(user.UserID, user.Username, g.authorizedUser(user, []string{"admin"}), botID, "http")
+}
+
+// authorizeBotCommand checks that a WebSocket client may control a bot
+func (g *Gateway) authorizeBotCommand(identity websocket.Identity, botID string) error {
+	return g.authorizeBot(identity.UserID, identity.Username, g.authorized(identity.Roles, []string{"admin"}), botID, "websocket")
+}
+
+// recordBotOwner learns a bot's owner from a registry event. Events that do
+// not name a bot and its owner are ignored, and an owner set by an admin
+// is kept.
+func (g *Gateway) recordBotOwner(body []byte) {
+	var event botRegistryEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.BotID == "" {
+		return
+	}
+	if event.OwnerID == "" && event.TeamID == "" {
+		return
+	}
+
+	ownership, err := g.bots.SetOwner(event.BotID, event.OwnerID, event.TeamID, auth.BotOwnerRegistry)
+	if err != nil {
+		g.logger.Errorf("Failed to record owner of bot %s: %v", event.BotID, err)
+		return
+	}
+	if ownership.OwnerID != event.OwnerID || ownership.TeamID != event.TeamID {
+		g.security.WithFields(logrus.Fields{
+			"event":          "bot_owner_ignored",
+			"bot_id":         event.BotID,
+			"owner_id":       ownership.OwnerID,
+			"team_id":        ownership.TeamID,
+			"reported_owner": event.OwnerID,
+			"reported_team":  event.TeamID,
+		}).Warn("Registry reported a different owner for a bot with an admin-set owner")
+	}
+}
+
+// handleListBots lists every known bot with its owner and sharing grants
+func (g *Gateway) handleListBots(c *gin.Context) {
+	bots, err := g.bots.List()
+	if err != nil {
+		g.logger.Errorf("Failed to list bots: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list bots"})
+		return
+	}
+
+	g.auditAdminAction(c, "list_bots", logrus.Fields{"count": len(bots)})
+	c.JSON(http.StatusOK, gin.H{"bots": bots})
+}
+
+// handleSetBotOwner sets the user or team that owns a bot
+func (g *Gateway) handleSetBotOwner(c *gin.Context) {
+	botID := c.Param("botId")
+
+	var request BotOwnerRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.OwnerID == "" && request.TeamID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ownerId or teamId required"})
+		return
+	}
+
+	ownership, err := g.bots.SetOwner(botID, request.OwnerID, request.TeamID, auth.BotOwnerAdmin)
+	if err != nil {
+		g.logger.Errorf("Failed to set owner of bot %s: %v", botID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set bot owner"})
+		return
+	}
+
+	g.auditAdminAction(c, "set_bot_owner", logrus.Fields{"bot_id": botID, "owner_id": request.OwnerID, "team_id": request.TeamID})
+	c.JSON(http.StatusOK, ownership)
+}
+
+// handleShareBot gives a user access to a bot
+func (g *Gateway) handleShareBot(c *gin.Context) {
+	botID := c.Param("botId")
+
+	var request BotShareRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ownership, err := g.bots.Share(botID, request.UserID)
+	if err != nil {
+		g.logger.Errorf("Failed to share bot %s: %v", botID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share bot"})
+		return
+	}
+
+	g.auditAdminAction(c, "share_bot", logrus.Fields{"bot_id": botID, "target_user_id": request.UserID})
+	c.JSON(http.StatusOK, ownership)
+}
+
+// handleUnshareBot removes a user's access to a bot
+func (g *Gateway) handleUnshareBot(c *gin.Context) {
+	botID := c.Param("botId")
+	userID := c.Param("userId")
+
+	ownership, err := g.bots.Unshare(botID, userID)
+	if err != nil {
+		g.logger.Errorf("Failed to unshare bot %s: %v", botID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unshare bot"})
+		return
+	}
+
+	g.auditAdminAction(c, "unshare_bot", logrus.Fields{"bot_id": botID, "target_user_id": userID})
+	c.JSON(http.StatusOK, ownership)
+}
@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBotCommandsRequireOwnership(t *testing.T) {
+	tg := newTestGateway(t, nil)
+	tg.recordBotOwner([]byte(`{"botId":"bot-alice","ownerId":"u-alice"}`))
+	tg.recordBotOwner([]byte(`{"botId":"bot-desk","teamId":"desk"}`))
+
+	// Without a broker an authorized command fails to publish instead
+	tests := []struct {
+		name     string
+		username string
+		botID    string
+		want     int
+	}{
+		{"owner", "alice", "bot-alice", http.StatusServiceUnavailable},
+		{"other user", "bob", "bot-alice", http.StatusForbidden},
+		{"team member", "alice", "bot-desk", http.StatusServiceUnavailable},
+		{"bot without owner", "alice", "bot-unknown", http.StatusForbidden},
+		{"admin", "root", "bot-unknown", http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := tg.do(t, http.MethodPost, "/commands/start-bot", StartBotRequest{BotID: tt.botID}, bearer(tg.token(t, tt.username)))
+			if status != tt.want {
+				t.Errorf("start-bot = %d %v, want %d", status, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryKeepsAdminSetBotOwner(t *testing.T) {
+	tg := newTestGateway(t, nil)
+	admin := bearer(tg.token(t, "root"))
+	start := func(username string) int {
+		status, _ := tg.do(t, http.MethodPost, "/commands/start-bot", StartBotRequest{BotID: "bot-1"}, bearer(tg.token(t, username)))
+		return status
+	}
+
+	tg.recordBotOwner([]byte(`{"botId":"bot-1","ownerId":"u-bob"}`))
+	if status, body := tg.do(t, http.MethodPut, "/admin/bots/bot-1/owner", BotOwnerRequest{OwnerID: "u-alice"}, admin); status != http.StatusOK {
+		t.Fatalf("set owner = %d %v, want %d", status, body, http.StatusOK)
+	}
+
+	// A later registry event naming another owner does not take the bot back
+	tg.recordBotOwner([]byte(`{"botId":"bot-1","ownerId":"u-bob"}`))
+
+	if status := start("bob"); status != http.StatusForbidden {
+		t.Errorf("start-bot by the registry's owner = %d, want %d", status, http.StatusForbidden)
+	}
+	if status := start("alice"); status != http.StatusServiceUnavailable {
+		t.Errorf("start-bot by the admin-set owner = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestBotSharedOnAnotherReplica(t *testing.T) {
+	broker := &testBroker{}
+	onA := newReplicaTestGateway(t, broker.join("a"), nil)
+	onB := newReplicaTestGateway(t, broker.join("b"), nil)
+	onA.recordBotOwner([]byte(`{"botId":"bot-1","ownerId":"u-root"}`))
+	start := func(tg *testGateway) int {
+		status, _ := tg.do(t, http.MethodPost, "/commands/start-bot", StartBotRequest{BotID: "bot-1"}, bearer(tg.token(t, "alice")))
+		return status
+	}
+
+	admin := bearer(onA.token(t, "root"))
+	if status, body := onA.do(t, http.MethodPost, "/admin/bots/bot-1/shares", BotShareRequest{UserID: "u-alice"}, admin); status != http.StatusOK {
+		t.Fatalf("share = %d %v, want %d", status, body, http.StatusOK)
+	}
+	if status := start(onB); status != http.StatusServiceUnavailable {
+		t.Errorf("start-bot on another replica after sharing = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+
+	if status, _ := onB.do(t, http.MethodDelete, "/admin/bots/bot-1/shares/u-alice", nil, admin); status != http.StatusOK {
+		t.Fatalf("unshare = %d, want %d", status, http.StatusOK)
+	}
+	if status := start(onA); status != http.StatusForbidden {
+		t.Errorf("start-bot on another replica after unsharing = %d, want %d", status, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,251 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"cryptobot-api-gateway/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// StartBotRequest is the payload of a start_bot command
+type StartBotRequest struct {
+	BotID  string                 `json:"botId" binding:"required"`
+	Config map[string]interface{} `json:"config"`
+}
+
+// StopBotRequest is the payload of a stop_bot command
+type StopBotRequest struct {
+	BotID string `json:"botId" binding:"required"`
+}
+
+// FetchHistoryRequest is the payload of a fetch_history command
+type FetchHistoryRequest struct {
+	Symbol    string    `json:"symbol" binding:"required"`
+	StartDate time.Time `json:"startDate" binding:"required"`
+	EndDate   time.Time `json:"endDate" binding:"required"`
+}
+
+// commandError is a command failure with the HTTP status it maps to
+type commandError struct {
+	status  int
+	message string
+}
+
+func (e *commandError) Error() string {
+	return e.message
+}
+
+// Code maps the HTTP status to the error code sent to WebSocket clients
+func (e *commandError) Code() string {
+	switch e.status {
+	case http.StatusBadRequest:
+		return websocket.ErrorInvalidPayload
+	case http.StatusUnauthorized:
+		return websocket.ErrorUnauthorized
+	case http.StatusForbidden:
+		return websocket.ErrorForbidden
+	default:
+		return websocket.ErrorCommandFailed
+	}
+}
+
+// respondCommandError writes a command failure as an HTTP response
+func respondCommandError(c *gin.Context, err error) {
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		c.JSON(cmdErr.status, gin.H{"error": cmdErr.message})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// executeCommand runs a command received over the WebSocket connection
+// through the same validation and publishing as the HTTP handlers
+func (g *Gateway) executeCommand(identity websocket.Identity, command string, payload json.RawMessage) (interface{}, error) {
+	if !identity.Authenticated {
+		return nil, &commandError{http.StatusUnauthorized, "Authentication required"}
+	}
+	if err := g.authorizeCommand(identity, command); err != nil {
+		return nil, err
+	}
+	if err := g.checkCommandStepUp(identity, command); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case "start_bot":
+		var request StartBotRequest
+		if err := decodeCommand(payload, &request); err != nil {
+			return nil, err
+		}
+		if err := g.authorizeBotCommand(identity, request.BotID); err != nil {
+			return nil, err
+		}
+		return g.startBot(request)
+
+	case "stop_bot":
+		var request StopBotRequest
+		if err := decodeCommand(payload, &request); err != nil {
+			return nil, err
+		}
+		if err := g.authorizeBotCommand(identity, request.BotID); err != nil {
+			return nil, err
+		}
+		return g.stopBot(request)
+
+	case "fetch_history":
+		var request FetchHistoryRequest
+		if err := decodeCommand(payload, &request); err != nil {
+			return nil, err
+		}
+		return g.fetchHistory(request)
+
+	case "mark_read":
+		var request MarkReadRequest
+		if err := decodeCommand(payload, &request); err != nil {
+			return nil, err
+		}
+		return g.markOfflineEventsRead(identity.UserID, request)
+
+	default:
+		return nil, &commandError{http.StatusBadRequest, "Unknown command: " + command}
+	}
+}
+
+// decodeCommand decodes and validates a command payload the way gin binds
+// an HTTP request body
+func decodeCommand(payload json.RawMessage, request interface{}) error {
+	if len(payload) == 0 {
+		payload = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(payload, request); err != nil {
+		return &commandError{http.StatusBadRequest, err.Error()}
+	}
+	if err := binding.Validator.ValidateStruct(request); err != nil {
+		return &commandError{http.StatusBadRequest, err.Error()}
+	}
+	return nil
+}
+
+// Command handlers for bot control
+func (g *Gateway) handleStartBot(c *gin.Context) {
+	var request StartBotRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := g.authorizeBotRequest(c, request.BotID); err != nil {
+		respondCommandError(c, err)
+		return
+	}
+
+	result, err := g.startBot(request)
+	if err != nil {
+		respondCommandError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, result)
+}
+
+func (g *Gateway) handleStopBot(c *gin.Context) {
+	var request StopBotRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := g.authorizeBotRequest(c, request.BotID); err != nil {
+		respondCommandError(c, err)
+		return
+	}
+
+	result, err := g.stopBot(request)
+	if err != nil {
+		respondCommandError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, result)
+}
+
+func (g *Gateway) handleFetchHistory(c *gin.Context) {
+	var request FetchHistoryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := g.fetchHistory(request)
+	if err != nil {
+		respondCommandError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, result)
+}
+
+// startBot publishes a start bot command
+func (g *Gateway) startBot(request StartBotRequest) (gin.H, error) {
+	message := map[string]interface{}{
+		"command":   "start",
+		"botId":     request.BotID,
+		"config":    request.Config,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if err := g.publishCommand("queue://commands.start_bot", message); err != nil {
+		return nil, err
+	}
+
+	return gin.H{"message": "Start bot command sent", "botId": request.BotID}, nil
+}
+
+// stopBot publishes a stop bot command
+func (g *Gateway) stopBot(request StopBotRequest) (gin.H, error) {
+	message := map[string]interface{}{
+		"command":   "stop",
+		"botId":     request.BotID,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if err := g.publishCommand("queue://commands.stop_bot", message); err != nil {
+		return nil, err
+	}
+
+	return gin.H{"message": "Stop bot command sent", "botId": request.BotID}, nil
+}
+
+// fetchHistory publishes a fetch history command
+func (g *Gateway) fetchHistory(request FetchHistoryRequest) (gin.H, error) {
+	message := map[string]interface{}{
+		"command":   "fetch_history",
+		"symbol":    request.Symbol,
+		"startDate": request.StartDate.Format(time.RFC3339),
+		"endDate":   request.EndDate.Format(time.RFC3339),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if err := g.publishCommand("queue://commands.fetch.history", message); err != nil {
+		return nil, err
+	}
+
+	return gin.H{"message": "Fetch history command sent", "symbol": request.Symbol}, nil
+}
+
+// publishCommand sends a command message to a broker queue
+func (g *Gateway) publishCommand(queue string, message map[string]interface{}) error {
+	if g.messageClient == nil {
+		return &commandError{http.StatusServiceUnavailable, "Message broker not available"}
+	}
+
+	if err := g.messageClient.PublishToQueue(queue, message); err != nil {
+		g.logger.Errorf("Failed to publish %s command: %v", message["command"], err)
+		return &commandError{http.StatusInternalServerError, "Failed to send command"}
+	}
+	return nil
+}
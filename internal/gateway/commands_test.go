@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/websocket"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+// wsMessage is a message sent to a WebSocket client
+type wsMessage struct {
+	Type string                 `json:"type"`
+	ID   string                 `json:"id"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// readReplies reads messages until it has seen a reply for each of ids and
+// returns the replies by id. Messages without an id are skipped.
+func readReplies(t *testing.T, conn *gorilla.Conn, ids ...string) map[string]wsMessage {
+	t.Helper()
+
+	replies := make(map[string]wsMessage)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for len(replies) < len(ids) {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read after %d replies: %v", len(replies), err)
+		}
+		// JSON clients get queued messages joined by newlines
+		for _, line := range strings.Split(string(data), "\n") {
+			var message wsMessage
+			if err := json.Unmarshal([]byte(line), &message); err != nil {
+				t.Fatalf("message %q is not JSON: %v", line, err)
+			}
+			if message.ID != "" {
+				replies[message.ID] = message
+			}
+		}
+	}
+	return replies
+}
+
+// send writes a client message, failing the test if it cannot be written
+func send(t *testing.T, conn *gorilla.Conn, message string) {
+	t.Helper()
+
+	if err := conn.WriteMessage(gorilla.TextMessage, []byte(message)); err != nil {
+		t.Fatalf("failed to send %s: %v", message, err)
+	}
+}
+
+func TestWebSocketCommandRepliesCarryRequestID(t *testing.T) {
+	tg := newTestGateway(t, nil)
+	alice := tg.dial(t, "/ws?token="+tg.token(t, "alice"), nil)
+	bob := tg.dial(t, "/ws?token="+tg.token(t, "bob"), nil)
+
+	// Requests are pipelined so each reply must be matched by its id
+	send(t, alice, `{"type":"mark_read","id":"read-1","data":{"ids":["e-1"]}}`)
+	send(t, alice, `{"type":"start_bot","id":"start-1","data":{}}`)
+	send(t, alice, `{"type":"launch_bot","id":"launch-1"}`)
+	send(t, alice, `{"type":"ping","id":"ping-1"}`)
+	send(t, bob, `{"type":"start_bot","id":"start-2","data":{"botId":"bot-1"}}`)
+
+	replies := readReplies(t, alice, "read-1", "start-1", "launch-1", "ping-1")
+	for id, reply := range readReplies(t, bob, "start-2") {
+		replies[id] = reply
+	}
+
+	tests := []struct {
+		id      string
+		typ     string
+		code    string
+		command string
+	}{
+		{"read-1", "ack", "", ""},
+		{"start-1", "error", websocket.ErrorInvalidPayload, "start_bot"},
+		{"launch-1", "error", websocket.ErrorUnknownType, "launch_bot"},
+		{"ping-1", "pong", "", ""},
+		{"start-2", "error", websocket.ErrorForbidden, "start_bot"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			reply := replies[tt.id]
+			if reply.Type != tt.typ {
+				t.Fatalf("reply type = %q, want %q (%v)", reply.Type, tt.typ, reply.Data)
+			}
+			if tt.code == "" {
+				return
+			}
+			if reply.Data["code"] != tt.code || reply.Data["command"] != tt.command {
+				t.Errorf("error data = %v, want code %q for %q", reply.Data, tt.code, tt.command)
+			}
+		})
+	}
+
+	if marked := replies["read-1"].Data["marked"]; marked != float64(0) {
+		t.Errorf("ack data marked = %v, want 0", marked)
+	}
+}
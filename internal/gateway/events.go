@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/websocket"
+)
+
+// SubscribeToEvents subscribes to the configured broker topics and forwards
+// every event to WebSocket clients. The topic name without its "topic://"
+// prefix is used as the message type and hub channel.
+func (g *Gateway) SubscribeToEvents() {
+	registryTopic := g.config.APIGatewayConfig.Bots.RegistryTopic
+	registrySubscribed := false
+
+	for _, topic := range g.config.ServiceDependencies.MessageBroker.SubscribedTopics {
+		channel := strings.TrimPrefix(topic, "topic://")
+		registry := topic == registryTopic
+		err := g.messageClient.SubscribeToTopic(topic, func(body []byte) error {
+			if registry {
+				g.recordBotOwner(body)
+			}
+			return g.forwardEvent(channel, body)
+		})
+		if err != nil {
+			g.logger.Errorf("Failed to subscribe to %s: %v", topic, err)
+		}
+		registrySubscribed = registrySubscribed || registry
+	}
+
+	// Bot owners are learned from the registry even when its events are
+	// not forwarded to clients
+	if !registrySubscribed {
+		err := g.messageClient.SubscribeToTopic(registryTopic, func(body []byte) error {
+			g.recordBotOwner(body)
+			return nil
+		})
+		if err != nil {
+			g.logger.Errorf("Failed to subscribe to %s: %v", registryTopic, err)
+		}
+	}
+
+	g.subscribeToPresence()
+	g.subscribeToAdmin()
+	g.subscribeToRevocations()
+	g.subscribeToReplication()
+}
+
+// subscribeToPresence shares WebSocket presence with the other gateway
+// replicas over the broker
+func (g *Gateway) subscribeToPresence() {
+	topic := g.config.APIGatewayConfig.WebSocket.Presence.Topic
+
+	g.wsHub.SetPresencePublisher(func(snapshot websocket.PresenceSnapshot) error {
+		return g.messageClient.PublishToTopic(topic, snapshot)
+	})
+
+	err := g.messageClient.SubscribeToTopic(topic, func(body []byte) error {
+		var snapshot websocket.PresenceSnapshot
+		if err := json.Unmarshal(body, &snapshot); err != nil {
+			return fmt.Errorf("failed to decode presence snapshot: %w", err)
+		}
+		g.wsHub.ApplyRemotePresence(snapshot)
+		return nil
+	})
+	if err != nil {
+		g.logger.Errorf("Failed to subscribe to %s: %v", topic, err)
+	}
+}
+
+// subscribeToAdmin carries out admin requests for live sessions made on
+// other replicas and collects their replies to requests made here
+func (g *Gateway) subscribeToAdmin() {
+	topic := g.config.APIGatewayConfig.WebSocket.Admin.Topic
+
+	g.wsHub.SetAdminPublisher(func(message websocket.AdminMessage) error {
+		return g.messageClient.PublishToTopic(topic, message)
+	})
+
+	err := g.messageClient.SubscribeToTopic(topic, func(body []byte) error {
+		var message websocket.AdminMessage
+		if err := json.Unmarshal(body, &message); err != nil {
+			return fmt.Errorf("failed to decode admin message: %w", err)
+		}
+		g.wsHub.ApplyAdminMessage(message)
+		return nil
+	})
+	if err != nil {
+		g.logger.Errorf("Failed to subscribe to %s: %v", topic, err)
+	}
+}
+
+// subscribeToRevocations applies token revocations made on other replicas
+// and asks them for the revocations made before this replica started
+func (g *Gateway) subscribeToRevocations() {
+	shared, ok := g.revocations.(*auth.SharedRevocationList)
+	if !ok {
+		return
+	}
+
+	// Sessions revoked on other replicas end their clients here too
+	shared.OnRevoke(func(id string) {
+		g.wsHub.DisconnectSession(id, sessionRevokedReason)
+	})
+
+	topic := g.config.APIGatewayConfig.Revocation.Topic
+	err := g.messageClient.SubscribeToTopic(topic, func(body []byte) error {
+		var message auth.RevocationMessage
+		if err := json.Unmarshal(body, &message); err != nil {
+			return fmt.Errorf("failed to decode revocation message: %w", err)
+		}
+		return shared.Apply(message)
+	})
+	if err != nil {
+		g.logger.Errorf("Failed to subscribe to %s: %v", topic, err)
+		return
+	}
+
+	if err := shared.RequestSync(); err != nil {
+		g.logger.Warnf("Failed to request token revocations: %v", err)
+	}
+}
+
+// subscribeToReplication applies changes to login state made on other
+// replicas and asks them for the state they held before this replica started
+func (g *Gateway) subscribeToReplication() {
+	if g.replication == nil {
+		return
+	}
+
+	topic := g.config.APIGatewayConfig.Replication.Topic
+	err := g.messageClient.SubscribeToTopic(topic, func(body []byte) error {
+		var message auth.ReplicationMessage
+		if err := json.Unmarshal(body, &message); err != nil {
+			return fmt.Errorf("failed to decode replication message: %w", err)
+		}
+		return g.replication.Apply(message)
+	})
+	if err != nil {
+		g.logger.Errorf("Failed to subscribe to %s: %v", topic, err)
+		return
+	}
+
+	if err := g.replication.RequestSync(); err != nil {
+		g.logger.Warnf("Failed to request login state: %v", err)
+	}
+}
+
+// forwardEvent routes a broker event to its owner when it carries a userId,
+// or to every client otherwise
+func (g *Gateway) forwardEvent(channel string, body []byte) error {
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return fmt.Errorf("failed to decode event: %w", err)
+	}
+
+	if userID, ok := data["userId"].(string); ok && userID != "" {
+		if g.wsHub.BroadcastToUser(userID, channel, data) == 0 {
+			g.queueOfflineEvent(userID, channel, body)
+		}
+		return nil
+	}
+
+	g.wsHub.BroadcastMessage(channel, data)
+	return nil
+}
@@ -7,8 +7,10 @@ import (
 	"strings"
 	"time"
 
+	"cryptobot-api-gateway/internal/auth"
 	"cryptobot-api-gateway/internal/config"
 	"cryptobot-api-gateway/internal/messaging"
+	"cryptobot-api-gateway/internal/offline"
 	"cryptobot-api-gateway/internal/websocket"
 
 	"github.com/gin-gonic/gin"
@@ -20,17 +22,86 @@ type Gateway struct {
 	config        *config.Config
 	messageClient *messaging.MessageClient
 	wsHub         *websocket.Hub
+	offlineStore  offline.Store
+	userStore     auth.UserStore
+	revocations   auth.RevocationList
+	replication   *auth.Replication
+	refreshTokens *auth.RefreshTokens
+	sessions      *auth.Sessions
+	keys          *auth.KeySet
+	apiKeys       *auth.APIKeys
+	oidc          *auth.OIDCProvider
+	externalUsers *auth.ExternalUserStore
+	mfa           *auth.MFA
+	bots          *auth.BotOwners
+	loginThrottle *auth.LoginThrottle
 	logger        *logrus.Entry
+	security      *logrus.Entry
+}
+
+// Deps are the stores and services a gateway uses. MessageClient may be
+// nil when the broker is unavailable, OfflineStore nil to disable queueing
+// of events for offline users, Replication nil when login state is not
+// shared with other replicas, and OIDC nil to disable login through an
+// identity provider.
+type Deps struct {
+	MessageClient *messaging.MessageClient
+	WSHub         *websocket.Hub
+	OfflineStore  offline.Store
+	UserStore     auth.UserStore
+	Revocations   auth.RevocationList
+	Replication   *auth.Replication
+	RefreshTokens *auth.RefreshTokens
+	Sessions      *auth.Sessions
+	Keys          *auth.KeySet
+	APIKeys       *auth.APIKeys
+	OIDC          *auth.OIDCProvider
+	MFA           *auth.MFA
+	Bots          *auth.BotOwners
+	LoginThrottle *auth.LoginThrottle
+	Logger        *logrus.Entry
 }
 
 // NewGateway creates a new gateway instance
-func NewGateway(cfg *config.Config, messageClient *messaging.MessageClient, wsHub *websocket.Hub, logger *logrus.Entry) *Gateway {
-	return &Gateway{
+func NewGateway(cfg *config.Config, deps Deps) *Gateway {
+	g := &Gateway{
 		config:        cfg,
-		messageClient: messageClient,
-		wsHub:         wsHub,
-		logger:        logger,
+		messageClient: deps.MessageClient,
+		wsHub:         deps.WSHub,
+		offlineStore:  deps.OfflineStore,
+		userStore:     deps.UserStore,
+		revocations:   deps.Revocations,
+		replication:   deps.Replication,
+		refreshTokens: deps.RefreshTokens,
+		sessions:      deps.Sessions,
+		keys:          deps.Keys,
+		apiKeys:       deps.APIKeys,
+		oidc:          deps.OIDC,
+		externalUsers: auth.NewExternalUserStore(),
+		mfa:           deps.MFA,
+		bots:          deps.Bots,
+		loginThrottle: deps.LoginThrottle,
+		logger:        deps.Logger,
+		security:      deps.Logger.WithField("log", "security"),
+	}
+	if g.replication != nil {
+		g.externalUsers = auth.NewSharedExternalUserStore(g.replication)
 	}
+
+	// Sessions revoked on other replicas end their clients here too
+	g.sessions.OnRemoteEnd(func(id string) {
+		g.wsHub.DisconnectSession(id, sessionRevokedReason)
+	})
+
+	// Bot commands can also be sent over the WebSocket connection
+	g.wsHub.SetCommandHandler(g.executeCommand, "start_bot", "stop_bot", "fetch_history", "mark_read")
+	g.wsHub.SetTokenValidator(g.validateWebSocketToken)
+
+	if g.offlineStore != nil {
+		g.wsHub.SetBacklogProvider(g.offlineBacklog)
+	}
+
+	return g
 }
 
 // SetupRoutes configures all routes for the gateway
@@ -44,6 +115,13 @@ func (g *Gateway) SetupRoutes() *gin.Engine {
 
 	router := gin.New()
 
+	// Only believe X-Forwarded-For from our own proxies; client addresses
+	// feed login lockouts and API key allowlists
+	if err := router.SetTrustedProxies(g.config.APIGatewayConfig.TrustedProxies); err != nil {
+		g.logger.Errorf("Invalid trustedProxies, trusting no proxy: %v", err)
+		router.SetTrustedProxies(nil)
+	}
+
 	// Middleware
 	router.Use(g.corsMiddleware())
 	router.Use(g.loggingMiddleware())
@@ -52,19 +130,41 @@ func (g *Gateway) SetupRoutes() *gin.Engine {
 	// Health check endpoint
 	router.GET("/health", g.healthCheck)
 
+	// Public keys for services verifying gateway tokens
+	router.GET("/.well-known/jwks.json", g.handleJWKS)
+
 	// Authentication routes (no auth required)
 	g.setupAuthRoutes(router)
+	g.setupOIDCRoutes(router)
+	g.setupAPIKeyRoutes(router)
 
 	// WebSocket endpoint for real-time updates
 	router.GET("/ws", g.handleWebSocket)
 
+	// Server-Sent Events alternative to the WebSocket endpoint
+	router.GET("/events/stream", g.authMiddleware(), g.rejectAPIKeys(), g.handleEventStream)
+
+	// Private events queued while the user was offline
+	offlineEvents := router.Group("/events/offline")
+	{
+		offlineEvents.Use(g.authMiddleware(), g.rejectAPIKeys())
+		offlineEvents.GET("", g.handleListOfflineEvents)
+		offlineEvents.POST("/read", g.handleMarkOfflineEventsRead)
+	}
+
+	// Users currently connected, with the bot dashboards they have open
+	router.GET("/presence", g.authMiddleware(), g.rejectAPIKeys(), g.handlePresence)
+
+	// WebSocket queue metrics, which name every connected user
+	router.GET("/metrics/websocket", g.authMiddleware(), g.requireRole("admin"), g.handleWebSocketMetrics)
+
 	// API routes that proxy to microservices
 	api := router.Group("/api")
 	{
 		api.Use(g.authMiddleware())
 
 		// Route to UI service (if needed for API calls)
-		api.Any("/ui/*path", g.proxyToUIService)
+		api.Any("/ui/*path", g.rejectAPIKeys(), g.proxyToUIService)
 
 		// Route to internal microservices
 		for _, service := range g.config.ServiceDependencies.InternalServices {
@@ -76,15 +176,18 @@ func (g *Gateway) SetupRoutes() *gin.Engine {
 	commands := router.Group("/commands")
 	{
 		commands.Use(g.authMiddleware())
-		commands.POST("/start-bot", g.handleStartBot)
-		commands.POST("/stop-bot", g.handleStopBot)
-		commands.POST("/fetch-history", g.handleFetchHistory)
+		commands.POST("/start-bot", g.requireCommand("start_bot"), g.handleStartBot)
+		commands.POST("/stop-bot", g.requireCommand("stop_bot"), g.handleStopBot)
+		commands.POST("/fetch-history", g.requireCommand("fetch_history"), g.handleFetchHistory)
 	}
 
+	// Admin endpoints for live WebSocket sessions
+	g.setupAdminRoutes(router)
+
 	// External API proxies (like Coinbase)
 	external := router.Group("/external")
 	{
-		external.Use(g.authMiddleware())
+		external.Use(g.authMiddleware(), g.rejectAPIKeys())
 		external.Any("/coinbase/*path", g.proxyToCoinbase)
 	}
 
@@ -99,11 +202,26 @@ func (g *Gateway) setupServiceProxy(group *gin.RouterGroup, service config.Inter
 		return
 	}
 
+	switch {
+	case service.Identity != identityHeaders && service.Identity != identityToken:
+		g.logger.Errorf("Invalid identity mode %q for service %s", service.Identity, service.Name)
+		return
+	case service.Identity == identityToken && service.Audience == g.config.APIGatewayConfig.Tokens.Audience:
+		g.logger.Errorf("Audience for service %s must differ from the gateway token audience", service.Name)
+		return
+	}
+
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 	proxy.ErrorHandler = g.proxyErrorHandler
 
 	// Remove the route prefix from the path before forwarding
-	group.Any(service.RoutePrefix+"/*path", func(c *gin.Context) {
+	group.Any(service.RoutePrefix+"/*path", g.requireServiceAccess(service), func(c *gin.Context) {
+		if err := g.forwardIdentity(c, service); err != nil {
+			g.logger.Errorf("Failed to mint token for service %s: %v", service.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to forward request"})
+			return
+		}
+
 		// Remove the route prefix from the request path
 		originalPath := c.Request.URL.Path
 		newPath := strings.TrimPrefix(originalPath, service.RoutePrefix)
@@ -189,9 +307,22 @@ func (g *Gateway) healthCheck(c *gin.Context) {
 		},
 	}
 
+	// Fail readiness while draining so no new clients are routed here
+	if g.wsHub.IsShuttingDown() {
+		status["status"] = "shutting_down"
+		c.JSON(http.StatusServiceUnavailable, status)
+		return
+	}
+
 	c.JSON(http.StatusOK, status)
 }
 
+// handleJWKS publishes the public keys that verify gateway tokens
+func (g *Gateway) handleJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, g.keys.JWKS())
+}
+
 // checkMessageBrokerHealth checks if the message broker is healthy
 func (g *Gateway) checkMessageBrokerHealth() string {
 	if g.messageClient == nil {
@@ -203,107 +334,68 @@ func (g *Gateway) checkMessageBrokerHealth() string {
 	return "error"
 }
 
-// handleWebSocket upgrades HTTP connection to WebSocket
+// handleWebSocket upgrades HTTP connection to WebSocket. Browsers cannot set
+// headers on WebSocket requests, so the token may also be passed as ?token=.
+// Clients without a token connect anonymously and only receive public events.
 func (g *Gateway) handleWebSocket(c *gin.Context) {
-	g.wsHub.HandleWebSocket(c.Writer, c.Request)
-}
-
-// Command handlers for bot control
-func (g *Gateway) handleStartBot(c *gin.Context) {
-	if g.messageClient == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Message broker not available"})
-		return
-	}
-
-	var request struct {
-		BotID  string                 `json:"botId" binding:"required"`
-		Config map[string]interface{} `json:"config"`
-	}
+	var identity websocket.Identity
 
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	message := map[string]interface{}{
-		"command":   "start",
-		"botId":     request.BotID,
-		"config":    request.Config,
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if tokenString == "" {
+		tokenString = c.Query("token")
 	}
 
-	err := g.messageClient.PublishToQueue("queue://commands.start_bot", message)
-	if err != nil {
-		g.logger.Errorf("Failed to publish start bot command: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send command"})
-		return
+	if tokenString != "" {
+		var err error
+		identity, err = g.validateWebSocketToken(tokenString)
+		if err != nil {
+			g.logger.Warnf("Invalid WebSocket token: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
 	}
 
-	c.JSON(http.StatusAccepted, gin.H{"message": "Start bot command sent", "botId": request.BotID})
+	g.wsHub.HandleWebSocket(c.Writer, c.Request, identity, c.ClientIP())
 }
 
-func (g *Gateway) handleStopBot(c *gin.Context) {
-	if g.messageClient == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Message broker not available"})
-		return
-	}
-
-	var request struct {
-		BotID string `json:"botId" binding:"required"`
-	}
-
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	message := map[string]interface{}{
-		"command":   "stop",
-		"botId":     request.BotID,
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
-	}
-
-	err := g.messageClient.PublishToQueue("queue://commands.stop_bot", message)
+// validateWebSocketToken parses a token into the identity of a WebSocket session
+func (g *Gateway) validateWebSocketToken(tokenString string) (websocket.Identity, error) {
+	claims, err := g.parseToken(tokenString)
 	if err != nil {
-		g.logger.Errorf("Failed to publish stop bot command: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send command"})
-		return
+		return websocket.Identity{}, err
 	}
-
-	c.JSON(http.StatusAccepted, gin.H{"message": "Stop bot command sent", "botId": request.BotID})
+	return identityFromClaims(claims), nil
 }
 
-func (g *Gateway) handleFetchHistory(c *gin.Context) {
-	if g.messageClient == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Message broker not available"})
-		return
-	}
-
-	var request struct {
-		Symbol    string    `json:"symbol" binding:"required"`
-		StartDate time.Time `json:"startDate" binding:"required"`
-		EndDate   time.Time `json:"endDate" binding:"required"`
-	}
-
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+// identityFromClaims builds a WebSocket identity from validated token claims
+func identityFromClaims(claims *auth.Claims) websocket.Identity {
+	var authTime time.Time
+	if claims.AuthTime != nil {
+		authTime = claims.AuthTime.Time
 	}
 
-	message := map[string]interface{}{
-		"command":   "fetch_history",
-		"symbol":    request.Symbol,
-		"startDate": request.StartDate.Format(time.RFC3339),
-		"endDate":   request.EndDate.Format(time.RFC3339),
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	return websocket.Identity{
+		UserID:        claims.UserID,
+		Username:      claims.Username,
+		SessionID:     claims.SessionID,
+		Roles:         claims.Roles,
+		Authenticated: true,
+		ExpiresAt:     claims.ExpiresAt.Time,
+		AuthTime:      authTime,
+		AMR:           claims.AMR,
 	}
+}
 
-	err := g.messageClient.PublishToQueue("queue://commands.fetch.history", message)
-	if err != nil {
-		g.logger.Errorf("Failed to publish fetch history command: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send command"})
-		return
-	}
+// handlePresence returns the users currently online across all replicas
+func (g *Gateway) handlePresence(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"users": g.wsHub.GetPresence()})
+}
 
-	c.JSON(http.StatusAccepted, gin.H{"message": "Fetch history command sent", "symbol": request.Symbol})
+// handleWebSocketMetrics reports per-client queue depth and drop counters
+func (g *Gateway) handleWebSocketMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"clients":         g.wsHub.GetClientStats(),
+		"slowDisconnects": g.wsHub.GetSlowDisconnectCount(),
+		"rejections":      g.wsHub.GetRejectionCounts(),
+	})
 }
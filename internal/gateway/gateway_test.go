@@ -0,0 +1,398 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/config"
+	"cryptobot-api-gateway/internal/offline"
+	"cryptobot-api-gateway/internal/websocket"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// testPassword is the password of every test user
+const testPassword = "correct horse battery staple"
+
+var (
+	testHashOnce sync.Once
+	testHash     string
+)
+
+// testUsers returns the accounts known to test gateways
+func testUsers(t *testing.T) []auth.User {
+	t.Helper()
+
+	testHashOnce.Do(func() {
+		var err error
+		if testHash, err = auth.HashPassword(testPassword, auth.HashBcrypt); err != nil {
+			t.Fatalf("failed to hash password: %v", err)
+		}
+	})
+
+	return []auth.User{
+		{ID: "u-alice", Username: "alice", PasswordHash: testHash, Roles: []string{"user", "trader"}, Teams: []string{"desk"}},
+		{ID: "u-bob", Username: "bob", PasswordHash: testHash, Roles: []string{"user"}},
+		{ID: "u-root", Username: "root", PasswordHash: testHash, Roles: []string{"user", "admin"}},
+		{ID: "u-dave", Username: "dave", PasswordHash: testHash, Roles: []string{"user"}, Disabled: true},
+	}
+}
+
+// testGateway is a gateway with in-memory and temporary file stores,
+// served by an httptest server
+type testGateway struct {
+	*Gateway
+	server *httptest.Server
+}
+
+// newTestGateway creates a gateway for tests. configure, if not nil, may
+// change the configuration before the gateway is built.
+func newTestGateway(t *testing.T, configure func(cfg *config.Config)) *testGateway {
+	t.Helper()
+	return newReplicaTestGateway(t, nil, configure)
+}
+
+// newReplicaTestGateway creates a gateway for tests that shares login state,
+// API keys, MFA enrollments, bot ownership and offline read marks through
+// replication, as
+// replicas sharing a broker do
+func newReplicaTestGateway(t *testing.T, replication *auth.Replication, configure func(cfg *config.Config)) *testGateway {
+	t.Helper()
+
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		t.Fatalf("failed to load default config: %v", err)
+	}
+	dir := t.TempDir()
+	cfg.APIGatewayConfig.LogLevel = "error"
+	cfg.APIGatewayConfig.JWTSecretKey = "test-secret"
+	cfg.APIGatewayConfig.OIDC.StateSecret = "test-state-secret"
+	cfg.APIGatewayConfig.APIKeys.File = filepath.Join(dir, "api-keys.json")
+	cfg.APIGatewayConfig.APIKeys.SigningSecret = "test-signing-secret"
+	cfg.APIGatewayConfig.MFA.File = filepath.Join(dir, "mfa.json")
+	cfg.APIGatewayConfig.Bots.File = filepath.Join(dir, "bots.json")
+	cfg.APIGatewayConfig.OfflineQueue.Path = filepath.Join(dir, "offline-events.log")
+	cfg.APIGatewayConfig.OfflineQueue.Channels = []string{"trades.filled"}
+	cfg.APIGatewayConfig.Authorization.RolePermissions = map[string][]string{
+		"admin":  {"*"},
+		"trader": {"trade:execute", "order:cancel", "bot:control", "report:read"},
+		"user":   {"report:read"},
+	}
+	cfg.APIGatewayConfig.Authorization.Commands = map[string][]string{
+		"start_bot":     {"bot:control"},
+		"stop_bot":      {"bot:control"},
+		"fetch_history": {"bot:control"},
+	}
+	cfg.APIGatewayConfig.MFA.StepUp.Commands = []string{"stop_bot"}
+	if configure != nil {
+		configure(cfg)
+	}
+	gatewayCfg := cfg.APIGatewayConfig
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	entry := logrus.NewEntry(logger)
+
+	wsHub := websocket.NewHub(gatewayCfg.WebSocket, entry)
+	go wsHub.Run()
+	t.Cleanup(wsHub.Close)
+
+	fileOfflineStore, err := offline.NewFileStore(gatewayCfg.OfflineQueue.Path, gatewayCfg.OfflineQueue.MaxPerUser, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open offline store: %v", err)
+	}
+	t.Cleanup(func() { fileOfflineStore.Close() })
+
+	userStore, err := auth.NewMemoryUserStore(testUsers(t)...)
+	if err != nil {
+		t.Fatalf("failed to create user store: %v", err)
+	}
+
+	fileAPIKeyStore, err := auth.NewFileAPIKeyStore(gatewayCfg.APIKeys.File)
+	if err != nil {
+		t.Fatalf("failed to open api key store: %v", err)
+	}
+	fileMFAStore, err := auth.NewFileMFAStore(gatewayCfg.MFA.File)
+	if err != nil {
+		t.Fatalf("failed to open mfa store: %v", err)
+	}
+	fileBotStore, err := auth.NewFileBotStore(gatewayCfg.Bots.File)
+	if err != nil {
+		t.Fatalf("failed to open bot store: %v", err)
+	}
+
+	var oidcProvider *auth.OIDCProvider
+	if oidcCfg := gatewayCfg.OIDC; oidcCfg.Enabled {
+		oidcProvider = auth.NewOIDCProvider(auth.OIDCClientConfig{
+			IssuerURL:   oidcCfg.IssuerURL,
+			ClientID:    oidcCfg.ClientID,
+			RedirectURL: oidcCfg.RedirectURL,
+			Scopes:      oidcCfg.Scopes,
+		})
+	}
+
+	var refreshStore auth.RefreshStore = auth.NewMemoryRefreshStore()
+	var sessionStore auth.SessionStore = auth.NewMemorySessionStore()
+	if replication != nil {
+		refreshStore = auth.NewSharedRefreshStore(replication)
+		sessionStore = auth.NewSharedSessionStore(replication)
+	}
+	var apiKeyStore auth.APIKeyStore = fileAPIKeyStore
+	if replication != nil {
+		apiKeyStore = auth.NewSharedAPIKeyStore(fileAPIKeyStore, replication)
+	}
+	var mfaStore auth.MFAStore = fileMFAStore
+	if replication != nil {
+		mfaStore = auth.NewSharedMFAStore(fileMFAStore, replication)
+	}
+	var botStore auth.BotStore = fileBotStore
+	if replication != nil {
+		botStore = auth.NewSharedBotStore(fileBotStore, replication)
+	}
+	var offlineStore offline.Store = fileOfflineStore
+	if replication != nil {
+		offlineStore = auth.NewSharedOfflineStore(fileOfflineStore, time.Hour, replication)
+	}
+
+	g := NewGateway(cfg, Deps{
+		WSHub:         wsHub,
+		OfflineStore:  offlineStore,
+		UserStore:     userStore,
+		Revocations:   auth.NewMemoryRevocationList(),
+		Replication:   replication,
+		RefreshTokens: auth.NewRefreshTokens(refreshStore, time.Hour, 24*time.Hour),
+		Sessions:      auth.NewSessions(sessionStore),
+		Keys:          auth.NewHMACKeySet([]byte(gatewayCfg.JWTSecretKey)),
+		APIKeys:       auth.NewAPIKeys(apiKeyStore, []byte(gatewayCfg.APIKeys.SigningSecret), 30*time.Second, auth.NewMemoryNonceCache()),
+		OIDC:          oidcProvider,
+		MFA:           auth.NewMFA(mfaStore, gatewayCfg.MFA.Issuer),
+		Bots:          auth.NewBotOwners(botStore),
+		LoginThrottle: auth.NewLoginThrottle(auth.NewMemoryLoginAttemptStore(), auth.LoginThrottleConfig{
+			Window:   time.Minute,
+			Username: auth.LockoutPolicy{MaxFailures: 3, LockoutDuration: time.Minute},
+			IP:       auth.LockoutPolicy{MaxFailures: 10, LockoutDuration: time.Minute},
+		}),
+		Logger: entry,
+	})
+
+	server := httptest.NewServer(g.SetupRoutes())
+	t.Cleanup(server.Close)
+
+	return &testGateway{Gateway: g, server: server}
+}
+
+// user returns the test user with the given username
+func (tg *testGateway) user(t *testing.T, username string) auth.User {
+	t.Helper()
+
+	user, err := tg.userStore.GetUser(username)
+	if err != nil {
+		t.Fatalf("unknown test user %s: %v", username, err)
+	}
+	return user
+}
+
+// token returns an access token for a test user who logged in with a
+// password just now
+func (tg *testGateway) token(t *testing.T, username string) string {
+	t.Helper()
+	return tg.tokenWithAMR(t, username, []string{auth.AMRPassword})
+}
+
+// steppedUpToken returns an access token for a test user who completed a
+// second factor just now
+func (tg *testGateway) steppedUpToken(t *testing.T, username string) string {
+	t.Helper()
+	return tg.tokenWithAMR(t, username, []string{auth.AMRPassword, auth.AMROTP, auth.AMRMFA})
+}
+
+// tokenWithAMR returns an access token for a test user who authenticated
+// just now with the amr methods
+func (tg *testGateway) tokenWithAMR(t *testing.T, username string, amr []string) string {
+	t.Helper()
+
+	token, _, err := tg.generateJWTToken(tg.user(t, username), "", time.Now(), amr)
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	return token
+}
+
+// do sends a request to the test gateway. body is encoded as JSON unless
+// it is nil, and header values are added to the request.
+func (tg *testGateway) do(t *testing.T, method, path string, body interface{}, header http.Header) (int, map[string]interface{}) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("failed to encode request: %v", err)
+		}
+		reader = strings.NewReader(string(data))
+	}
+
+	request, err := http.NewRequest(method, tg.server.URL+path, reader)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer response.Body.Close()
+
+	var result map[string]interface{}
+	json.NewDecoder(response.Body).Decode(&result)
+	return response.StatusCode, result
+}
+
+// bearer returns an Authorization header carrying token
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// dial opens a WebSocket connection to path on the test gateway
+func (tg *testGateway) dial(t *testing.T, path string, header http.Header) *gorilla.Conn {
+	t.Helper()
+
+	conn, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(tg.server.URL, "http")+path, header)
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", path, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// eventually fails the test if condition does not hold within a second
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebSocketUserIDQueryDoesNotReceivePrivateEvents(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	tg.dial(t, "/ws?user_id=u-alice", nil)
+	eventually(t, func() bool { return tg.wsHub.GetClientCount() == 1 })
+
+	if err := tg.forwardEvent("trades.filled", []byte(`{"userId":"u-alice","symbol":"BTC-USD"}`)); err != nil {
+		t.Fatalf("forwardEvent: %v", err)
+	}
+
+	// The anonymous socket must not count as a delivery, so the event is
+	// queued for the real user instead
+	events, err := tg.offlineStore.List("u-alice")
+	if err != nil {
+		t.Fatalf("failed to list offline events: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d queued events, want 1", len(events))
+	}
+}
+
+func TestWebSocketDeliversPrivateEventsToAuthenticatedOwner(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	conn := tg.dial(t, "/ws?token="+tg.token(t, "alice"), nil)
+	eventually(t, func() bool { return tg.wsHub.IsUserOnline("u-alice") })
+
+	if delivered := tg.wsHub.BroadcastToUser("u-bob", "trades.filled", map[string]interface{}{"userId": "u-bob"}); delivered != 0 {
+		t.Fatalf("event for another user delivered to %d clients", delivered)
+	}
+	if delivered := tg.wsHub.BroadcastToUser("u-alice", "trades.filled", map[string]interface{}{"userId": "u-alice"}); delivered != 1 {
+		t.Fatalf("event delivered to %d clients, want 1", delivered)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		var message struct {
+			Type string `json:"type"`
+		}
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("failed to read event: %v", err)
+		}
+		if message.Type == "trades.filled" {
+			return
+		}
+	}
+}
+
+func TestJWKSDoesNotPublishSharedSecret(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	status, body := tg.do(t, http.MethodGet, "/.well-known/jwks.json", nil, nil)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	if keys, _ := body["keys"].([]interface{}); len(keys) != 0 {
+		t.Errorf("JWKS of an HMAC key set lists %d keys, want none", len(keys))
+	}
+}
+
+// testBackend is an internal service that records the last request it served
+type testBackend struct {
+	server  *httptest.Server
+	mu      sync.Mutex
+	request *http.Request
+}
+
+// newTestBackend starts an internal service that answers every request
+// with 200
+func newTestBackend(t *testing.T) *testBackend {
+	t.Helper()
+
+	backend := &testBackend{}
+	backend.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backend.mu.Lock()
+		backend.request = r.Clone(r.Context())
+		backend.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(backend.server.Close)
+	return backend
+}
+
+// service returns an internal service proxied to the backend under prefix,
+// open to callers that satisfy require
+func (b *testBackend) service(name, prefix string, require ...string) config.InternalService {
+	return config.InternalService{
+		Name:        name,
+		RoutePrefix: prefix,
+		TargetURL:   b.server.URL,
+		Require:     require,
+		Identity:    identityHeaders,
+		Audience:    name,
+	}
+}
+
+// lastRequest returns the last request the backend served, or nil
+func (b *testBackend) lastRequest() *http.Request {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.request
+}
@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Ways of passing the caller's identity to an internal service
+const (
+	identityHeaders = "headers"
+	identityToken   = "token"
+)
+
+// Identity headers set on requests proxied to internal services
+const (
+	headerUserID    = "X-User-ID"
+	headerUsername  = "X-Username"
+	headerUserRoles = "X-User-Roles"
+)
+
+// errInternalToken rejects tokens minted for internal services when they
+// are presented to the gateway
+var errInternalToken = errors.New("internal service token not accepted")
+
+// forwardIdentity replaces the caller's credentials on a request proxied to
+// service with their identity. Client-supplied X-User headers are always
+// removed, so services can trust the ones the gateway sets.
+func (g *Gateway) forwardIdentity(c *gin.Context, service config.InternalService) error {
+	header := c.Request.Header
+	for name := range header {
+		if strings.HasPrefix(strings.ToLower(name), "x-user") {
+			header.Del(name)
+		}
+	}
+	header.Del("Authorization")
+	header.Del("X-API-Key")
+
+	claims := CurrentUser(c)
+	if service.Identity == identityToken {
+		token, err := g.internalToken(claims, service.Audience)
+		if err != nil {
+			return err
+		}
+		header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+
+	header.Set(headerUserID, claims.UserID)
+	header.Set(headerUsername, claims.Username)
+	header.Set(headerUserRoles, strings.Join(claims.Roles, ","))
+	return nil
+}
+
+// internalToken mints a short-lived token for the caller that only the
+// internal service with the given audience accepts
+func (g *Gateway) internalToken(caller *auth.Claims, audience string) (string, error) {
+	now := time.Now()
+	tokens := g.config.APIGatewayConfig.Tokens
+
+	claims := &auth.Claims{
+		UserID:    caller.UserID,
+		Username:  caller.Username,
+		Roles:     caller.Roles,
+		SessionID: caller.SessionID,
+		AuthTime:  caller.AuthTime,
+		AMR:       caller.AMR,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			Subject:   caller.UserID,
+			Issuer:    tokens.Issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(tokens.InternalTokenSeconds) * time.Second)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	return g.keys.Sign(claims)
+}
+
+// isInternalAudience reports whether a token was minted for an internal
+// service rather than for clients of the gateway. Services sharing the
+// gateway's own audience are not proxied, so they are skipped.
+func (g *Gateway) isInternalAudience(audience jwt.ClaimStrings) bool {
+	for _, service := range g.config.ServiceDependencies.InternalServices {
+		if service.Identity != identityToken || service.Audience == g.config.APIGatewayConfig.Tokens.Audience {
+			continue
+		}
+		for _, aud := range audience {
+			if aud == service.Audience {
+				return true
+			}
+		}
+	}
+	return false
+}
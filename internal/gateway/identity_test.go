@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestForwardIdentityHeaders(t *testing.T) {
+	backend := newTestBackend(t)
+	tg := newTestGateway(t, func(cfg *config.Config) {
+		cfg.APIGatewayConfig.APIKeys.ScopePermissions = map[string][]string{"trade": {"trade:execute"}}
+		cfg.ServiceDependencies.InternalServices = []config.InternalService{backend.service("buy-sell-engine", "/v1/trade/execute", "trade:execute")}
+	})
+	key, _ := tg.createAPIKey(t, "alice", CreateAPIKeyRequest{Name: "bot", Scopes: []string{"trade"}})
+
+	// Identity headers sent by the client must never reach the service
+	spoofed := http.Header{
+		"X-User-ID":    {"u-root"},
+		"X-User-Roles": {"admin"},
+		"X-User-Admin": {"true"},
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		{"token", bearer(tg.token(t, "alice"))},
+		{"api key", apiKeyHeader(key)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header.Clone()
+			for name, values := range spoofed {
+				header[name] = values
+			}
+			if status, body := tg.do(t, http.MethodPost, "/api/v1/trade/execute/order", nil, header); status != http.StatusOK {
+				t.Fatalf("proxied request = %d %v, want %d", status, body, http.StatusOK)
+			}
+
+			forwarded := backend.lastRequest().Header
+			want := map[string]string{
+				headerUserID:    "u-alice",
+				headerUsername:  "alice",
+				headerUserRoles: "user,trader",
+				"X-User-Admin":  "",
+				"Authorization": "",
+				"X-API-Key":     "",
+			}
+			for name, value := range want {
+				if got := forwarded.Get(name); got != value {
+					t.Errorf("forwarded %s = %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardIdentityToken(t *testing.T) {
+	backend := newTestBackend(t)
+	tg := newTestGateway(t, func(cfg *config.Config) {
+		cfg.APIGatewayConfig.Tokens.Audience = "cryptobot"
+		trade := backend.service("buy-sell-engine", "/v1/trade/execute")
+		trade.Identity = identityToken
+		cfg.ServiceDependencies.InternalServices = []config.InternalService{trade}
+	})
+	callerToken := tg.steppedUpToken(t, "alice")
+
+	header := bearer(callerToken)
+	header.Set("X-User-ID", "u-root")
+	if status, body := tg.do(t, http.MethodPost, "/api/v1/trade/execute/order", nil, header); status != http.StatusOK {
+		t.Fatalf("proxied request = %d %v, want %d", status, body, http.StatusOK)
+	}
+
+	forwarded := backend.lastRequest().Header
+	if got := forwarded.Get("X-User-ID"); got != "" {
+		t.Errorf("forwarded X-User-ID = %q, want none", got)
+	}
+	internalToken := strings.TrimPrefix(forwarded.Get("Authorization"), "Bearer ")
+	if internalToken == "" || internalToken == callerToken {
+		t.Fatalf("service got Authorization %q, want a token of its own", forwarded.Get("Authorization"))
+	}
+
+	// The service verifies the token with the gateway's keys and its own
+	// audience, and sees the caller's identity and how they authenticated
+	claims := &auth.Claims{}
+	if _, err := jwt.ParseWithClaims(internalToken, claims, tg.keys.Keyfunc, jwt.WithAudience("buy-sell-engine")); err != nil {
+		t.Fatalf("internal token does not verify: %v", err)
+	}
+	if claims.UserID != "u-alice" || claims.Subject != "u-alice" || len(claims.AMR) != 3 {
+		t.Errorf("internal token claims user %q subject %q amr %v", claims.UserID, claims.Subject, claims.AMR)
+	}
+	if lifetime := claims.ExpiresAt.Sub(claims.IssuedAt.Time); lifetime.Seconds() != float64(tg.config.APIGatewayConfig.Tokens.InternalTokenSeconds) {
+		t.Errorf("internal token lifetime = %v", lifetime)
+	}
+
+	// and cannot replay it against the gateway
+	if status, _ := tg.do(t, http.MethodGet, "/auth/mfa", nil, bearer(internalToken)); status != http.StatusUnauthorized {
+		t.Errorf("internal token at the gateway = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
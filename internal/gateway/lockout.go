@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// allowLogin checks the login throttle before credentials are verified,
+// writing a 429 response and returning false if the attempt is refused
+func (g *Gateway) allowLogin(c *gin.Context, username string) bool {
+	wait, err := g.loginThrottle.Allow(username, c.ClientIP())
+	if errors.Is(err, auth.ErrLoginLocked) || errors.Is(err, auth.ErrLoginThrottled) {
+		seconds := int(math.Ceil(wait.Seconds()))
+		g.security.WithFields(logrus.Fields{
+			"event":       "login_refused",
+			"username":    username,
+			"client_ip":   c.ClientIP(),
+			"reason":      err.Error(),
+			"retry_after": seconds,
+		}).Warn("Login refused")
+
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts", "retryAfter": seconds})
+		return false
+	}
+	if err != nil {
+		g.logger.Errorf("Failed to check login throttle: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+		return false
+	}
+	return true
+}
+
+// loginFailed records a failed login in the throttle and the security log
+func (g *Gateway) loginFailed(c *gin.Context, username, reason string) {
+	fields := logrus.Fields{
+		"event":     "login_failed",
+		"username":  username,
+		"client_ip": c.ClientIP(),
+		"reason":    reason,
+	}
+
+	user, ip, err := g.loginThrottle.Failure(username, c.ClientIP())
+	if err != nil {
+		g.logger.Errorf("Failed to record failed login: %v", err)
+		g.security.WithFields(fields).Warn("Login failed")
+		return
+	}
+
+	fields["user_failures"] = user.Failures
+	fields["ip_failures"] = ip.Failures
+	g.security.WithFields(fields).Warn("Login failed")
+
+	now := time.Now()
+	for _, attempts := range []auth.LoginAttempts{user, ip} {
+		if attempts.Locked(now) {
+			g.security.WithFields(logrus.Fields{
+				"event":        "login_locked",
+				"key":          attempts.Key,
+				"failures":     attempts.Failures,
+				"locked_until": attempts.LockedUntil,
+			}).Warn("Login locked out")
+		}
+	}
+}
+
+// loginSucceeded clears the username's failed logins and records the login
+// in the security log
+func (g *Gateway) loginSucceeded(c *gin.Context, user auth.User) {
+	if err := g.loginThrottle.Success(user.Username); err != nil {
+		g.logger.Errorf("Failed to reset failed logins for %s: %v", user.Username, err)
+	}
+	g.security.WithFields(logrus.Fields{
+		"event":     "login_succeeded",
+		"user_id":   user.ID,
+		"username":  user.Username,
+		"client_ip": c.ClientIP(),
+	}).Info("Login succeeded")
+}
+
+// handleListLockouts lists usernames and client addresses that are locked out
+func (g *Gateway) handleListLockouts(c *gin.Context) {
+	lockouts, err := g.loginThrottle.Lockouts()
+	if err != nil {
+		g.logger.Errorf("Failed to list lockouts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list lockouts"})
+		return
+	}
+
+	g.auditAdminAction(c, "list_lockouts", logrus.Fields{"count": len(lockouts)})
+	c.JSON(http.StatusOK, gin.H{"lockouts": lockouts})
+}
+
+// handleUnlockUser clears a username's lockout and failed logins
+func (g *Gateway) handleUnlockUser(c *gin.Context) {
+	username := c.Param("username")
+	if err := g.loginThrottle.UnlockUser(username); err != nil {
+		g.logger.Errorf("Failed to unlock user %s: %v", username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	g.auditAdminAction(c, "unlock_user", logrus.Fields{"target_username": username})
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked", "username": username})
+}
+
+// handleUnlockIP clears a client address's lockout and failed logins
+func (g *Gateway) handleUnlockIP(c *gin.Context) {
+	ip := c.Param("ip")
+	if err := g.loginThrottle.UnlockIP(ip); err != nil {
+		g.logger.Errorf("Failed to unlock address %s: %v", ip, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock address"})
+		return
+	}
+
+	g.auditAdminAction(c, "unlock_ip", logrus.Fields{"target_ip": ip})
+	c.JSON(http.StatusOK, gin.H{"message": "Address unlocked", "ip": ip})
+}
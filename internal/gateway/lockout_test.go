@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"net/http"
+	"testing"
+
+	"cryptobot-api-gateway/internal/config"
+)
+
+func TestLoginLockout(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	// The test throttle locks a username after three failures
+	for i := 0; i < 3; i++ {
+		if status, _ := tg.login(t, "bob", "wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d = %d, want %d", i+1, status, http.StatusUnauthorized)
+		}
+	}
+
+	status, body := tg.login(t, "bob", testPassword)
+	if status != http.StatusTooManyRequests || body["retryAfter"] == nil {
+		t.Fatalf("login while locked = %d %v, want %d", status, body, http.StatusTooManyRequests)
+	}
+
+	// Other users from the same address are not affected
+	if status, _ := tg.login(t, "alice", testPassword); status != http.StatusOK {
+		t.Errorf("login of another user = %d, want %d", status, http.StatusOK)
+	}
+
+	admin := bearer(tg.token(t, "root"))
+	status, body = tg.do(t, http.MethodGet, "/admin/lockouts", nil, admin)
+	if lockouts, _ := body["lockouts"].([]interface{}); status != http.StatusOK || len(lockouts) != 1 {
+		t.Fatalf("list lockouts = %d %v, want one lockout", status, body)
+	}
+	if status, _ := tg.do(t, http.MethodDelete, "/admin/lockouts/users/bob", nil, admin); status != http.StatusOK {
+		t.Fatalf("unlock = %d, want %d", status, http.StatusOK)
+	}
+	if status, _ := tg.login(t, "bob", testPassword); status != http.StatusOK {
+		t.Errorf("login after unlock = %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestForwardedForOnlyFromTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		want           int
+	}{
+		{"no trusted proxies", nil, http.StatusUnauthorized},
+		{"other proxy", []string{"10.0.0.0/8"}, http.StatusUnauthorized},
+		{"trusted proxy", []string{"127.0.0.1"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newTestBackend(t)
+			tg := newTestGateway(t, func(cfg *config.Config) {
+				cfg.APIGatewayConfig.TrustedProxies = tt.trustedProxies
+				cfg.ServiceDependencies.InternalServices = []config.InternalService{backend.service("report-engine", "/v1/reports", "report:read")}
+			})
+			key, _ := tg.createAPIKey(t, "alice", CreateAPIKeyRequest{Name: "remote", Scopes: []string{"read"}, AllowedIPs: []string{"203.0.113.7"}})
+
+			header := apiKeyHeader(key)
+			header.Set("X-Forwarded-For", "203.0.113.7")
+			if status, _ := tg.do(t, http.MethodGet, "/api/v1/reports/daily", nil, header); status != tt.want {
+				t.Errorf("key used with a forwarded allowed address = %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
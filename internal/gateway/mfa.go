@@ -0,0 +1,377 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// MFACodeRequest carries a TOTP code
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableMFARequest carries a TOTP code and, unless the caller stepped up
+// recently, their password
+type DisableMFARequest struct {
+	Code     string `json:"code" binding:"required"`
+	Password string `json:"password"`
+}
+
+// stepUpAPIKeysSigned lets API keys make signed requests to operations
+// that need step-up; any other mfa.stepUp.apiKeys value refuses them
+const stepUpAPIKeysSigned = "signed"
+
+// StepUpResponse returns an access token carrying a fresh second factor
+type StepUpResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// setupMFARoutes adds TOTP enrollment and step-up routes to the router
+func (g *Gateway) setupMFARoutes(router *gin.Engine) {
+	mfa := router.Group("/auth/mfa")
+	{
+		mfa.Use(g.authMiddleware(), g.rejectAPIKeys())
+		mfa.GET("", g.handleMFAStatus)
+		mfa.POST("/enroll", g.handleMFAEnroll)
+		mfa.POST("/confirm", g.handleMFAConfirm)
+		mfa.POST("/verify", g.handleMFAStepUp)
+		mfa.DELETE("", g.handleMFADisable)
+	}
+}
+
+// handleMFAStatus reports whether the caller has a second factor
+func (g *Gateway) handleMFAStatus(c *gin.Context) {
+	user := CurrentUser(c)
+
+	enabled, err := g.mfa.Enabled(user.UserID)
+	if err != nil {
+		g.logger.Errorf("Failed to load MFA for user %s: %v", user.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load MFA status"})
+		return
+	}
+	if !enabled {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	remaining, err := g.mfa.RemainingRecoveryCodes(user.UserID)
+	if err != nil {
+		g.logger.Errorf("Failed to load MFA for user %s: %v", user.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load MFA status"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "recoveryCodesRemaining": remaining})
+}
+
+// handleMFAEnroll starts TOTP enrollment and returns the secret and its
+// provisioning URI for the authenticator app
+func (g *Gateway) handleMFAEnroll(c *gin.Context) {
+	user := CurrentUser(c)
+
+	secret, uri, err := g.mfa.Begin(user.UserID, user.Username)
+	if errors.Is(err, auth.ErrMFAAlreadyEnabled) {
+		c.JSON(http.StatusConflict, gin.H{"error": "MFA already enabled"})
+		return
+	}
+	if err != nil {
+		g.logger.Errorf("Failed to start MFA enrollment for user %s: %v", user.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start MFA enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "provisioningUri": uri})
+}
+
+// handleMFAConfirm enables the caller's second factor once they prove
+// their app produces valid codes, and returns their recovery codes
+func (g *Gateway) handleMFAConfirm(c *gin.Context) {
+	var request MFACodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := CurrentUser(c)
+	codes, err := g.mfa.Confirm(user.UserID, request.Code)
+	switch {
+	case errors.Is(err, auth.ErrMFANotEnrolled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA enrollment not started"})
+		return
+	case errors.Is(err, auth.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "MFA already enabled"})
+		return
+	case errors.Is(err, auth.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code"})
+		return
+	case err != nil:
+		g.logger.Errorf("Failed to confirm MFA for user %s: %v", user.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm MFA"})
+		return
+	}
+
+	g.logger.WithFields(logrus.Fields{"user_id": user.UserID}).Info("MFA enabled")
+	c.JSON(http.StatusOK, gin.H{"message": "MFA enabled", "recoveryCodes": codes})
+}
+
+// handleMFAStepUp verifies a TOTP code and returns an access token for the
+// caller's session that satisfies step-up requirements
+func (g *Gateway) handleMFAStepUp(c *gin.Context) {
+	var request MFACodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims := CurrentUser(c)
+	if !g.allowLogin(c, claims.Username) || !g.verifyMFACode(c, claims, request.Code) {
+		return
+	}
+
+	user, err := g.lookupUser(claims.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		return
+	}
+
+	token, expiresAt, err := g.generateJWTToken(user, claims.SessionID, time.Now(), mfaAMR(claims.AMR))
+	if err != nil {
+		g.logger.Errorf("Failed to generate JWT token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	c.JSON(http.StatusOK, StepUpResponse{Token: token, ExpiresAt: expiresAt})
+}
+
+// handleMFADisable removes the caller's second factor after checking a
+// current code and either their password or a recent step-up
+func (g *Gateway) handleMFADisable(c *gin.Context) {
+	var request DisableMFARequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := CurrentUser(c)
+	if !g.allowLogin(c, user.Username) {
+		return
+	}
+	if !g.claimsFreshMFA(user) && !g.verifyCurrentPassword(c, user, request.Password) {
+		return
+	}
+	if !g.verifyMFACode(c, user, request.Code) {
+		return
+	}
+	if err := g.mfa.Disable(user.UserID); err != nil {
+		g.logger.Errorf("Failed to disable MFA for user %s: %v", user.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable MFA"})
+		return
+	}
+
+	g.logger.WithFields(logrus.Fields{"user_id": user.UserID}).Info("MFA disabled")
+	c.JSON(http.StatusOK, gin.H{"message": "MFA disabled"})
+}
+
+// verifyMFACode checks a TOTP code for an enrolled user, counting a wrong
+// code as a failed login. Callers check the login throttle first. It
+// writes the error response and returns false if the code is not accepted.
+func (g *Gateway) verifyMFACode(c *gin.Context, user *auth.Claims, code string) bool {
+	err := g.mfa.Verify(user.UserID, code)
+	switch {
+	case err == nil:
+		if err := g.loginThrottle.Success(user.Username); err != nil {
+			g.logger.Errorf("Failed to reset failed logins for %s: %v", user.Username, err)
+		}
+		return true
+	case errors.Is(err, auth.ErrMFANotEnrolled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA not enabled"})
+	case errors.Is(err, auth.ErrInvalidMFACode):
+		g.loginFailed(c, user.Username, "invalid_mfa_code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code"})
+	default:
+		g.logger.Errorf("Failed to verify MFA for user %s: %v", user.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify MFA code"})
+	}
+	return false
+}
+
+// verifyCurrentPassword checks the caller's password, counting a wrong one
+// as a failed login. Callers check the login throttle first. It writes the
+// error response and returns false if the password is not accepted.
+func (g *Gateway) verifyCurrentPassword(c *gin.Context, user *auth.Claims, password string) bool {
+	if password == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Password or step-up authentication required", "stepUpRequired": true})
+		return false
+	}
+
+	_, err := auth.Authenticate(g.userStore, user.Username, password)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		g.loginFailed(c, user.Username, "invalid_password")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+	case errors.Is(err, auth.ErrUserDisabled):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+	default:
+		g.logger.Errorf("Failed to verify password for user %s: %v", user.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+	}
+	return false
+}
+
+// loginAMR checks the second factor of a password login for users who
+// enrolled one and returns the authentication methods used. It writes the
+// error response and returns false if the login must not proceed.
+func (g *Gateway) loginAMR(c *gin.Context, userID string, request LoginRequest) ([]string, bool) {
+	amr := []string{auth.AMRPassword}
+
+	enabled, err := g.mfa.Enabled(userID)
+	if err != nil {
+		g.logger.Errorf("Failed to load MFA for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+		return nil, false
+	}
+	if !enabled {
+		return amr, true
+	}
+
+	switch {
+	case request.OTP != "":
+		err = g.mfa.Verify(userID, request.OTP)
+		amr = append(amr, auth.AMROTP)
+	case request.RecoveryCode != "":
+		err = g.mfa.UseRecoveryCode(userID, request.RecoveryCode)
+		if err == nil {
+			g.logger.WithFields(logrus.Fields{"user_id": userID}).Warn("MFA recovery code used")
+		}
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "MFA code required", "mfaRequired": true})
+		return nil, false
+	}
+
+	if errors.Is(err, auth.ErrInvalidMFACode) {
+		g.loginFailed(c, request.Username, "invalid_mfa_code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code", "mfaRequired": true})
+		return nil, false
+	}
+	if err != nil {
+		g.logger.Errorf("Failed to verify MFA for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+		return nil, false
+	}
+	return append(amr, auth.AMRMFA), true
+}
+
+// mfaAMR adds a TOTP second factor to a list of authentication methods
+func mfaAMR(amr []string) []string {
+	result := make([]string, 0, len(amr)+2)
+	for _, method := range amr {
+		if method != auth.AMROTP && method != auth.AMRMFA {
+			result = append(result, method)
+		}
+	}
+	return append(result, auth.AMROTP, auth.AMRMFA)
+}
+
+// freshMFA reports whether a user completed a second factor within the
+// step-up window
+func (g *Gateway) freshMFA(authTime time.Time, amr []string) bool {
+	maxAge := time.Duration(g.config.APIGatewayConfig.MFA.StepUp.MaxAgeMinutes) * time.Minute
+	for _, method := range amr {
+		if method == auth.AMRMFA {
+			return !authTime.IsZero() && time.Since(authTime) <= maxAge
+		}
+	}
+	return false
+}
+
+// requiresStepUp reports whether requests to path need a fresh second factor
+func (g *Gateway) requiresStepUp(path string) bool {
+	for _, prefix := range g.config.APIGatewayConfig.MFA.StepUp.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandRequiresStepUp reports whether a command needs a fresh second factor
+func (g *Gateway) commandRequiresStepUp(command string) bool {
+	for _, name := range g.config.APIGatewayConfig.MFA.StepUp.Commands {
+		if name == command {
+			return true
+		}
+	}
+	return false
+}
+
+// claimsFreshMFA reports whether a token holder completed a second factor
+// within the step-up window
+func (g *Gateway) claimsFreshMFA(user *auth.Claims) bool {
+	var authTime time.Time
+	if user.AuthTime != nil {
+		authTime = user.AuthTime.Time
+	}
+	return g.freshMFA(authTime, user.AMR)
+}
+
+// requireFreshMFA rejects callers without a second factor from the
+// step-up window, whatever the path. It must run after authMiddleware.
+func (g *Gateway) requireFreshMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if g.claimsFreshMFA(CurrentUser(c)) {
+			c.Next()
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Step-up authentication required", "stepUpRequired": true})
+		c.Abort()
+	}
+}
+
+// checkStepUp rejects callers without a fresh second factor on routes and
+// commands that require one. API key callers are refused or must have
+// signed the request, as mfa.stepUp.apiKeys says. It writes the response
+// and returns false when the request must not proceed.
+func (g *Gateway) checkStepUp(c *gin.Context, command string) bool {
+	if !g.requiresStepUp(c.Request.URL.Path) && (command == "" || !g.commandRequiresStepUp(command)) {
+		return true
+	}
+
+	user := CurrentUser(c)
+	switch {
+	case user.APIKeyID == "" && g.claimsFreshMFA(user):
+		return true
+	case user.APIKeyID == "":
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Step-up authentication required", "stepUpRequired": true})
+	case g.config.APIGatewayConfig.MFA.StepUp.APIKeys != stepUpAPIKeysSigned:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not available to API keys"})
+	case user.Signed:
+		return true
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Request signature required"})
+	}
+	c.Abort()
+	return false
+}
+
+// checkCommandStepUp requires a fresh second factor from a WebSocket client
+// for commands that need one
+func (g *Gateway) checkCommandStepUp(identity websocket.Identity, command string) error {
+	if !g.commandRequiresStepUp(command) || g.freshMFA(identity.AuthTime, identity.AMR) {
+		return nil
+	}
+	return &commandError{http.StatusForbidden, "Step-up authentication required"}
+}
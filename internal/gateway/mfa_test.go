@@ -0,0 +1,205 @@
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/config"
+)
+
+// totpAt returns the TOTP code of secret for the time step offset steps
+// from now
+func totpAt(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("invalid TOTP secret: %v", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(time.Now().Unix()/30+offset))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	truncated := binary.BigEndian.Uint32(sum[sum[len(sum)-1]&0x0f:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", truncated%1000000)
+}
+
+// enrollMFA enables a second factor for a test user and returns its
+// secret. Enrollment uses the previous time step's code, which leaves the
+// current and next steps for the test.
+func (tg *testGateway) enrollMFA(t *testing.T, username string) string {
+	t.Helper()
+
+	token := tg.token(t, username)
+	status, body := tg.do(t, http.MethodPost, "/auth/mfa/enroll", nil, bearer(token))
+	if status != http.StatusOK {
+		t.Fatalf("enroll = %d %v", status, body)
+	}
+	secret, _ := body["secret"].(string)
+
+	status, body = tg.do(t, http.MethodPost, "/auth/mfa/confirm", MFACodeRequest{Code: totpAt(t, secret, -1)}, bearer(token))
+	if status != http.StatusOK {
+		t.Fatalf("confirm = %d %v", status, body)
+	}
+	return secret
+}
+
+func TestMFAStepUpSatisfiesStepUpPaths(t *testing.T) {
+	backend := newTestBackend(t)
+	tg := newTestGateway(t, func(cfg *config.Config) {
+		cfg.APIGatewayConfig.MFA.StepUp.Paths = []string{"/api/v1/trade"}
+		cfg.ServiceDependencies.InternalServices = []config.InternalService{backend.service("buy-sell-engine", "/v1/trade/execute")}
+	})
+	secret := tg.enrollMFA(t, "alice")
+	token := tg.token(t, "alice")
+
+	status, body := tg.do(t, http.MethodPost, "/api/v1/trade/execute/order", nil, bearer(token))
+	if status != http.StatusUnauthorized || body["stepUpRequired"] != true {
+		t.Fatalf("request without step-up = %d %v, want %d", status, body, http.StatusUnauthorized)
+	}
+
+	status, body = tg.do(t, http.MethodPost, "/auth/mfa/verify", MFACodeRequest{Code: totpAt(t, secret, 0)}, bearer(token))
+	if status != http.StatusOK {
+		t.Fatalf("step-up = %d %v", status, body)
+	}
+	steppedUp, _ := body["token"].(string)
+
+	if status, body := tg.do(t, http.MethodPost, "/api/v1/trade/execute/order", nil, bearer(steppedUp)); status != http.StatusOK {
+		t.Errorf("request after step-up = %d %v, want %d", status, body, http.StatusOK)
+	}
+}
+
+func TestMFAFailuresCountTowardsLockout(t *testing.T) {
+	tg := newTestGateway(t, nil)
+	secret := tg.enrollMFA(t, "alice")
+	token := tg.token(t, "alice")
+
+	// The test throttle locks a username after three failures
+	for i := 0; i < 3; i++ {
+		if status, _ := tg.do(t, http.MethodPost, "/auth/mfa/verify", MFACodeRequest{Code: "000000"}, bearer(token)); status != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d = %d, want %d", i+1, status, http.StatusUnauthorized)
+		}
+	}
+
+	// Even the right code is refused while the account is locked
+	if status, _ := tg.do(t, http.MethodPost, "/auth/mfa/verify", MFACodeRequest{Code: totpAt(t, secret, 0)}, bearer(token)); status != http.StatusTooManyRequests {
+		t.Errorf("code during lockout = %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
+
+func TestDisableMFARequiresPasswordOrStepUp(t *testing.T) {
+	tests := []struct {
+		name      string
+		steppedUp bool
+		password  string
+		want      int
+	}{
+		{"no password", false, "", http.StatusUnauthorized},
+		{"wrong password", false, "wrong", http.StatusUnauthorized},
+		{"password", false, testPassword, http.StatusOK},
+		{"recent step-up", true, "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := newTestGateway(t, nil)
+			secret := tg.enrollMFA(t, "alice")
+			token := tg.token(t, "alice")
+			if tt.steppedUp {
+				token = tg.steppedUpToken(t, "alice")
+			}
+
+			request := DisableMFARequest{Code: totpAt(t, secret, 0), Password: tt.password}
+			if status, body := tg.do(t, http.MethodDelete, "/auth/mfa", request, bearer(token)); status != tt.want {
+				t.Errorf("disable MFA = %d %v, want %d", status, body, tt.want)
+			}
+			enabled, _ := tg.mfa.Enabled("u-alice")
+			if enabled != (tt.want != http.StatusOK) {
+				t.Errorf("MFA enabled = %v after a %d response", enabled, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeysOnStepUpPaths(t *testing.T) {
+	tests := []struct {
+		policy   string
+		unsigned int
+		signed   int
+	}{
+		{"exclude", http.StatusForbidden, http.StatusForbidden},
+		{"signed", http.StatusUnauthorized, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			backend := newTestBackend(t)
+			tg := newTestGateway(t, func(cfg *config.Config) {
+				cfg.APIGatewayConfig.APIKeys.ScopePermissions = map[string][]string{"trade": {"trade:execute"}}
+				cfg.APIGatewayConfig.MFA.StepUp.Paths = []string{"/api/v1/trade"}
+				cfg.APIGatewayConfig.MFA.StepUp.APIKeys = tt.policy
+				cfg.ServiceDependencies.InternalServices = []config.InternalService{backend.service("buy-sell-engine", "/v1/trade/execute", "trade:execute")}
+			})
+			key, id := tg.createAPIKey(t, "alice", CreateAPIKeyRequest{Name: "bot", Scopes: []string{"trade"}})
+
+			if status, _ := tg.do(t, http.MethodPost, "/api/v1/trade/execute/order", nil, apiKeyHeader(key)); status != tt.unsigned {
+				t.Errorf("unsigned request = %d, want %d", status, tt.unsigned)
+			}
+
+			secret, err := tg.apiKeys.SigningSecret(id)
+			if err != nil {
+				t.Fatalf("SigningSecret: %v", err)
+			}
+			request := auth.SignedRequest{
+				KeyID:     id,
+				Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+				Nonce:     "n1",
+				Method:    http.MethodPost,
+				Path:      "/api/v1/trade/execute/order",
+			}
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(request.StringToSign()))
+			header := http.Header{
+				headerAPIKeyID:     {id},
+				headerAPITimestamp: {request.Timestamp},
+				headerAPINonce:     {request.Nonce},
+				headerAPISignature: {hex.EncodeToString(mac.Sum(nil))},
+			}
+			if status, _ := tg.do(t, http.MethodPost, "/api/v1/trade/execute/order", nil, header); status != tt.signed {
+				t.Errorf("signed request = %d, want %d", status, tt.signed)
+			}
+		})
+	}
+}
+
+func TestMFAEnrolledOnAnotherReplica(t *testing.T) {
+	broker := &testBroker{}
+	onA := newReplicaTestGateway(t, broker.join("a"), nil)
+	onB := newReplicaTestGateway(t, broker.join("b"), nil)
+	secret := onA.enrollMFA(t, "alice")
+
+	status, body := onB.login(t, "alice", testPassword)
+	if status != http.StatusUnauthorized || body["mfaRequired"] != true {
+		t.Fatalf("password-only login on another replica = %d %v, want %d", status, body, http.StatusUnauthorized)
+	}
+
+	// A code accepted on one replica is refused on the other
+	login := LoginRequest{Username: "alice", Password: testPassword, OTP: totpAt(t, secret, 0)}
+	if status, body := onB.do(t, http.MethodPost, "/auth/login", login, nil); status != http.StatusOK {
+		t.Fatalf("login with a code = %d %v, want %d", status, body, http.StatusOK)
+	}
+	if status, _ := onA.do(t, http.MethodPost, "/auth/login", login, nil); status != http.StatusUnauthorized {
+		t.Errorf("login replaying the code on another replica = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
@@ -1,10 +1,16 @@
 package gateway
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"cryptobot-api-gateway/internal/auth"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -28,7 +34,7 @@ func (g *Gateway) corsMiddleware() gin.HandlerFunc {
 		}
 
 		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Last-Event-ID, X-API-Key, X-API-Key-ID, X-API-Timestamp, X-API-Nonce, X-API-Signature")
 		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
 
 		if c.Request.Method == "OPTIONS" {
@@ -55,7 +61,8 @@ func (g *Gateway) loggingMiddleware() gin.HandlerFunc {
 	})
 }
 
-// authMiddleware validates JWT tokens
+// authMiddleware validates JWT tokens, API keys sent in X-API-Key and
+// requests signed with an API key
 func (g *Gateway) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Skip auth for health checks and options requests
@@ -64,6 +71,15 @@ func (g *Gateway) authMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if c.GetHeader(headerAPISignature) != "" {
+			g.authenticateSignedRequest(c)
+			return
+		}
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			g.authenticateAPIKey(c, apiKey)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
@@ -80,42 +96,156 @@ func (g *Gateway) authMiddleware() gin.HandlerFunc {
 		}
 
 		// Parse and validate JWT token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Verify signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(g.config.APIGatewayConfig.JWTSecretKey), nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := g.parseToken(tokenString)
+		if errors.Is(err, errTokenRevoked) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revoked"})
+			c.Abort()
+			return
+		}
+		if err != nil {
 			g.logger.Warnf("Invalid JWT token: %v", err)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		// Extract claims and set user context
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			c.Set("user_id", claims["user_id"])
-			c.Set("username", claims["username"])
-			c.Set("roles", claims["roles"])
+		c.Set(currentUserKey, claims)
+		if !g.checkStepUp(c, "") {
+			return
+		}
+		g.sessionSeen(c, claims.SessionID)
+		c.Next()
+	}
+}
+
+// currentUserKey is the gin context key holding the caller's claims
+const currentUserKey = "current_user"
+
+// CurrentUser returns the claims of the authenticated caller, or nil if the
+// request did not pass through authMiddleware
+func CurrentUser(c *gin.Context) *auth.Claims {
+	if value, ok := c.Get(currentUserKey); ok {
+		if claims, ok := value.(*auth.Claims); ok {
+			return claims
 		}
+	}
+	return nil
+}
 
-		c.Next()
+// requireRole rejects requests whose token does not carry role.
+// It must run after authMiddleware.
+func (g *Gateway) requireRole(role string) gin.HandlerFunc {
+	return g.requireAny("role:"+role, role)
+}
+
+// errTokenRevoked is returned for tokens revoked by logout or refresh
+var errTokenRevoked = errors.New("token has been revoked")
+
+// parseToken validates a JWT token, including its issuer, audience, time
+// claims and that it has not been revoked, and returns its claims
+func (g *Gateway) parseToken(tokenString string) (*auth.Claims, error) {
+	tokens := g.config.APIGatewayConfig.Tokens
+
+	options := []jwt.ParserOption{
+		jwt.WithLeeway(time.Duration(tokens.ClockSkewSeconds) * time.Second),
+		jwt.WithIssuedAt(),
+	}
+	if tokens.Issuer != "" {
+		options = append(options, jwt.WithIssuer(tokens.Issuer))
+	}
+	if tokens.Audience != "" {
+		options = append(options, jwt.WithAudience(tokens.Audience))
+	}
+
+	claims := &auth.Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, g.keys.Keyfunc, options...)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	// Revocation entries expire with the token, so every token must expire
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("%w: exp claim required", jwt.ErrTokenRequiredClaimMissing)
+	}
+	if tokens.NotBeforeRequired && claims.NotBefore == nil {
+		return nil, fmt.Errorf("%w: nbf claim required", jwt.ErrTokenRequiredClaimMissing)
+	}
+	if g.isInternalAudience(claims.Audience) {
+		return nil, errInternalToken
+	}
+	if claims.UserID == "" {
+		claims.UserID = claims.Subject
+	}
+
+	// A token is revoked on its own (logout) or with its whole session
+	// (refresh token reuse)
+	for _, id := range []string{claims.ID, claims.SessionID} {
+		if id == "" {
+			continue
+		}
+		revoked, err := g.revocations.IsRevoked(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check token revocation: %w", err)
+		}
+		if revoked {
+			return nil, errTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+// generateJWTToken generates a short-lived access token for user within a
+// login session and returns it with its expiry. authTime and amr record
+// when and how the user last authenticated.
+func (g *Gateway) generateJWTToken(user auth.User, sessionID string, authTime time.Time, amr []string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(g.accessTokenLifetime())
+
+	tokens := g.config.APIGatewayConfig.Tokens
+	claims := &auth.Claims{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Roles:     user.Roles,
+		SessionID: sessionID,
+		AuthTime:  jwt.NewNumericDate(authTime),
+		AMR:       amr,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			Subject:   user.ID,
+			Issuer:    tokens.Issuer,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	if tokens.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{tokens.Audience}
 	}
+
+	signed, err := g.keys.Sign(claims)
+	return signed, expiresAt, err
 }
 
-// generateJWTToken generates a JWT token for testing purposes
-func (g *Gateway) generateJWTToken(userID, username string, roles []string) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id":  userID,
-		"username": username,
-		"roles":    roles,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
-		"iat":      time.Now().Unix(),
+// accessTokenLifetime returns how long access tokens are valid
+func (g *Gateway) accessTokenLifetime() time.Duration {
+	return time.Duration(g.config.APIGatewayConfig.Tokens.AccessTokenMinutes) * time.Minute
+}
+
+// revokeToken adds a token to the revocation list until it expires. Tokens
+// issued without a jti cannot be revoked and are ignored.
+func (g *Gateway) revokeToken(claims *auth.Claims) error {
+	if claims.ID == "" {
+		return nil
 	}
+	return g.revocations.Revoke(claims.ID, claims.ExpiresAt.Time)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(g.config.APIGatewayConfig.JWTSecretKey))
+// newTokenID returns a random, unique token identifier
+func newTokenID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestParseTokenValidatesClaims(t *testing.T) {
+	backend := newTestBackend(t)
+	tg := newTestGateway(t, func(cfg *config.Config) {
+		cfg.APIGatewayConfig.Tokens.Issuer = "cryptobot-api-gateway"
+		cfg.APIGatewayConfig.Tokens.Audience = "cryptobot"
+		cfg.APIGatewayConfig.Tokens.ClockSkewSeconds = 30
+		cfg.APIGatewayConfig.Tokens.NotBeforeRequired = true
+		reports := backend.service("report-engine", "/v1/reports")
+		reports.Identity = identityToken
+		cfg.ServiceDependencies.InternalServices = []config.InternalService{reports}
+	})
+	tokens := tg.config.APIGatewayConfig.Tokens
+	now := time.Now()
+
+	// valid returns claims the gateway accepts; each case changes one
+	valid := func() *auth.Claims {
+		return &auth.Claims{
+			UserID: "u-alice",
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        newTokenID(),
+				Subject:   "u-alice",
+				Issuer:    tokens.Issuer,
+				Audience:  jwt.ClaimStrings{tokens.Audience},
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+				NotBefore: jwt.NewNumericDate(now),
+				IssuedAt:  jwt.NewNumericDate(now),
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(claims *auth.Claims)
+		valid  bool
+	}{
+		{"valid", func(*auth.Claims) {}, true},
+		{"wrong issuer", func(c *auth.Claims) { c.Issuer = "someone-else" }, false},
+		{"missing issuer", func(c *auth.Claims) { c.Issuer = "" }, false},
+		{"wrong audience", func(c *auth.Claims) { c.Audience = jwt.ClaimStrings{"other"} }, false},
+		{"internal service audience", func(c *auth.Claims) { c.Audience = jwt.ClaimStrings{tokens.Audience, "report-engine"} }, false},
+		{"missing exp", func(c *auth.Claims) { c.ExpiresAt = nil }, false},
+		{"expired", func(c *auth.Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Hour)) }, false},
+		{"expired within clock skew", func(c *auth.Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-5 * time.Second)) }, true},
+		{"missing nbf", func(c *auth.Claims) { c.NotBefore = nil }, false},
+		{"not yet valid", func(c *auth.Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour)) }, false},
+		{"issued in the future", func(c *auth.Claims) { c.IssuedAt = jwt.NewNumericDate(now.Add(time.Hour)) }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.change(claims)
+			signed, err := tg.keys.Sign(claims)
+			if err != nil {
+				t.Fatalf("failed to sign token: %v", err)
+			}
+
+			_, err = tg.parseToken(signed)
+			if (err == nil) != tt.valid {
+				t.Errorf("parseToken() error = %v, valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestParseTokenFillsUserIDFromSubject(t *testing.T) {
+	tg := newTestGateway(t, nil)
+	tokens := tg.config.APIGatewayConfig.Tokens
+	now := time.Now()
+
+	signed, _ := tg.keys.Sign(&auth.Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "u-alice",
+		Issuer:    tokens.Issuer,
+		Audience:  jwt.ClaimStrings{tokens.Audience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		NotBefore: jwt.NewNumericDate(now),
+	}})
+
+	claims, err := tg.parseToken(signed)
+	if err != nil {
+		t.Fatalf("parseToken: %v", err)
+	}
+	if claims.UserID != "u-alice" {
+		t.Errorf("UserID = %q, want the subject", claims.UserID)
+	}
+}
+
+func TestParseTokenRejectsRevokedTokens(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	signed := tg.token(t, "alice")
+	claims, err := tg.parseToken(signed)
+	if err != nil {
+		t.Fatalf("parseToken: %v", err)
+	}
+	if err := tg.revokeToken(claims); err != nil {
+		t.Fatalf("revokeToken: %v", err)
+	}
+
+	if _, err := tg.parseToken(signed); !errors.Is(err, errTokenRevoked) {
+		t.Errorf("parseToken() error = %v, want %v", err, errTokenRevoked)
+	}
+	if status, _ := tg.do(t, http.MethodGet, "/presence", nil, bearer(signed)); status != http.StatusUnauthorized {
+		t.Errorf("request with a revoked token = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
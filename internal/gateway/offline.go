@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"net/http"
+
+	"cryptobot-api-gateway/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MarkReadRequest lists offline events the client has seen
+type MarkReadRequest struct {
+	IDs []string `json:"ids" binding:"required,min=1"`
+}
+
+// queueOfflineEvent stores a private event for a user who is not connected
+// to any replica, if the channel is configured for offline delivery
+func (g *Gateway) queueOfflineEvent(userID, channel string, body []byte) {
+	if g.offlineStore == nil || !g.isOfflineChannel(channel) || g.wsHub.IsUserOnline(userID) {
+		return
+	}
+
+	if _, err := g.offlineStore.Append(userID, channel, body); err != nil {
+		g.logger.Errorf("Failed to queue offline event for user %s: %v", userID, err)
+	}
+}
+
+// isOfflineChannel reports whether events on channel are queued for offline users
+func (g *Gateway) isOfflineChannel(channel string) bool {
+	for _, c := range g.config.APIGatewayConfig.OfflineQueue.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// offlineBacklog returns a user's unread offline events, oldest first, for
+// delivery when one of their clients connects
+func (g *Gateway) offlineBacklog(identity websocket.Identity) []websocket.BacklogMessage {
+	events, err := g.offlineStore.List(identity.UserID)
+	if err != nil {
+		g.logger.Errorf("Failed to load offline events for user %s: %v", identity.UserID, err)
+		return nil
+	}
+
+	messages := make([]websocket.BacklogMessage, len(events))
+	for i, event := range events {
+		messages[i] = websocket.BacklogMessage{Type: "offline.event", Data: event}
+	}
+	return messages
+}
+
+// markOfflineEventsRead removes read events from a user's offline queue
+func (g *Gateway) markOfflineEventsRead(userID string, request MarkReadRequest) (gin.H, error) {
+	if g.offlineStore == nil {
+		return nil, &commandError{http.StatusServiceUnavailable, "Offline event queue not enabled"}
+	}
+
+	removed, err := g.offlineStore.MarkRead(userID, request.IDs)
+	if err != nil {
+		g.logger.Errorf("Failed to mark offline events read for user %s: %v", userID, err)
+		return nil, &commandError{http.StatusInternalServerError, "Failed to mark events read"}
+	}
+
+	return gin.H{"message": "Events marked read", "marked": removed}, nil
+}
+
+// handleListOfflineEvents returns the caller's unread offline events
+func (g *Gateway) handleListOfflineEvents(c *gin.Context) {
+	if g.offlineStore == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Offline event queue not enabled"})
+		return
+	}
+
+	events, err := g.offlineStore.List(CurrentUser(c).UserID)
+	if err != nil {
+		g.logger.Errorf("Failed to list offline events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// handleMarkOfflineEventsRead marks the caller's offline events as read
+func (g *Gateway) handleMarkOfflineEventsRead(c *gin.Context) {
+	var request MarkReadRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := g.markOfflineEventsRead(CurrentUser(c).UserID, request)
+	if err != nil {
+		respondCommandError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,247 @@
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// oidcLoginTimeout is how long a user has to complete a login at the provider
+const oidcLoginTimeout = 10 * time.Minute
+
+// oidcStateCookie carries a pending login back to the callback, on
+// whichever replica it reaches
+const oidcStateCookie = "oidc_state"
+
+// oidcLogin is a login started at /auth/oidc/login and not yet completed.
+// It is kept in the browser in a signed cookie, which also binds the login
+// to the browser that started it.
+type oidcLogin struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// encodeOIDCLogin returns the signed cookie value for a pending login
+func (g *Gateway) encodeOIDCLogin(login oidcLogin) (string, error) {
+	payload, err := json.Marshal(login)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(g.oidcStateMAC(encoded)), nil
+}
+
+// decodeOIDCLogin verifies a cookie value and returns its pending login
+func (g *Gateway) decodeOIDCLogin(value string) (oidcLogin, bool) {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return oidcLogin{}, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, g.oidcStateMAC(encoded)) {
+		return oidcLogin{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return oidcLogin{}, false
+	}
+
+	var login oidcLogin
+	if err := json.Unmarshal(payload, &login); err != nil {
+		return oidcLogin{}, false
+	}
+	return login, true
+}
+
+// oidcStateMAC signs a pending login with the state secret
+func (g *Gateway) oidcStateMAC(encoded string) []byte {
+	mac := hmac.New(sha256.New, []byte(g.config.APIGatewayConfig.OIDC.StateSecret))
+	mac.Write([]byte("oidc_state:" + encoded))
+	return mac.Sum(nil)
+}
+
+// setupOIDCRoutes adds the OpenID Connect login routes when a provider is
+// configured
+func (g *Gateway) setupOIDCRoutes(router *gin.Engine) {
+	if g.oidc == nil {
+		return
+	}
+
+	oidc := router.Group("/auth/oidc")
+	{
+		oidc.GET("/login", g.handleOIDCLogin)
+		oidc.GET("/callback", g.handleOIDCCallback)
+	}
+}
+
+// handleOIDCLogin starts an authorization code login with PKCE and
+// redirects the browser to the provider
+func (g *Gateway) handleOIDCLogin(c *gin.Context) {
+	login := oidcLogin{
+		State:     auth.NewOIDCState(),
+		Nonce:     auth.NewOIDCState(),
+		Verifier:  auth.NewPKCEVerifier(),
+		ExpiresAt: time.Now().Add(oidcLoginTimeout).Unix(),
+	}
+
+	redirect, err := g.oidc.AuthCodeURL(c.Request.Context(), login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		g.logger.Errorf("Failed to start OIDC login: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+	cookie, err := g.encodeOIDCLogin(login)
+	if err != nil {
+		g.logger.Errorf("Failed to encode OIDC login: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, cookie, int(oidcLoginTimeout.Seconds()), "/auth/oidc", "", g.oidcSecureCookie(), true)
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// handleOIDCCallback completes a login: the code is redeemed, the ID token
+// verified and the user's groups mapped to roles before the gateway issues
+// its own tokens
+func (g *Gateway) handleOIDCCallback(c *gin.Context) {
+	if providerError := c.Query("error"); providerError != "" {
+		g.logger.Warnf("OIDC login failed at provider: %s %s", providerError, c.Query("error_description"))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login failed at identity provider"})
+		return
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", g.oidcSecureCookie(), true)
+	login, ok := g.decodeOIDCLogin(cookie)
+	if state == "" || !ok || login.State != state {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login state"})
+		return
+	}
+	if time.Now().Unix() > login.ExpiresAt {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login expired, please try again"})
+		return
+	}
+
+	claims, err := g.oidc.Exchange(c.Request.Context(), c.Query("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		g.logger.Warnf("OIDC login failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login failed"})
+		return
+	}
+
+	user, groups := g.oidcUser(claims)
+	if len(user.Roles) == 0 {
+		g.logger.WithFields(logrus.Fields{"subject": user.ID, "username": user.Username, "groups": groups}).
+			Warn("OIDC user has no gateway roles")
+		c.JSON(http.StatusForbidden, gin.H{"error": "No gateway roles for this account"})
+		return
+	}
+	if err := g.externalUsers.Put(user); err != nil {
+		g.logger.Errorf("Failed to store OIDC user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+		return
+	}
+
+	// The provider reports when and how the user authenticated there, which
+	// may predate this login if they already had a session
+	authTime := time.Now()
+	if value, ok := claims["auth_time"].(float64); ok {
+		authTime = time.Unix(int64(value), 0)
+	}
+	var amr []string
+	if methods, ok := claims["amr"].([]interface{}); ok {
+		for _, method := range methods {
+			if s, ok := method.(string); ok {
+				amr = append(amr, s)
+			}
+		}
+	}
+
+	refreshToken, session, err := g.refreshTokens.Issue(user.ID, authTime, amr)
+	if err != nil {
+		g.logger.Errorf("Failed to issue refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	g.logger.WithFields(logrus.Fields{"user_id": user.ID, "username": user.Username, "roles": user.Roles}).
+		Info("OIDC login")
+	g.startSession(c, session)
+	g.respondWithTokens(c, user, refreshToken, session)
+}
+
+// oidcUser builds a gateway user from ID token claims, with roles mapped
+// from the user's groups. It also returns the groups.
+func (g *Gateway) oidcUser(claims jwt.MapClaims) (auth.User, []string) {
+	cfg := g.config.APIGatewayConfig.OIDC
+
+	subject, _ := claims["sub"].(string)
+	username, _ := claims[cfg.UsernameClaim].(string)
+	if username == "" {
+		username, _ = claims["email"].(string)
+	}
+	if username == "" {
+		username = subject
+	}
+
+	var groups []string
+	switch value := claims[cfg.GroupsClaim].(type) {
+	case string:
+		groups = []string{value}
+	case []interface{}:
+		for _, group := range value {
+			if s, ok := group.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+
+	granted := make(map[string]bool)
+	for _, group := range groups {
+		for _, role := range cfg.GroupRoles[group] {
+			granted[role] = true
+		}
+	}
+	roles := make([]string, 0, len(granted))
+	for role := range granted {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	return auth.User{ID: "oidc:" + subject, Username: username, Roles: roles}, groups
+}
+
+// oidcSecureCookie reports whether the login cookie needs HTTPS, which it
+// does whenever the callback is served over HTTPS
+func (g *Gateway) oidcSecureCookie() bool {
+	redirect, err := url.Parse(g.config.APIGatewayConfig.OIDC.RedirectURL)
+	return err == nil && strings.EqualFold(redirect.Scheme, "https")
+}
+
+// lookupUser returns a user from the users file or, failing that, one who
+// signed in through the identity provider
+func (g *Gateway) lookupUser(id string) (auth.User, error) {
+	user, err := g.userStore.GetUserByID(id)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		return g.externalUsers.GetUserByID(id)
+	}
+	return user, err
+}
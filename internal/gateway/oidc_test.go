@@ -0,0 +1,254 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is an identity provider that redeems codes for ID tokens
+// with claims registered by the test
+type mockProvider struct {
+	server *httptest.Server
+	keys   *auth.KeySet
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+// mockGrant is a code's ID token claims and its PKCE challenge
+type mockGrant struct {
+	claims    jwt.MapClaims
+	challenge string
+}
+
+// newMockProvider starts an identity provider signing with a fresh RSA key
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "provider.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	keys, err := auth.LoadKeySet("provider", []auth.KeyFile{{ID: "provider", Path: path}})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+
+	provider := &mockProvider{keys: keys, grants: make(map[string]mockGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 provider.server.URL,
+			"authorization_endpoint": provider.server.URL + "/authorize",
+			"token_endpoint":         provider.server.URL + "/token",
+			"jwks_uri":               provider.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(provider.keys.JWKS())
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		provider.mu.Lock()
+		grant, ok := provider.grants[r.FormValue("code")]
+		delete(provider.grants, r.FormValue("code"))
+		provider.mu.Unlock()
+
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != grant.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		idToken, _ := provider.keys.Sign(grant.claims)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+// configure enables login through the provider
+func (p *mockProvider) configure(cfg *config.Config) {
+	oidc := &cfg.APIGatewayConfig.OIDC
+	oidc.Enabled = true
+	oidc.IssuerURL = p.server.URL
+	oidc.ClientID = "cryptobot-gateway"
+	oidc.RedirectURL = "http://gateway.test/auth/oidc/callback"
+	oidc.GroupRoles = map[string][]string{"cryptobot-traders": {"user", "trader"}}
+}
+
+// oidcLoginStart is a login started at the gateway, as the browser holds it
+type oidcLoginStart struct {
+	cookie *http.Cookie
+	query  url.Values
+}
+
+// startOIDCLogin starts a login at the test gateway without following the
+// redirect to the provider
+func (tg *testGateway) startOIDCLogin(t *testing.T) oidcLoginStart {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(tg.server.URL + "/auth/oidc/login")
+	if err != nil {
+		t.Fatalf("failed to start login: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		t.Fatalf("login = %d, want %d", response.StatusCode, http.StatusFound)
+	}
+
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return oidcLoginStart{cookie: cookie, query: location.Query()}
+		}
+	}
+	t.Fatal("login did not set the state cookie")
+	return oidcLoginStart{}
+}
+
+// grant registers a code for a started login whose ID token has the
+// provider's default claims changed by change
+func (p *mockProvider) grant(login oidcLoginStart, change func(claims jwt.MapClaims)) string {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                p.server.URL,
+		"aud":                "cryptobot-gateway",
+		"sub":                "operator-1",
+		"preferred_username": "operator",
+		"groups":             []string{"cryptobot-traders"},
+		"nonce":              login.query.Get("nonce"),
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+	}
+	if change != nil {
+		change(claims)
+	}
+
+	code := auth.NewOIDCState()
+	p.mu.Lock()
+	p.grants[code] = mockGrant{claims: claims, challenge: login.query.Get("code_challenge")}
+	p.mu.Unlock()
+	return code
+}
+
+// callback completes a login at the test gateway
+func (tg *testGateway) callback(t *testing.T, state, code string, cookie *http.Cookie) (int, map[string]interface{}) {
+	t.Helper()
+
+	header := http.Header{}
+	if cookie != nil {
+		header.Set("Cookie", cookie.String())
+	}
+	return tg.do(t, http.MethodGet, "/auth/oidc/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil, header)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newMockProvider(t)
+	tg := newTestGateway(t, provider.configure)
+
+	tests := []struct {
+		name   string
+		change func(claims jwt.MapClaims)
+		want   int
+	}{
+		{"valid", nil, http.StatusOK},
+		{"empty subject", func(c jwt.MapClaims) { c["sub"] = "" }, http.StatusUnauthorized},
+		{"nonce of another login", func(c jwt.MapClaims) { c["nonce"] = "other" }, http.StatusUnauthorized},
+		{"token for another client", func(c jwt.MapClaims) { c["aud"] = "other-client" }, http.StatusUnauthorized},
+		{"no gateway roles", func(c jwt.MapClaims) { c["groups"] = []string{"finance"} }, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login := tg.startOIDCLogin(t)
+			code := provider.grant(login, tt.change)
+
+			status, body := tg.callback(t, login.query.Get("state"), code, login.cookie)
+			if status != tt.want {
+				t.Fatalf("callback = %d %v, want %d", status, body, tt.want)
+			}
+			if status != http.StatusOK {
+				return
+			}
+
+			token, _ := body["token"].(string)
+			if status, _ := tg.do(t, http.MethodGet, "/presence", nil, bearer(token)); status != http.StatusOK {
+				t.Errorf("request with the issued token = %d, want %d", status, http.StatusOK)
+			}
+			if _, err := tg.lookupUser("oidc:operator-1"); err != nil {
+				t.Errorf("provider user not stored: %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsForgedState(t *testing.T) {
+	provider := newMockProvider(t)
+	tg := newTestGateway(t, provider.configure)
+
+	login := tg.startOIDCLogin(t)
+	other := tg.startOIDCLogin(t)
+	forged := *login.cookie
+	encoded, _, _ := strings.Cut(forged.Value, ".")
+	forged.Value = encoded + ".AAAA"
+
+	tests := []struct {
+		name   string
+		state  string
+		cookie *http.Cookie
+	}{
+		{"missing cookie", login.query.Get("state"), nil},
+		{"state of another login", other.query.Get("state"), login.cookie},
+		{"forged signature", login.query.Get("state"), &forged},
+		{"missing state", "", login.cookie},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := provider.grant(login, nil)
+			if status, body := tg.callback(t, tt.state, code, tt.cookie); status != http.StatusBadRequest {
+				t.Errorf("callback = %d %v, want %d", status, body, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackOnAnotherReplica(t *testing.T) {
+	provider := newMockProvider(t)
+	started := newTestGateway(t, provider.configure)
+	completed := newTestGateway(t, provider.configure)
+
+	login := started.startOIDCLogin(t)
+	code := provider.grant(login, nil)
+
+	if status, body := completed.callback(t, login.query.Get("state"), code, login.cookie); status != http.StatusOK {
+		t.Errorf("callback on another replica = %d %v, want %d", status, body, http.StatusOK)
+	}
+}
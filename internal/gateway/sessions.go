@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+
+	"cryptobot-api-gateway/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// sessionRevokedReason is sent to WebSocket clients of a revoked session
+const sessionRevokedReason = "Session revoked"
+
+// SessionInfo is a login session as listed to users. Current marks the
+// session of the token making the request.
+type SessionInfo struct {
+	auth.Session
+	Current bool `json:"current"`
+}
+
+// setupSessionRoutes adds routes for users to manage their login sessions
+func (g *Gateway) setupSessionRoutes(router *gin.Engine) {
+	sessions := router.Group("/auth/sessions")
+	{
+		sessions.Use(g.authMiddleware(), g.rejectAPIKeys())
+		sessions.GET("", g.handleListOwnSessions)
+		sessions.DELETE("/:id", g.handleRevokeOwnSession)
+	}
+}
+
+// startSession records the session a login started, with the device it
+// came from
+func (g *Gateway) startSession(c *gin.Context, token auth.RefreshToken) {
+	if _, err := g.sessions.Start(token, c.Request.UserAgent(), c.ClientIP()); err != nil {
+		g.logger.Errorf("Failed to record session for user %s: %v", token.UserID, err)
+	}
+}
+
+// sessionSeen records that the caller's session was used
+func (g *Gateway) sessionSeen(c *gin.Context, sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	if err := g.sessions.Seen(sessionID, c.ClientIP()); err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+		g.logger.Errorf("Failed to record use of session %s: %v", sessionID, err)
+	}
+}
+
+// handleListOwnSessions lists the caller's login sessions
+func (g *Gateway) handleListOwnSessions(c *gin.Context) {
+	claims := CurrentUser(c)
+
+	sessions, err := g.sessions.List(claims.UserID)
+	if err != nil {
+		g.logger.Errorf("Failed to list sessions for user %s: %v", claims.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessionInfos(sessions, claims.SessionID)})
+}
+
+// handleRevokeOwnSession revokes one of the caller's login sessions.
+// Sessions of other users are reported as not found.
+func (g *Gateway) handleRevokeOwnSession(c *gin.Context) {
+	claims := CurrentUser(c)
+	sessionID := c.Param("id")
+
+	session, err := g.sessions.Get(sessionID)
+	if errors.Is(err, auth.ErrSessionNotFound) || (err == nil && session.UserID != claims.UserID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Errorf("Failed to load session %s: %v", sessionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	if err := g.revokeSession(sessionID); err != nil {
+		g.logger.Errorf("Failed to revoke session %s: %v", sessionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	g.logger.WithFields(logrus.Fields{"user_id": claims.UserID, "session_id": sessionID}).Info("Session revoked")
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked", "sessionId": sessionID})
+}
+
+// handleListLoginSessions lists every user's login sessions, optionally
+// filtered by ?userId=
+func (g *Gateway) handleListLoginSessions(c *gin.Context) {
+	userID := c.Query("userId")
+
+	sessions, err := g.sessions.List(userID)
+	if err != nil {
+		g.logger.Errorf("Failed to list sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	g.auditAdminAction(c, "list_login_sessions", logrus.Fields{"filter_user_id": userID, "count": len(sessions)})
+	c.JSON(http.StatusOK, gin.H{"sessions": sessionInfos(sessions, CurrentUser(c).SessionID)})
+}
+
+// handleRevokeLoginSession revokes any user's login session
+func (g *Gateway) handleRevokeLoginSession(c *gin.Context) {
+	sessionID := c.Param("id")
+
+	session, err := g.sessions.Get(sessionID)
+	if errors.Is(err, auth.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Errorf("Failed to load session %s: %v", sessionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	if err := g.revokeSession(sessionID); err != nil {
+		g.logger.Errorf("Failed to revoke session %s: %v", sessionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	g.auditAdminAction(c, "revoke_login_session", logrus.Fields{"session_id": sessionID, "target_user_id": session.UserID})
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked", "sessionId": sessionID})
+}
+
+// sessionInfos marks the current session in a list of sessions
+func sessionInfos(sessions []auth.Session, currentID string) []SessionInfo {
+	infos := make([]SessionInfo, len(sessions))
+	for i, session := range sessions {
+		infos[i] = SessionInfo{Session: session, Current: session.ID == currentID}
+	}
+	return infos
+}
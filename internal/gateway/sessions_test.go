@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/auth"
+	"cryptobot-api-gateway/internal/websocket"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+// testBroker delivers replication messages synchronously to every replica
+// that joined it, as the message broker would
+type testBroker struct {
+	replicas []*auth.Replication
+}
+
+// join adds a replica to the broker
+func (b *testBroker) join(replicaID string) *auth.Replication {
+	replication := auth.NewReplication(replicaID, b.publish)
+	b.replicas = append(b.replicas, replication)
+	return replication
+}
+
+// publish delivers a message to every replica, including its sender
+func (b *testBroker) publish(message auth.ReplicationMessage) error {
+	for _, replica := range b.replicas {
+		if err := replica.Apply(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expectClose fails the test unless conn is closed with code within a second
+func expectClose(t *testing.T, conn *gorilla.Conn, code int) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		closeErr, ok := err.(*gorilla.CloseError)
+		if !ok {
+			t.Fatalf("connection ended without a close frame: %v", err)
+		}
+		if closeErr.Code != code {
+			t.Errorf("got close code %d, want %d", closeErr.Code, code)
+		}
+		return
+	}
+}
+
+func TestRevokeOwnSession(t *testing.T) {
+	tg := newTestGateway(t, nil)
+
+	_, laptop := tg.login(t, "alice", testPassword)
+	_, phone := tg.login(t, "alice", testPassword)
+	laptopToken, _ := laptop["token"].(string)
+	phoneToken, _ := phone["token"].(string)
+	laptopClaims, _ := tg.parseToken(laptopToken)
+
+	status, body := tg.do(t, http.MethodGet, "/auth/sessions", nil, bearer(phoneToken))
+	if sessions, _ := body["sessions"].([]interface{}); status != http.StatusOK || len(sessions) != 2 {
+		t.Fatalf("list sessions = %d %v, want two sessions", status, body)
+	}
+
+	// Other users cannot see or end the session
+	if status, _ := tg.do(t, http.MethodDelete, "/auth/sessions/"+laptopClaims.SessionID, nil, bearer(tg.token(t, "bob"))); status != http.StatusNotFound {
+		t.Errorf("revoke by another user = %d, want %d", status, http.StatusNotFound)
+	}
+
+	conn := tg.dial(t, "/ws?token="+laptopToken, nil)
+	eventually(t, func() bool { return tg.wsHub.GetClientCount() == 1 })
+
+	if status, _ := tg.do(t, http.MethodDelete, "/auth/sessions/"+laptopClaims.SessionID, nil, bearer(phoneToken)); status != http.StatusOK {
+		t.Fatalf("revoke = %d, want %d", status, http.StatusOK)
+	}
+	expectClose(t, conn, websocket.CloseSessionRevoked)
+	if status, _ := tg.do(t, http.MethodGet, "/auth/sessions", nil, bearer(laptopToken)); status != http.StatusUnauthorized {
+		t.Errorf("token of the revoked session = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRevokeSessionOnAnotherReplica(t *testing.T) {
+	broker := &testBroker{}
+	onA := newReplicaTestGateway(t, broker.join("a"), nil)
+	onB := newReplicaTestGateway(t, broker.join("b"), nil)
+
+	_, body := onA.login(t, "alice", testPassword)
+	token, _ := body["token"].(string)
+	claims, _ := onA.parseToken(token)
+
+	// The session started on one replica is listed on the other
+	status, body := onB.do(t, http.MethodGet, "/auth/sessions", nil, bearer(token))
+	if sessions, _ := body["sessions"].([]interface{}); status != http.StatusOK || len(sessions) != 1 {
+		t.Fatalf("list sessions on another replica = %d %v, want one session", status, body)
+	}
+
+	conn := onB.dial(t, "/ws?token="+token, nil)
+	eventually(t, func() bool { return onB.wsHub.GetClientCount() == 1 })
+
+	// Revoking it on one replica closes its clients on the other
+	admin := bearer(onA.token(t, "root"))
+	if status, _ := onA.do(t, http.MethodDelete, "/admin/login-sessions/"+claims.SessionID, nil, admin); status != http.StatusOK {
+		t.Fatalf("revoke = %d, want %d", status, http.StatusOK)
+	}
+	expectClose(t, conn, websocket.CloseSessionRevoked)
+
+	if _, err := onB.sessions.Get(claims.SessionID); err == nil {
+		t.Error("revoked session still known to the other replica")
+	}
+}
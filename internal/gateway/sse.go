@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cryptobot-api-gateway/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleEventStream streams hub messages as Server-Sent Events. Channels are
+// selected with ?channels=a,b (or repeated ?channel=), and a reconnecting
+// client resumes from its Last-Event-ID.
+func (g *Gateway) handleEventStream(c *gin.Context) {
+	identity := identityFromClaims(CurrentUser(c))
+
+	var channels []string
+	for _, value := range append(c.QueryArray("channels"), c.QueryArray("channel")...) {
+		for _, channel := range strings.Split(value, ",") {
+			if channel = strings.TrimSpace(channel); channel != "" {
+				channels = append(channels, channel)
+			}
+		}
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	resumeFrom, _ := strconv.ParseUint(lastEventID, 10, 64)
+
+	sub, err := g.wsHub.Subscribe(identity, c.ClientIP(), channels, resumeFrom)
+	if err != nil {
+		var rejection *websocket.RejectionError
+		if errors.As(err, &rejection) {
+			c.Header("Retry-After", "1")
+			c.JSON(rejection.Status, gin.H{"error": rejection.Message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer sub.Close()
+
+	// The server write timeout would otherwise cut the stream short
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		g.logger.Warnf("Failed to clear write deadline for event stream: %v", err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(time.Duration(g.config.APIGatewayConfig.WebSocket.SSEKeepAliveSeconds) * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-sub.Ready():
+			for _, event := range sub.Events() {
+				fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Channel, event.Data)
+			}
+			c.Writer.Flush()
+
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keepalive\n\n")
+			c.Writer.Flush()
+
+		case <-sub.Done():
+			writeStreamClose(c, sub)
+			return
+
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// writeStreamClose flushes events still queued for a subscriber the hub has
+// dropped. On shutdown the client is also sent a close event and told how
+// long to wait before reconnecting.
+func writeStreamClose(c *gin.Context, sub *websocket.Subscription) {
+	for _, event := range sub.Events() {
+		fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Channel, event.Data)
+	}
+
+	if shutdown, ok := sub.ShutdownReason(); ok {
+		data, _ := json.Marshal(shutdown)
+		fmt.Fprintf(c.Writer, "retry: %d\nevent: close\ndata: %s\n\n", shutdown.RetryAfterMs, data)
+	}
+	c.Writer.Flush()
+}
@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/config"
+)
+
+// sseEvent is an event or comment read from an event stream
+type sseEvent struct {
+	ID      string
+	Event   string
+	Data    string
+	Comment string
+}
+
+// stream opens an event stream and returns a reader over its body. The
+// stream is closed when the test ends or after five seconds.
+func (tg *testGateway) stream(t *testing.T, query string, header http.Header) *bufio.Reader {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tg.server.URL+"/events/stream?"+query, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to open event stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("event stream = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// nextSSE reads the next event or comment from an event stream
+func nextSSE(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return event
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			event.Comment = value
+		case "id":
+			event.ID = value
+		case "event":
+			event.Event = value
+		case "data":
+			event.Data = value
+		}
+	}
+}
+
+func TestEventStreamResumesFromLastEventID(t *testing.T) {
+	tg := newTestGateway(t, nil)
+	header := bearer(tg.token(t, "alice"))
+
+	first := tg.stream(t, "channels=pnl.update", header)
+	eventually(t, func() bool { return tg.wsHub.GetClientCount() == 1 })
+	tg.wsHub.BroadcastToUser("u-alice", "pnl.update", map[string]interface{}{"pnl": 1})
+	tg.wsHub.BroadcastToUser("u-alice", "pnl.update", map[string]interface{}{"pnl": 2})
+
+	nextSSE(t, first)
+	seen := nextSSE(t, first)
+	if seen.Event != "pnl.update" || !strings.Contains(seen.Data, `"pnl":2`) {
+		t.Fatalf("second event = %+v, want the pnl 2 update", seen)
+	}
+
+	// An event published while the client is away is replayed on resume,
+	// and nothing it already saw is sent again
+	tg.wsHub.BroadcastToUser("u-alice", "pnl.update", map[string]interface{}{"pnl": 3})
+	resumed := tg.stream(t, "channels=pnl.update", http.Header{
+		"Authorization": header["Authorization"],
+		"Last-Event-Id": {seen.ID},
+	})
+
+	replayed := nextSSE(t, resumed)
+	if replayed.Event != "pnl.update" || !strings.Contains(replayed.Data, `"pnl":3`) {
+		t.Errorf("replayed event = %+v, want the pnl 3 update", replayed)
+	}
+	seenID, _ := strconv.ParseUint(seen.ID, 10, 64)
+	if replayedID, _ := strconv.ParseUint(replayed.ID, 10, 64); replayedID <= seenID {
+		t.Errorf("replayed event id %s is not after %s", replayed.ID, seen.ID)
+	}
+}
+
+func TestEventStreamSendsKeepAlive(t *testing.T) {
+	tg := newTestGateway(t, func(cfg *config.Config) {
+		cfg.APIGatewayConfig.WebSocket.SSEKeepAliveSeconds = 1
+	})
+
+	reader := tg.stream(t, "channels=pnl.update", bearer(tg.token(t, "alice")))
+	if event := nextSSE(t, reader); event.Comment != "keepalive" {
+		t.Errorf("first message on an idle stream = %+v, want a keepalive comment", event)
+	}
+}
@@ -0,0 +1,348 @@
+package offline
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired events are dropped for every user
+const sweepInterval = 5 * time.Minute
+
+// compactMinRecords is the log size below which it is never compacted
+const compactMinRecords = 1000
+
+// logRecord is one line of the store's log: an event appended to a user's
+// queue or the IDs of events the user read
+type logRecord struct {
+	UserID string   `json:"userId"`
+	Event  *Event   `json:"event,omitempty"`
+	Read   []string `json:"read,omitempty"`
+}
+
+// FileStore is an embedded Store that keeps all queues in memory and
+// records changes in an append-only log of JSON lines. Each user's queue
+// holds at most maxPerUser events, dropping the oldest, and events older
+// than ttl are discarded. Expired events are swept for all users
+// periodically, and the log is rewritten with only the live events once
+// most of its records are obsolete.
+type FileStore struct {
+	path       string
+	maxPerUser int
+	ttl        time.Duration
+	queues     map[string][]Event
+	log        *os.File
+	records    int
+	events     int
+	mu         sync.Mutex
+	done       chan struct{}
+}
+
+// NewFileStore opens or creates the store at path
+func NewFileStore(path string, maxPerUser int, ttl time.Duration) (*FileStore, error) {
+	store := &FileStore{
+		path:       path,
+		maxPerUser: maxPerUser,
+		ttl:        ttl,
+		queues:     make(map[string][]Event),
+		done:       make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create offline store directory: %w", err)
+	}
+	if err := store.replay(); err != nil {
+		return nil, err
+	}
+
+	// Start from a compact log so records of expired events are not kept
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+
+	go store.sweepPeriodically()
+	return store, nil
+}
+
+// Append stores an event for a user unless it is already queued
+func (s *FileStore) Append(userID, eventType string, data json.RawMessage) (Event, error) {
+	event := Event{
+		ID:        EventID(userID, eventType, data),
+		Type:      eventType,
+		Data:      data,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if queued, ok := s.find(userID, event.ID); ok {
+		return queued, nil
+	}
+	s.apply(logRecord{UserID: userID, Event: &event})
+	return event, s.write(logRecord{UserID: userID, Event: &event})
+}
+
+// Put stores an event queued by another replica, keeping its ID and
+// timestamp, and reports whether it was added. Expired events and events
+// that are already queued are ignored.
+func (s *FileStore) Put(userID string, event Event) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.find(userID, event.ID); ok || event.CreatedAt.Before(time.Now().Add(-s.ttl)) {
+		return false, nil
+	}
+	s.apply(logRecord{UserID: userID, Event: &event})
+	return true, s.write(logRecord{UserID: userID, Event: &event})
+}
+
+// All returns every user's unread events, oldest first
+func (s *FileStore) All() map[string][]Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := make(map[string][]Event, len(s.queues))
+	for userID := range s.queues {
+		queue := s.prune(userID)
+		if len(queue) == 0 {
+			continue
+		}
+		all[userID] = append([]Event(nil), queue...)
+	}
+	return all
+}
+
+// List returns a user's unread events, oldest first
+func (s *FileStore) List(userID string) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.prune(userID)
+	events := make([]Event, len(queue))
+	copy(events, queue)
+	return events, nil
+}
+
+// MarkRead removes the given events from a user's queue
+func (s *FileStore) MarkRead(userID string, ids []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := s.apply(logRecord{UserID: userID, Read: ids})
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.write(logRecord{UserID: userID, Read: ids})
+}
+
+// Close stops the sweeper and compacts the log
+func (s *FileStore) Close() error {
+	close(s.done)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.compact()
+	if closeErr := s.log.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close offline store: %w", closeErr)
+	}
+	return err
+}
+
+// sweepPeriodically drops expired events until the store is closed
+func (s *FileStore) sweepPeriodically() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.sweep()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sweep drops expired events for every user and compacts the log if most
+// of it is obsolete
+func (s *FileStore) sweep() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for userID := range s.queues {
+		s.prune(userID)
+	}
+	return s.compactIfNeeded()
+}
+
+// apply adds a record's event to its user's queue or removes the events it
+// marks read, and returns how many events were removed. The caller must
+// hold s.mu.
+func (s *FileStore) apply(record logRecord) int {
+	queue := s.prune(record.UserID)
+	before := len(queue)
+
+	if record.Event != nil {
+		queue = append(queue, *record.Event)
+		if overflow := len(queue) - s.maxPerUser; overflow > 0 {
+			queue = queue[overflow:]
+		}
+	}
+
+	if len(record.Read) > 0 {
+		read := make(map[string]bool, len(record.Read))
+		for _, id := range record.Read {
+			read[id] = true
+		}
+
+		remaining := queue[:0]
+		for _, event := range queue {
+			if !read[event.ID] {
+				remaining = append(remaining, event)
+			}
+		}
+		queue = remaining
+	}
+
+	s.setQueue(record.UserID, queue)
+	if record.Event != nil {
+		return before + 1 - len(queue)
+	}
+	return before - len(queue)
+}
+
+// find returns a queued event of a user. The caller must hold s.mu.
+func (s *FileStore) find(userID, id string) (Event, bool) {
+	for _, event := range s.prune(userID) {
+		if event.ID == id {
+			return event, true
+		}
+	}
+	return Event{}, false
+}
+
+// prune drops expired events from a user's queue and returns it. The
+// caller must hold s.mu.
+func (s *FileStore) prune(userID string) []Event {
+	queue := s.queues[userID]
+	cutoff := time.Now().Add(-s.ttl)
+
+	i := 0
+	for i < len(queue) && queue[i].CreatedAt.Before(cutoff) {
+		i++
+	}
+	queue = queue[i:]
+
+	s.setQueue(userID, queue)
+	return s.queues[userID]
+}
+
+// setQueue replaces a user's queue, keeping the event count in step. The
+// caller must hold s.mu.
+func (s *FileStore) setQueue(userID string, queue []Event) {
+	s.events += len(queue) - len(s.queues[userID])
+	if len(queue) == 0 {
+		delete(s.queues, userID)
+		return
+	}
+	s.queues[userID] = queue
+}
+
+// replay rebuilds the queues from the log. A record cut short by a crash
+// ends the log; it is dropped by the compaction that follows.
+func (s *FileStore) replay() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read offline store: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var record logRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode offline store: %w", err)
+		}
+		s.apply(record)
+	}
+}
+
+// write appends a record to the log and compacts it if most of it is
+// obsolete. The caller must hold s.mu.
+func (s *FileStore) write(record logRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode offline event: %w", err)
+	}
+	if _, err := s.log.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write offline store: %w", err)
+	}
+	s.records++
+	return s.compactIfNeeded()
+}
+
+// compactIfNeeded compacts the log once it holds more than twice as many
+// records as there are live events. The caller must hold s.mu.
+func (s *FileStore) compactIfNeeded() error {
+	if s.records < compactMinRecords || s.records <= 2*s.events {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact atomically replaces the log with one record per live event and
+// reopens it for appending. The caller must hold s.mu.
+func (s *FileStore) compact() error {
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write offline store: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	records := 0
+	for userID, queue := range s.queues {
+		for i := range queue {
+			if err := encoder.Encode(logRecord{UserID: userID, Event: &queue[i]}); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to encode offline store: %w", err)
+			}
+			records++
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write offline store: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write offline store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace offline store: %w", err)
+	}
+
+	if s.log != nil {
+		s.log.Close()
+	}
+	if s.log, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+		return fmt.Errorf("failed to open offline store: %w", err)
+	}
+	s.records = records
+	return nil
+}
@@ -0,0 +1,224 @@
+package offline
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openStore opens a store at path
+func openStore(t *testing.T, path string, maxPerUser int, ttl time.Duration) *FileStore {
+	t.Helper()
+
+	store, err := NewFileStore(path, maxPerUser, ttl)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	return store
+}
+
+// logLines returns the number of records in the log at path
+func logLines(t *testing.T, path string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestFileStoreReplaysLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.log")
+	store := openStore(t, path, 10, time.Hour)
+	defer store.Close()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		event, err := store.Append("u-alice", "trades.filled", json.RawMessage(fmt.Sprintf(`{"n":%d}`, i)))
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		ids = append(ids, event.ID)
+	}
+	if _, err := store.Append("u-bob", "trades.filled", json.RawMessage(`{}`)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if removed, err := store.MarkRead("u-alice", ids[:1]); err != nil || removed != 1 {
+		t.Fatalf("MarkRead = %d, %v, want 1", removed, err)
+	}
+
+	// Every change is appended, not rewritten
+	if lines := logLines(t, path); lines != 5 {
+		t.Fatalf("log has %d records, want 5", lines)
+	}
+
+	// Simulate a crash without Close
+	reopened := openStore(t, path, 10, time.Hour)
+	defer reopened.Close()
+
+	events, err := reopened.List("u-alice")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(events) != 2 || events[0].ID != ids[1] || events[1].ID != ids[2] {
+		t.Fatalf("replayed events %+v, want %v", events, ids[1:])
+	}
+	if events, _ := reopened.List("u-bob"); len(events) != 1 {
+		t.Fatalf("replayed %d events for u-bob, want 1", len(events))
+	}
+}
+
+func TestFileStoreDropsTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.log")
+	store := openStore(t, path, 10, time.Hour)
+	defer store.Close()
+	if _, err := store.Append("u-alice", "trades.filled", json.RawMessage(`{}`)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	file.WriteString(`{"userId":"u-alice","event":{"id":"x`)
+	file.Close()
+
+	reopened := openStore(t, path, 10, time.Hour)
+	defer reopened.Close()
+	if events, _ := reopened.List("u-alice"); len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+}
+
+func TestFileStoreCapsQueuePerUser(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "offline.log"), 2, time.Hour)
+	defer store.Close()
+
+	var last []string
+	for i := 0; i < 4; i++ {
+		event, err := store.Append("u-alice", "trades.filled", json.RawMessage(fmt.Sprintf(`{"n":%d}`, i)))
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		last = append(last, event.ID)
+	}
+
+	events, _ := store.List("u-alice")
+	if len(events) != 2 || events[0].ID != last[2] || events[1].ID != last[3] {
+		t.Fatalf("got %+v, want the two newest events", events)
+	}
+}
+
+func TestFileStoreSweepsExpiredEventsForAllUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.log")
+	store := openStore(t, path, 10, time.Hour)
+	defer store.Close()
+
+	for _, userID := range []string{"u-alice", "u-bob", "u-carol"} {
+		if _, err := store.Append(userID, "trades.filled", json.RawMessage(`{}`)); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	// Age the events past the TTL without any of their users returning
+	store.mu.Lock()
+	for userID, queue := range store.queues {
+		for i := range queue {
+			queue[i].CreatedAt = queue[i].CreatedAt.Add(-2 * time.Hour)
+		}
+		store.queues[userID] = queue
+	}
+	store.mu.Unlock()
+
+	if err := store.sweep(); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.queues) != 0 || store.events != 0 {
+		t.Fatalf("sweep left %d queues and %d events", len(store.queues), store.events)
+	}
+}
+
+func TestFileStoreCompactsObsoleteRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.log")
+	store := openStore(t, path, 10, time.Hour)
+	defer store.Close()
+
+	for i := 0; i < compactMinRecords; i++ {
+		event, err := store.Append("u-alice", "trades.filled", json.RawMessage(`{}`))
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if _, err := store.MarkRead("u-alice", []string{event.ID}); err != nil {
+			t.Fatalf("MarkRead: %v", err)
+		}
+	}
+	if _, err := store.Append("u-bob", "trades.filled", json.RawMessage(`{}`)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	if lines := logLines(t, path); lines >= compactMinRecords {
+		t.Fatalf("log has %d records after compaction, want fewer than %d", lines, compactMinRecords)
+	}
+	if events, _ := store.List("u-bob"); len(events) != 1 {
+		t.Fatalf("compaction lost events: got %d for u-bob, want 1", len(events))
+	}
+}
+
+func TestFileStoreAppendIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.log")
+	store := openStore(t, path, 10, time.Hour)
+	defer store.Close()
+
+	first, err := store.Append("u-alice", "trades.filled", json.RawMessage(`{"tradeId":"t-1"}`))
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	second, err := store.Append("u-alice", "trades.filled", json.RawMessage(`{"tradeId":"t-1"}`))
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	if second.ID != first.ID || !second.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("second append = %+v, want the queued %+v", second, first)
+	}
+	if events, _ := store.List("u-alice"); len(events) != 1 {
+		t.Errorf("got %d events, want 1", len(events))
+	}
+	if lines := logLines(t, path); lines != 1 {
+		t.Errorf("log has %d records, want 1", lines)
+	}
+	if id := EventID("u-bob", "trades.filled", json.RawMessage(`{"tradeId":"t-1"}`)); id == first.ID {
+		t.Error("the same event for another user has the same ID")
+	}
+}
+
+func TestFileStorePutKeepsEventsFromOtherReplicas(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "offline.log"), 10, time.Hour)
+	defer store.Close()
+
+	createdAt := time.Now().Add(-time.Minute).UTC()
+	event := Event{ID: "e-1", Type: "trades.filled", Data: json.RawMessage(`{}`), CreatedAt: createdAt}
+	expired := Event{ID: "e-2", Type: "trades.filled", Data: json.RawMessage(`{}`), CreatedAt: createdAt.Add(-2 * time.Hour)}
+
+	for _, tt := range []struct {
+		event Event
+		want  bool
+	}{{event, true}, {event, false}, {expired, false}} {
+		if added, err := store.Put("u-alice", tt.event); err != nil || added != tt.want {
+			t.Errorf("Put(%s) = %v, %v; want %v", tt.event.ID, added, err, tt.want)
+		}
+	}
+
+	all := store.All()
+	if events := all["u-alice"]; len(events) != 1 || events[0].ID != "e-1" || !events[0].CreatedAt.Equal(createdAt) {
+		t.Errorf("All() = %+v, want e-1 with its original timestamp", all)
+	}
+}
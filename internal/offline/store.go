@@ -0,0 +1,37 @@
+package offline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Event is a private user event that was not delivered because the user
+// was offline
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// Store persists undelivered events per user until they are read
+type Store interface {
+	// Append stores an event for a user, assigning its ID and timestamp.
+	// Appending an event that is still queued has no effect.
+	Append(userID, eventType string, data json.RawMessage) (Event, error)
+	// List returns a user's unread events, oldest first
+	List(userID string) ([]Event, error)
+	// MarkRead removes events from a user's queue and returns how many were removed
+	MarkRead(userID string, ids []string) (int, error)
+	// Close releases the store
+	Close() error
+}
+
+// EventID returns the ID of an event for a user. It is derived from the
+// event so replicas that each receive the same broker event agree on it.
+func EventID(userID, eventType string, data json.RawMessage) string {
+	sum := sha256.Sum256([]byte(userID + "\x00" + eventType + "\x00" + string(data)))
+	return hex.EncodeToString(sum[:8])
+}
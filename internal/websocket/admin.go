@@ -0,0 +1,205 @@
+package websocket
+
+import (
+	"sort"
+	"time"
+)
+
+// Admin operations carried out on every replica
+const (
+	AdminListSessions     = "list_sessions"
+	AdminDisconnectUser   = "disconnect_user"
+	AdminDisconnectClient = "disconnect_client"
+)
+
+// AdminMessage is an admin request sent to every replica, or one replica's
+// reply to it. Replies carry the request's id and the replica's sessions or
+// the number of clients it disconnected.
+type AdminMessage struct {
+	RequestID    string        `json:"requestId"`
+	ReplicaID    string        `json:"replicaId"`
+	Op           string        `json:"op"`
+	Reply        bool          `json:"reply,omitempty"`
+	UserID       string        `json:"userId,omitempty"`
+	ClientID     string        `json:"clientId,omitempty"`
+	Reason       string        `json:"reason,omitempty"`
+	Sessions     []ClientStats `json:"sessions,omitempty"`
+	Disconnected int           `json:"disconnected,omitempty"`
+}
+
+// AdminPublisher sends an admin message to the other replicas
+type AdminPublisher func(message AdminMessage) error
+
+// ClusterResult is the outcome of an admin request across the replicas.
+// Replicas lists the replicas that answered, this one included, and Missing
+// those known from presence that did not answer in time.
+type ClusterResult struct {
+	Sessions     []ClientStats `json:"sessions"`
+	Disconnected int           `json:"disconnected"`
+	Replicas     []string      `json:"replicas"`
+	Missing      []string      `json:"missingReplicas"`
+}
+
+// SetAdminPublisher registers the function that sends admin requests and
+// replies to the other replicas
+func (h *Hub) SetAdminPublisher(publisher AdminPublisher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.adminPublisher = publisher
+}
+
+// ListSessions returns the sessions of every replica
+func (h *Hub) ListSessions() ClusterResult {
+	return h.clusterRequest(AdminMessage{Op: AdminListSessions})
+}
+
+// DisconnectUserEverywhere closes every client of a user on every replica
+func (h *Hub) DisconnectUserEverywhere(userID, reason string) ClusterResult {
+	return h.clusterRequest(AdminMessage{Op: AdminDisconnectUser, UserID: userID, Reason: reason})
+}
+
+// DisconnectClientEverywhere closes the client with the given id on
+// whichever replica holds it
+func (h *Hub) DisconnectClientEverywhere(id, reason string) ClusterResult {
+	return h.clusterRequest(AdminMessage{Op: AdminDisconnectClient, ClientID: id, Reason: reason})
+}
+
+// ApplyAdminMessage handles a message received from another replica,
+// carrying out requests and answering them, and passing replies to the
+// request waiting for them
+func (h *Hub) ApplyAdminMessage(message AdminMessage) {
+	if message.ReplicaID == h.config.Presence.ReplicaID {
+		return
+	}
+
+	if message.Reply {
+		h.adminMu.Lock()
+		replies, ok := h.adminRequests[message.RequestID]
+		h.adminMu.Unlock()
+		if ok {
+			select {
+			case replies <- message:
+			default:
+				h.logger.Warnf("Dropping admin reply from replica %s", message.ReplicaID)
+			}
+		}
+		return
+	}
+
+	reply := h.applyAdmin(message)
+	if err := h.publishAdmin(reply); err != nil {
+		h.logger.Warnf("Failed to answer admin request %s: %v", message.RequestID, err)
+	}
+}
+
+// applyAdmin carries out an admin request on this replica and returns the
+// reply to it
+func (h *Hub) applyAdmin(request AdminMessage) AdminMessage {
+	reply := AdminMessage{
+		RequestID: request.RequestID,
+		ReplicaID: h.config.Presence.ReplicaID,
+		Op:        request.Op,
+		Reply:     true,
+	}
+
+	switch request.Op {
+	case AdminListSessions:
+		reply.Sessions = h.GetClientStats()
+	case AdminDisconnectUser:
+		reply.Disconnected = h.DisconnectUser(request.UserID, request.Reason)
+	case AdminDisconnectClient:
+		if h.DisconnectClient(request.ClientID, request.Reason) {
+			reply.Disconnected = 1
+		}
+	default:
+		h.logger.Warnf("Unknown admin request %q from replica %s", request.Op, request.ReplicaID)
+	}
+	return reply
+}
+
+// clusterRequest carries out an admin request here and on every other
+// replica known from presence, waiting up to the admin timeout for their
+// replies
+func (h *Hub) clusterRequest(request AdminMessage) ClusterResult {
+	request.RequestID = newClientID()
+	request.ReplicaID = h.config.Presence.ReplicaID
+
+	result := ClusterResult{Sessions: make([]ClientStats, 0), Missing: make([]string, 0)}
+	result.add(h.applyAdmin(request))
+
+	// A client is held by one replica only
+	if request.Op == AdminDisconnectClient && result.Disconnected > 0 {
+		return result
+	}
+
+	h.mu.RLock()
+	publisher := h.adminPublisher
+	h.mu.RUnlock()
+
+	expected := make(map[string]bool)
+	for _, replicaID := range h.presence.replicas() {
+		expected[replicaID] = true
+	}
+	if publisher == nil || len(expected) == 0 {
+		return result
+	}
+
+	replies := make(chan AdminMessage, len(expected)+8)
+	h.adminMu.Lock()
+	h.adminRequests[request.RequestID] = replies
+	h.adminMu.Unlock()
+	defer func() {
+		h.adminMu.Lock()
+		delete(h.adminRequests, request.RequestID)
+		h.adminMu.Unlock()
+	}()
+
+	if err := publisher(request); err != nil {
+		h.logger.Warnf("Failed to send admin request to other replicas: %v", err)
+		result.missing(expected)
+		return result
+	}
+
+	timeout := time.NewTimer(time.Duration(h.config.Admin.TimeoutMs) * time.Millisecond)
+	defer timeout.Stop()
+	for len(expected) > 0 {
+		select {
+		case reply := <-replies:
+			delete(expected, reply.ReplicaID)
+			result.add(reply)
+		case <-timeout.C:
+			result.missing(expected)
+			return result
+		}
+	}
+	return result
+}
+
+// publishAdmin sends a message through the registered publisher. Without
+// one the hub is the only replica and nothing is sent.
+func (h *Hub) publishAdmin(message AdminMessage) error {
+	h.mu.RLock()
+	publisher := h.adminPublisher
+	h.mu.RUnlock()
+
+	if publisher == nil {
+		return nil
+	}
+	return publisher(message)
+}
+
+// add merges a replica's reply into the result
+func (r *ClusterResult) add(reply AdminMessage) {
+	r.Sessions = append(r.Sessions, reply.Sessions...)
+	r.Disconnected += reply.Disconnected
+	r.Replicas = append(r.Replicas, reply.ReplicaID)
+	sort.Strings(r.Replicas)
+}
+
+// missing records the replicas that did not answer
+func (r *ClusterResult) missing(replicas map[string]bool) {
+	for replicaID := range replicas {
+		r.Missing = append(r.Missing, replicaID)
+	}
+	sort.Strings(r.Missing)
+}
@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/config"
+)
+
+func TestAdminRequestsReachEveryReplica(t *testing.T) {
+	a, b := newReplicaTestHubs(t)
+	onA := a.dial(t, "user=u-alice", nil)
+	onB := b.dial(t, "user=u-alice", nil)
+	bob := b.dial(t, "user=u-bob", nil)
+	waitFor(t, func() bool {
+		presence, _ := presenceOf(a, "u-alice")
+		return presence.Connections == 2
+	})
+
+	// Sessions on both replicas are listed, labelled with their replica
+	result := a.ListSessions()
+	if len(result.Sessions) != 3 || !reflect.DeepEqual(result.Replicas, []string{"a", "b"}) || len(result.Missing) != 0 {
+		t.Fatalf("ListSessions() = %+v, want three sessions from a and b", result)
+	}
+	var bobID string
+	for _, session := range result.Sessions {
+		if session.UserID == "u-bob" {
+			bobID = session.ID
+			if session.ReplicaID != "b" {
+				t.Errorf("u-bob listed on replica %q, want b", session.ReplicaID)
+			}
+		}
+	}
+
+	// Disconnecting a user closes their clients on every replica
+	if result := a.DisconnectUserEverywhere("u-alice", "bye"); result.Disconnected != 2 {
+		t.Errorf("DisconnectUserEverywhere() = %+v, want 2 disconnected", result)
+	}
+	expectCloseCode(t, onA, CloseAdminDisconnect, time.Second)
+	expectCloseCode(t, onB, CloseAdminDisconnect, time.Second)
+
+	// A session is found on the replica that holds it
+	if result := a.DisconnectClientEverywhere(bobID, "bye"); result.Disconnected != 1 {
+		t.Errorf("DisconnectClientEverywhere() = %+v, want 1 disconnected", result)
+	}
+	expectCloseCode(t, bob, CloseAdminDisconnect, time.Second)
+}
+
+func TestAdminRequestReportsSilentReplica(t *testing.T) {
+	th := newTestHub(t, func(cfg *config.WebSocketConfig) {
+		cfg.Presence.ReplicaID = "a"
+		cfg.Admin.TimeoutMs = 50
+	})
+	th.SetAdminPublisher(func(AdminMessage) error { return nil })
+	th.ApplyRemotePresence(PresenceSnapshot{ReplicaID: "c", Timestamp: time.Now()})
+	th.dial(t, "user=u-alice", nil)
+
+	result := th.ListSessions()
+	if len(result.Sessions) != 1 || !reflect.DeepEqual(result.Missing, []string{"c"}) {
+		t.Errorf("ListSessions() = %+v, want the local session and c missing", result)
+	}
+}
@@ -0,0 +1,52 @@
+package websocket
+
+// BacklogMessage is a message sent to a single client as soon as it connects
+type BacklogMessage struct {
+	Type string
+	Data interface{}
+}
+
+// BacklogProvider returns the messages an authenticated client should
+// receive on connect, such as events queued while its user was offline
+type BacklogProvider func(identity Identity) []BacklogMessage
+
+// SetBacklogProvider registers the provider consulted for new clients
+func (h *Hub) SetBacklogProvider(provider BacklogProvider) {
+	h.backlogProvider = provider
+}
+
+// backlogFor returns the backlog of a client connecting as identity
+func (h *Hub) backlogFor(identity Identity) []*outbound {
+	if h.backlogProvider == nil || !identity.Authenticated {
+		return nil
+	}
+
+	var messages []*outbound
+	for _, backlog := range h.backlogProvider(identity) {
+		message, err := h.newOutbound(backlog.Type, backlog.Data)
+		if err != nil {
+			h.logger.Errorf("Failed to marshal backlog message: %v", err)
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// queueBacklog queues a backlog for a client that is not attached yet, so
+// it is sent ahead of any live message. The client's queue is sized to hold
+// the backlog, so it is only refused once the client is gone; the provider
+// keeps the messages and sends them again on the next connection.
+func (c *Client) queueBacklog(backlog []*outbound) {
+	for i, message := range backlog {
+		if !c.enqueue(message) {
+			c.hub.logger.Warnf("Failed to queue %d backlog messages for user %s, they are sent on the next connection", len(backlog)-i, c.identity.UserID)
+			return
+		}
+	}
+}
+
+// IsUserOnline reports whether a user is connected to any gateway replica
+func (h *Hub) IsUserOnline(userID string) bool {
+	return h.presence.online(userID)
+}
@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"fmt"
+	"testing"
+
+	"cryptobot-api-gateway/internal/config"
+)
+
+func TestBacklogArrivesBeforeLiveEvents(t *testing.T) {
+	th := newTestHub(t, func(cfg *config.WebSocketConfig) {
+		cfg.SendBufferSize = 2
+	})
+
+	// The backlog is larger than the send buffer, and an event fires while
+	// it is being loaded
+	th.SetBacklogProvider(func(identity Identity) []BacklogMessage {
+		th.BroadcastToUser(identity.UserID, "pnl.update", map[string]interface{}{"seq": "during"})
+
+		backlog := make([]BacklogMessage, 5)
+		for i := range backlog {
+			backlog[i] = BacklogMessage{Type: "offline.event", Data: map[string]interface{}{"seq": i}}
+		}
+		return backlog
+	})
+
+	conn := th.dial(t, "user=u-alice", nil)
+	th.BroadcastToUser("u-alice", "pnl.update", map[string]interface{}{"seq": "after"})
+
+	var order []string
+	for len(order) == 0 || order[len(order)-1] != "pnl.update:after" {
+		message := conn.next(t)
+		if message["type"] == PresenceOnline {
+			continue
+		}
+		data, _ := message["data"].(map[string]interface{})
+		order = append(order, fmt.Sprintf("%v:%v", message["type"], data["seq"]))
+	}
+
+	want := []string{"offline.event:0", "offline.event:1", "offline.event:2", "offline.event:3", "offline.event:4"}
+	if len(order) < len(want) {
+		t.Fatalf("got %v, want the backlog %v first", order, want)
+	}
+	for i, message := range want {
+		if order[i] != message {
+			t.Fatalf("got %v, want the backlog %v first", order, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Identity describes the user behind a WebSocket connection. A zero
+// ExpiresAt means the session does not expire. AuthTime and AMR record
+// when and how the user last authenticated.
+type Identity struct {
+	UserID        string
+	Username      string
+	SessionID     string
+	Roles         []string
+	Authenticated bool
+	ExpiresAt     time.Time
+	AuthTime      time.Time
+	AMR           []string
+}
+
+// CommandHandler executes a command sent by a client and returns the payload
+// of the ack reply. A returned error is sent back as an error reply.
+type CommandHandler func(identity Identity, command string, payload json.RawMessage) (interface{}, error)
+
+// inboundMessage is a message received from a client. ID is chosen by the
+// client and echoed in the reply so it can correlate responses.
+type inboundMessage struct {
+	Type string          `json:"type"`
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// SetCommandHandler registers the handler for the named inbound commands
+func (h *Hub) SetCommandHandler(handler CommandHandler, commands ...string) {
+	h.commandHandler = handler
+	h.commands = make(map[string]bool, len(commands))
+	for _, command := range commands {
+		h.commands[command] = true
+	}
+}
+
+// handleMessage processes a message read from the connection. It returns
+// false when the client should be disconnected.
+func (c *Client) handleMessage(raw []byte) bool {
+	if !c.inboundLimiter.allow() {
+		return c.violation("", "", ErrorRateLimited, "Too many messages")
+	}
+
+	msg, err := decodeInbound(raw)
+	if err != nil {
+		return c.violation("", "", ErrorInvalidMessage, err.Error())
+	}
+
+	if limit := c.hub.readLimitFor(msg.Type); len(raw) > limit {
+		return c.violation(msg.ID, msg.Type, ErrorMessageTooLarge, fmt.Sprintf("Message exceeds %d bytes", limit))
+	}
+
+	switch msg.Type {
+	case "ping":
+		return c.reply("pong", msg.ID, nil)
+	case "reauth":
+		return c.handleReauth(msg)
+	case "presence":
+		return c.handlePresence(msg)
+	}
+
+	if c.hub.commandHandler == nil || !c.hub.isCommand(msg.Type) {
+		return c.violation(msg.ID, msg.Type, ErrorUnknownType, "Unknown message type: "+msg.Type)
+	}
+
+	result, err := c.hub.commandHandler(c.identity, msg.Type, msg.Data)
+	if err != nil {
+		code := ErrorCommandFailed
+		var coded CodedError
+		if errors.As(err, &coded) {
+			code = coded.Code()
+		}
+		return c.replyError(msg.ID, msg.Type, code, err.Error())
+	}
+	return c.reply("ack", msg.ID, result)
+}
+
+// reply queues a response to the client, echoing the request id when set
+func (c *Client) reply(messageType, id string, data interface{}) bool {
+	message := map[string]interface{}{
+		"type": messageType,
+	}
+	if id != "" {
+		message["id"] = id
+	}
+	if data != nil {
+		message["data"] = data
+	}
+
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		c.hub.logger.Errorf("Failed to marshal reply: %v", err)
+		return true
+	}
+	return c.enqueue(&outbound{channel: messageType, data: jsonData})
+}
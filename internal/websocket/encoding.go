@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encodings negotiated through the Sec-WebSocket-Protocol header
+const (
+	// ProtocolJSON sends newline-joined JSON messages in text frames (default)
+	ProtocolJSON = "json"
+	// ProtocolJSONBatch sends queued messages as a JSON array in one text frame
+	ProtocolJSONBatch = "json-batch"
+	// ProtocolMsgPack sends each message as a MessagePack binary frame
+	ProtocolMsgPack = "msgpack"
+)
+
+// subprotocols lists the supported encodings
+var subprotocols = map[string]bool{ProtocolJSON: true, ProtocolJSONBatch: true, ProtocolMsgPack: true}
+
+// selectSubprotocol returns the first of the client's requested protocols
+// that the hub supports, honoring the client's order of preference, or ""
+// when none is supported
+func selectSubprotocol(requested []string) string {
+	for _, protocol := range requested {
+		if subprotocols[protocol] {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// msgpackData returns the MessagePack encoding of the message, computing it
+// once and sharing it between all clients that receive the message
+func (m *outbound) msgpackData() ([]byte, error) {
+	m.packOnce.Do(func() {
+		var message interface{}
+		if m.packErr = json.Unmarshal(m.data, &message); m.packErr != nil {
+			return
+		}
+		m.packed, m.packErr = msgpack.Marshal(message)
+	})
+	return m.packed, m.packErr
+}
+
+// writeMessages writes a batch of queued messages using the client's encoding
+func (c *Client) writeMessages(messages []*outbound) error {
+	switch c.protocol {
+	case ProtocolMsgPack:
+		for _, message := range messages {
+			data, err := message.msgpackData()
+			if err != nil {
+				c.hub.logger.Errorf("Failed to encode MessagePack message: %v", err)
+				continue
+			}
+			if err := c.writeFrame(websocket.BinaryMessage, data); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case ProtocolJSONBatch:
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, message := range messages {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(message.data)
+		}
+		buf.WriteByte(']')
+		return c.writeFrame(websocket.TextMessage, buf.Bytes())
+
+	default:
+		var buf bytes.Buffer
+		for i, message := range messages {
+			if i > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.Write(message.data)
+		}
+		return c.writeFrame(websocket.TextMessage, buf.Bytes())
+	}
+}
+
+// writeFrame writes a single frame, compressing it only when it is at least
+// as large as the configured threshold
+func (c *Client) writeFrame(messageType int, data []byte) error {
+	if c.hub.config.Compression.Enabled {
+		c.conn.EnableWriteCompression(len(data) >= c.hub.config.Compression.Threshold)
+	}
+	return c.conn.WriteMessage(messageType, data)
+}
@@ -0,0 +1,187 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/config"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestSubprotocolNegotiation(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []string
+		want      string
+	}{
+		{"none requested", nil, ""},
+		{"client prefers msgpack", []string{ProtocolMsgPack, ProtocolJSON}, ProtocolMsgPack},
+		{"client prefers json-batch", []string{ProtocolJSONBatch, ProtocolMsgPack}, ProtocolJSONBatch},
+		{"unsupported protocols are skipped", []string{"cbor", ProtocolJSONBatch}, ProtocolJSONBatch},
+		{"nothing supported", []string{"cbor"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newTestHub(t, nil)
+			conn := th.dial(t, "", &websocket.Dialer{Subprotocols: tt.requested})
+			if got := conn.Subprotocol(); got != tt.want {
+				t.Errorf("negotiated %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// readMessages reads frames until it has n messages, checking that every
+// frame has the expected type, and decodes each message with split
+func readMessages(t *testing.T, conn *websocket.Conn, n, frameType int, split func([]byte) []map[string]interface{}) []map[string]interface{} {
+	t.Helper()
+
+	var messages []map[string]interface{}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for len(messages) < n {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read after %d messages: %v", len(messages), err)
+		}
+		if messageType != frameType {
+			t.Fatalf("frame type %d, want %d", messageType, frameType)
+		}
+		messages = append(messages, split(data)...)
+	}
+	return messages
+}
+
+func TestMessageFraming(t *testing.T) {
+	tests := []struct {
+		protocol  string
+		frameType int
+		split     func(t *testing.T, data []byte) []map[string]interface{}
+	}{
+		{ProtocolJSON, websocket.TextMessage, func(t *testing.T, data []byte) []map[string]interface{} {
+			var messages []map[string]interface{}
+			for _, line := range strings.Split(string(data), "\n") {
+				var message map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &message); err != nil {
+					t.Fatalf("line %q is not JSON: %v", line, err)
+				}
+				messages = append(messages, message)
+			}
+			return messages
+		}},
+		{ProtocolJSONBatch, websocket.TextMessage, func(t *testing.T, data []byte) []map[string]interface{} {
+			var messages []map[string]interface{}
+			if err := json.Unmarshal(data, &messages); err != nil {
+				t.Fatalf("frame %q is not a JSON array: %v", data, err)
+			}
+			return messages
+		}},
+		{ProtocolMsgPack, websocket.BinaryMessage, func(t *testing.T, data []byte) []map[string]interface{} {
+			var message map[string]interface{}
+			if err := msgpack.Unmarshal(data, &message); err != nil {
+				t.Fatalf("frame is not MessagePack: %v", err)
+			}
+			return []map[string]interface{}{message}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			th := newTestHub(t, nil)
+			conn := th.dial(t, "user=u-alice", &websocket.Dialer{Subprotocols: []string{tt.protocol}})
+			split := func(data []byte) []map[string]interface{} { return tt.split(t, data) }
+
+			// The client's own presence comes first, in a frame of its own
+			if joined := readMessages(t, conn.Conn, 1, tt.frameType, split); joined[0]["type"] != "presence.online" {
+				t.Fatalf("first message = %v, want presence.online", joined[0])
+			}
+
+			th.BroadcastToUser("u-alice", "ticker", map[string]interface{}{"symbol": "BTC"})
+			th.BroadcastToUser("u-alice", "ticker", map[string]interface{}{"symbol": "ETH"})
+
+			messages := readMessages(t, conn.Conn, 2, tt.frameType, split)
+			var symbols []interface{}
+			for _, message := range messages {
+				if message["type"] != "ticker" {
+					t.Errorf("message type = %v, want ticker", message["type"])
+				}
+				data, _ := message["data"].(map[string]interface{})
+				symbols = append(symbols, data["symbol"])
+			}
+			if want := []interface{}{"BTC", "ETH"}; !reflect.DeepEqual(symbols, want) {
+				t.Errorf("symbols = %v, want %v", symbols, want)
+			}
+		})
+	}
+}
+
+// countingConn counts the bytes read from the network
+type countingConn struct {
+	net.Conn
+	read *int64
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	payload := strings.Repeat("a", 4096)
+
+	tests := []struct {
+		name       string
+		level      int
+		threshold  int
+		compressed bool
+	}{
+		{"above the threshold", 1, 512, true},
+		{"below the threshold", 1, 1 << 20, false},
+		{"level 0 is honored", 0, 512, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newTestHub(t, func(cfg *config.WebSocketConfig) {
+				level := tt.level
+				cfg.Compression = config.CompressionConfig{Enabled: true, Level: &level, Threshold: tt.threshold}
+			})
+
+			var read int64
+			dialer := &websocket.Dialer{
+				EnableCompression: true,
+				NetDial: func(network, addr string) (net.Conn, error) {
+					conn, err := net.Dial(network, addr)
+					return countingConn{Conn: conn, read: &read}, err
+				},
+			}
+			conn := th.dial(t, "user=u-alice", dialer)
+
+			// Skip the client's own presence before counting
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, _, err := conn.ReadMessage(); err != nil {
+				t.Fatalf("read presence: %v", err)
+			}
+			before := atomic.LoadInt64(&read)
+
+			th.BroadcastToUser("u-alice", "ticker", payload)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, data, err := conn.ReadMessage(); err != nil || !strings.Contains(string(data), payload) {
+				t.Fatalf("read = %.40q, %v; want the payload", data, err)
+			}
+
+			wire := atomic.LoadInt64(&read) - before
+			if compressed := wire < int64(len(payload)); compressed != tt.compressed {
+				t.Errorf("%d bytes on the wire for a %d byte payload, compressed = %v, want %v", wire, len(payload), compressed, tt.compressed)
+			}
+		})
+	}
+}
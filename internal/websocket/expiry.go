@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// CloseTokenExpired is the close code sent when a session's token expires
+// without being refreshed
+const CloseTokenExpired = 4001
+
+// TokenValidator validates a bearer token and returns the identity it carries
+type TokenValidator func(token string) (Identity, error)
+
+// reauthRequest is the payload of a reauth message
+type reauthRequest struct {
+	Token string `json:"token"`
+}
+
+// SetTokenValidator registers the validator used for in-band reauth
+func (h *Hub) SetTokenValidator(validator TokenValidator) {
+	h.tokenValidator = validator
+}
+
+// expiresAt returns the expiry of the client's current token
+func (c *Client) expiresAt() time.Time {
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
+	return c.identity.ExpiresAt
+}
+
+// watchExpiry warns the client shortly before its token expires and closes
+// the connection once it has. A successful reauth restarts the countdown.
+func (c *Client) watchExpiry() {
+	warning := time.Duration(c.hub.config.TokenExpiryWarningSeconds) * time.Second
+
+	for {
+		expiresAt := c.expiresAt()
+		if expiresAt.IsZero() {
+			return
+		}
+
+		warnTimer := time.NewTimer(time.Until(expiresAt.Add(-warning)))
+		expiryTimer := time.NewTimer(time.Until(expiresAt))
+
+		select {
+		case <-warnTimer.C:
+			c.reply("token_expiring", "", map[string]interface{}{"expiresAt": expiresAt})
+			select {
+			case <-expiryTimer.C:
+				c.hub.expire(c)
+				return
+			case <-c.reauthed:
+			case <-c.queue.done:
+				expiryTimer.Stop()
+				return
+			}
+
+		case <-expiryTimer.C:
+			c.hub.expire(c)
+			return
+
+		case <-c.reauthed:
+
+		case <-c.queue.done:
+			warnTimer.Stop()
+			expiryTimer.Stop()
+			return
+		}
+
+		warnTimer.Stop()
+		expiryTimer.Stop()
+	}
+}
+
+// expire removes a client whose token has expired
+func (h *Hub) expire(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.detach(client) {
+		h.logger.Infof("Closing WebSocket client %s: token expired", client.identity.UserID)
+	}
+	client.queue.closeWith(CloseTokenExpired, "Token expired")
+}
+
+// handleReauth replaces the client's token with a fresh one for the same user
+func (c *Client) handleReauth(msg inboundMessage) bool {
+	var request reauthRequest
+	if err := json.Unmarshal(msg.Data, &request); err != nil || request.Token == "" {
+		return c.replyError(msg.ID, "reauth", ErrorInvalidPayload, "Token required")
+	}
+
+	if c.hub.tokenValidator == nil || !c.identity.Authenticated {
+		return c.replyError(msg.ID, "reauth", ErrorUnauthorized, "Reauthentication not supported")
+	}
+
+	identity, err := c.hub.tokenValidator(request.Token)
+	if err != nil {
+		return c.replyError(msg.ID, "reauth", ErrorUnauthorized, "Invalid token")
+	}
+	if identity.UserID != c.identity.UserID {
+		return c.replyError(msg.ID, "reauth", ErrorUnauthorized, "Token belongs to a different user")
+	}
+
+	c.hub.mu.Lock()
+	c.identity = identity
+	c.hub.mu.Unlock()
+
+	select {
+	case c.reauthed <- struct{}{}:
+	default:
+	}
+
+	return c.reply("ack", msg.ID, map[string]interface{}{"command": "reauth", "expiresAt": identity.ExpiresAt})
+}
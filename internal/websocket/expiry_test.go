@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// newExpiryTestHub starts a hub that warns a second before expiry and
+// accepts reauth tokens of the form "<user>|<ttl>"
+func newExpiryTestHub(t *testing.T) *testHub {
+	t.Helper()
+
+	th := newTestHub(t, func(cfg *config.WebSocketConfig) {
+		cfg.TokenExpiryWarningSeconds = 1
+	})
+	th.SetTokenValidator(func(token string) (Identity, error) {
+		user, ttl, _ := strings.Cut(token, "|")
+		lifetime, err := time.ParseDuration(ttl)
+		if err != nil {
+			return Identity{}, errors.New("invalid token")
+		}
+		return Identity{UserID: user, Authenticated: true, ExpiresAt: time.Now().Add(lifetime)}, nil
+	})
+	return th
+}
+
+func TestExpiringTokenIsWarnedThenClosed(t *testing.T) {
+	th := newExpiryTestHub(t)
+	conn := th.dial(t, "user=u-alice&ttl=1500ms", nil)
+
+	warning := conn.readType(t, "token_expiring")
+	if data, _ := warning["data"].(map[string]interface{}); data["expiresAt"] == nil {
+		t.Errorf("warning = %v, want the expiry time", warning)
+	}
+
+	// A token for someone else does not extend the session
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"reauth","id":"r1","data":{"token":"u-bob|1h"}}`)); err != nil {
+		t.Fatalf("send reauth: %v", err)
+	}
+	if reply := conn.readType(t, "error"); reply["id"] != "r1" {
+		t.Errorf("reauth reply = %v, want an error for r1", reply)
+	}
+
+	closeErr := expectCloseCode(t, conn, CloseTokenExpired, 2*time.Second)
+	if closeErr.Text != "Token expired" {
+		t.Errorf("close reason = %q, want %q", closeErr.Text, "Token expired")
+	}
+	waitFor(t, func() bool { return th.GetClientCount() == 0 })
+}
+
+func TestReauthExtendsSession(t *testing.T) {
+	th := newExpiryTestHub(t)
+	conn := th.dial(t, "user=u-alice&ttl=1500ms", nil)
+	conn.readType(t, "token_expiring")
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"reauth","id":"r1","data":{"token":"u-alice|1h"}}`)); err != nil {
+		t.Fatalf("send reauth: %v", err)
+	}
+	ack := conn.readType(t, "ack")
+	if data, _ := ack["data"].(map[string]interface{}); ack["id"] != "r1" || data["command"] != "reauth" {
+		t.Fatalf("reauth reply = %v, want an ack for r1", ack)
+	}
+
+	// The connection outlives the original token
+	conn.SetReadDeadline(time.Now().Add(1500 * time.Millisecond))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var netErr interface{ Timeout() bool }
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Fatalf("connection ended after reauth: %v", err)
+		}
+		break
+	}
+	if count := th.GetClientCount(); count != 1 {
+		t.Errorf("client count = %d after the original expiry, want 1", count)
+	}
+}
@@ -1,176 +1,396 @@
 package websocket
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"cryptobot-api-gateway/internal/config"
+
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// In production, implement proper origin checking
-		return true
-	},
-}
+// CloseAdminDisconnect is the close code sent when an administrator ends a session
+const CloseAdminDisconnect = 4000
+
+// CloseSessionRevoked is the close code sent when the client's login session
+// is revoked
+const CloseSessionRevoked = 4002
+
+// Transports a client can be connected over
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+)
 
 // Client represents a websocket client
 type Client struct {
-	hub    *Hub
-	conn   *websocket.Conn
-	send   chan []byte
-	userID string
+	id          string
+	hub         *Hub
+	conn        *websocket.Conn
+	queue       *sendQueue
+	identity    Identity
+	ip          string
+	protocol    string
+	channels    map[string]bool
+	connectedAt time.Time
+	reauthed    chan struct{}
+
+	// Inbound abuse tracking, only touched by readPump
+	inboundLimiter       *tokenBucket
+	violations           int
+	violationWindowStart time.Time
+}
+
+// ClientStats describes a connected client and the state of its queue
+type ClientStats struct {
+	ID            string    `json:"id"`
+	ReplicaID     string    `json:"replicaId"`
+	UserID        string    `json:"userId"`
+	Username      string    `json:"username,omitempty"`
+	IP            string    `json:"ip"`
+	Transport     string    `json:"transport"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+	Channels      []string  `json:"channels"`
+	QueueDepth    int       `json:"queueDepth"`
+	QueueCapacity int       `json:"queueCapacity"`
+	Sent          uint64    `json:"sent"`
+	Dropped       uint64    `json:"dropped"`
+	Conflated     uint64    `json:"conflated"`
 }
 
 // Hub maintains the set of active clients and broadcasts messages to the clients
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
+	broadcast  chan *outbound
 	unregister chan *Client
+	upgrader   websocket.Upgrader
+	config     config.WebSocketConfig
 	logger     *logrus.Entry
 	mu         sync.RWMutex
+
+	commandHandler  CommandHandler
+	commands        map[string]bool
+	tokenValidator  TokenValidator
+	backlogProvider BacklogProvider
+
+	presence          *presenceTracker
+	presencePublisher PresencePublisher
+
+	adminPublisher AdminPublisher
+	adminRequests  map[string]chan AdminMessage // request id -> replies
+	adminMu        sync.Mutex
+
+	// events carries hub-generated messages such as presence changes
+	events chan *outbound
+	done   chan struct{}
+
+	// shuttingDown stops admission; writers tracks running write pumps
+	shuttingDown bool
+	writers      sync.WaitGroup
+
+	// history holds recent messages so stream subscribers can resume
+	history     []*outbound
+	nextEventID uint64
+
+	upgradeLimiter *tokenBucket
+	rejections     map[string]uint64
+	rejectionsMu   sync.Mutex
+
+	slowDisconnects uint64
 }
 
 // NewHub creates a new WebSocket hub
-func NewHub(logger *logrus.Entry) *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+func NewHub(cfg config.WebSocketConfig, logger *logrus.Entry) *Hub {
+	h := &Hub{
+		clients:       make(map[*Client]bool),
+		broadcast:     make(chan *outbound),
+		unregister:    make(chan *Client),
+		events:        make(chan *outbound, 1024),
+		done:          make(chan struct{}),
+		presence:      newPresenceTracker(cfg.Presence.ReplicaID),
+		adminRequests: make(map[string]chan AdminMessage),
+		upgrader: websocket.Upgrader{
+			EnableCompression: cfg.Compression.Enabled,
+			CheckOrigin: func(r *http.Request) bool {
+				// In production, implement proper origin checking
+				return true
+			},
+		},
+		config:     cfg,
 		logger:     logger,
+		rejections: make(map[string]uint64),
 	}
+
+	if cfg.Limits.UpgradesPerSecond > 0 {
+		h.upgradeLimiter = newTokenBucket(cfg.Limits.UpgradesPerSecond, cfg.Limits.UpgradeBurst)
+	}
+
+	return h
 }
 
 // Run starts the hub
 func (h *Hub) Run() {
+	go h.runPresence()
+
 	for {
 		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			h.mu.Unlock()
-			h.logger.Infof("WebSocket client connected. Total clients: %d", len(h.clients))
-
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+			if h.detach(client) {
+				client.queue.close()
 			}
 			h.mu.Unlock()
-			h.logger.Infof("WebSocket client disconnected. Total clients: %d", len(h.clients))
+			h.logger.Infof("WebSocket client disconnected. Total clients: %d", h.GetClientCount())
 
 		case message := <-h.broadcast:
-			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
-			h.mu.RUnlock()
+			h.publish(message)
+
+		case message := <-h.events:
+			h.publish(message)
+
+		case <-h.done:
+			return
 		}
 	}
 }
 
-// HandleWebSocket handles websocket requests from the peer
-func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+// publish records a message and delivers it to every interested client
+func (h *Hub) publish(message *outbound) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.record(message)
+	for client := range h.clients {
+		h.deliver(client, message)
+	}
+}
+
+// attach adds a client to the hub. The caller must hold h.mu for writing.
+func (h *Hub) attach(client *Client) {
+	h.clients[client] = true
+	h.trackJoin(client)
+}
+
+// detach removes a client from the hub and reports whether it was present.
+// The caller must hold h.mu for writing.
+func (h *Hub) detach(client *Client) bool {
+	if _, ok := h.clients[client]; !ok {
+		return false
+	}
+	delete(h.clients, client)
+	h.trackLeave(client)
+	return true
+}
+
+// record assigns the message an event id and keeps it for replay. The
+// caller must hold h.mu for writing.
+func (h *Hub) record(message *outbound) {
+	h.nextEventID++
+	message.id = h.nextEventID
+
+	h.history = append(h.history, message)
+	if overflow := len(h.history) - h.config.ReplayBufferSize; overflow > 0 {
+		h.history = append(h.history[:0:0], h.history[overflow:]...)
+	}
+}
+
+// deliver queues a message for a client, disconnecting it if the channel
+// policy says so. The caller must hold h.mu for writing.
+func (h *Hub) deliver(client *Client, message *outbound) bool {
+	if !client.wants(message) {
+		return false
+	}
+
+	if client.enqueue(message) {
+		return true
+	}
+
+	atomic.AddUint64(&h.slowDisconnects, 1)
+	h.logger.Warnf("Disconnecting slow WebSocket client %s on channel %s", client.identity.UserID, message.channel)
+	client.queue.close()
+	h.detach(client)
+	return false
+}
+
+// policyFor returns the backpressure policy configured for a channel
+func (h *Hub) policyFor(channel string) config.ChannelPolicy {
+	if policy, ok := h.config.ChannelPolicies[channel]; ok && policy.Policy != "" {
+		return policy
+	}
+	return config.ChannelPolicy{Policy: h.config.DefaultPolicy}
+}
+
+// newOutbound encodes a message and computes its conflation key
+func (h *Hub) newOutbound(messageType string, data interface{}) (*outbound, error) {
+	message := map[string]interface{}{
+		"type": messageType,
+		"data": data,
+	}
+
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &outbound{channel: messageType, data: jsonData}
+	if policy := h.policyFor(messageType); policy.Policy == PolicyConflate && policy.ConflateKey != "" {
+		msg.key = conflationKey(data, policy.ConflateKey)
+	}
+	return msg, nil
+}
+
+// conflationKey extracts the value of field from a message payload
+func conflationKey(data interface{}, field string) string {
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		raw, err := json.Marshal(data)
+		if err != nil || json.Unmarshal(raw, &fields) != nil {
+			return ""
+		}
+	}
+
+	value, ok := fields[field]
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// HandleWebSocket handles websocket requests from the peer at ip on behalf of identity
+func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request, identity Identity, ip string) {
+	if identity.UserID == "" {
+		identity.UserID = "anonymous"
+	}
+
+	if rejection := h.admit(identity, ip); rejection != nil {
+		h.countRejection(rejection)
+		writeRejection(w, rejection)
+		return
+	}
+
+	// The subprotocol is chosen here rather than through upgrader.Subprotocols,
+	// which would pick by server preference instead of the client's order
+	var header http.Header
+	if protocol := selectSubprotocol(websocket.Subprotocols(r)); protocol != "" {
+		header = http.Header{"Sec-Websocket-Protocol": {protocol}}
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, header)
 	if err != nil {
 		h.logger.Errorf("WebSocket upgrade error: %v", err)
 		return
 	}
 
-	// Extract user ID from query parameters or JWT token
-	userID := r.URL.Query().Get("user_id")
-	if userID == "" {
-		userID = "anonymous"
+	if h.config.Compression.Enabled {
+		level := 1
+		if h.config.Compression.Level != nil {
+			level = *h.config.Compression.Level
+		}
+		if err := conn.SetCompressionLevel(level); err != nil {
+			h.logger.Warnf("Invalid WebSocket compression level %d: %v", level, err)
+		}
+	}
+
+	// Clients that do not negotiate a subprotocol get JSON lines
+	protocol := conn.Subprotocol()
+	if protocol == "" {
+		protocol = ProtocolJSON
 	}
 
+	backlog := h.backlogFor(identity)
 	client := &Client{
-		hub:    h,
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		userID: userID,
+		id:             newClientID(),
+		hub:            h,
+		conn:           conn,
+		queue:          newSendQueue(h.config.SendBufferSize + len(backlog)),
+		identity:       identity,
+		ip:             ip,
+		protocol:       protocol,
+		connectedAt:    time.Now().UTC(),
+		reauthed:       make(chan struct{}, 1),
+		inboundLimiter: newTokenBucket(h.config.Inbound.MessagesPerSecond, h.config.Inbound.Burst),
 	}
 
-	client.hub.register <- client
+	client.queueBacklog(backlog)
+
+	// Limits are checked again in case concurrent upgrades took the last slot
+	if rejection := h.addClient(client); rejection != nil {
+		h.countRejection(rejection)
+		closeRejected(conn, rejection)
+		return
+	}
 
 	// Allow collection of memory referenced by the caller by doing all work in new goroutines
 	go client.writePump()
 	go client.readPump()
+	go client.watchExpiry()
 }
 
 // BroadcastMessage broadcasts a message to all connected clients
 func (h *Hub) BroadcastMessage(messageType string, data interface{}) {
-	message := map[string]interface{}{
-		"type": messageType,
-		"data": data,
-	}
-
-	jsonData, err := json.Marshal(message)
+	message, err := h.newOutbound(messageType, data)
 	if err != nil {
 		h.logger.Errorf("Failed to marshal broadcast message: %v", err)
 		return
 	}
 
 	select {
-	case h.broadcast <- jsonData:
+	case h.broadcast <- message:
 	default:
 		h.logger.Warn("Broadcast channel is full, dropping message")
 	}
 }
 
-// BroadcastToUser broadcasts a message to a specific user
-func (h *Hub) BroadcastToUser(userID, messageType string, data interface{}) {
-	message := map[string]interface{}{
-		"type": messageType,
-		"data": data,
-	}
-
-	jsonData, err := json.Marshal(message)
+// BroadcastToUser broadcasts a message to a specific user and returns the
+// number of local clients it was queued for
+func (h *Hub) BroadcastToUser(userID, messageType string, data interface{}) int {
+	message, err := h.newOutbound(messageType, data)
 	if err != nil {
 		h.logger.Errorf("Failed to marshal user message: %v", err)
-		return
+		return 0
 	}
 
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	message.userID = userID
 
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.record(message)
+	delivered := 0
 	for client := range h.clients {
-		if client.userID == userID {
-			select {
-			case client.send <- jsonData:
-			default:
-				close(client.send)
-				delete(h.clients, client)
-			}
+		if h.deliver(client, message) {
+			delivered++
 		}
 	}
+	return delivered
 }
 
-// Close closes the hub and all client connections
+// Close closes the hub and any client connections left after Shutdown
 func (h *Hub) Close() {
 	h.mu.Lock()
-	defer h.mu.Unlock()
-
+	h.shuttingDown = true
 	for client := range h.clients {
-		client.conn.Close()
-		close(client.send)
-		delete(h.clients, client)
+		if client.conn != nil {
+			client.conn.Close()
+		}
+		client.queue.close()
+		h.detach(client)
 	}
 
-	close(h.broadcast)
-	close(h.register)
-	close(h.unregister)
+	close(h.done)
+	h.mu.Unlock()
+
+	// Tell the other replicas this one no longer has anyone connected
+	h.publishPresence()
 }
 
 // GetClientCount returns the number of connected clients
@@ -180,14 +400,141 @@ func (h *Hub) GetClientCount() int {
 	return len(h.clients)
 }
 
+// DisconnectClient closes the client with the given id, sending reason in
+// the close frame. It returns false if no such client is connected.
+func (h *Hub) DisconnectClient(id, reason string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		if client.id == id {
+			client.queue.closeWith(CloseAdminDisconnect, reason)
+			h.detach(client)
+			return true
+		}
+	}
+	return false
+}
+
+// DisconnectUser closes every client of a user and returns how many were closed
+func (h *Hub) DisconnectUser(userID, reason string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := 0
+	for client := range h.clients {
+		if client.identity.UserID == userID {
+			client.queue.closeWith(CloseAdminDisconnect, reason)
+			h.detach(client)
+			count++
+		}
+	}
+	return count
+}
+
+// DisconnectSession closes every client of a login session and returns how
+// many were closed
+func (h *Hub) DisconnectSession(sessionID, reason string) int {
+	if sessionID == "" {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := 0
+	for client := range h.clients {
+		if client.identity.SessionID == sessionID {
+			client.queue.closeWith(CloseSessionRevoked, reason)
+			h.detach(client)
+			count++
+		}
+	}
+	return count
+}
+
+// GetClientStats returns queue metrics for every connected client
+func (h *Hub) GetClientStats() []ClientStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	stats := make([]ClientStats, 0, len(h.clients))
+	for client := range h.clients {
+		stats = append(stats, client.stats())
+	}
+	return stats
+}
+
+// GetSlowDisconnectCount returns how many clients were disconnected for falling behind
+func (h *Hub) GetSlowDisconnectCount() uint64 {
+	return atomic.LoadUint64(&h.slowDisconnects)
+}
+
+// wants reports whether a message is addressed to the client and on one of
+// its channels. Messages for a user only go to clients that authenticated
+// as that user.
+func (c *Client) wants(message *outbound) bool {
+	if message.userID != "" && (!c.identity.Authenticated || message.userID != c.identity.UserID) {
+		return false
+	}
+	if message.channel == PresenceChannel && !c.identity.Authenticated {
+		return false
+	}
+	if c.channels != nil && !c.channels[message.channel] {
+		return false
+	}
+	return true
+}
+
+// enqueue adds a message to the client's queue using the channel's policy.
+// It returns false when the client should be disconnected.
+func (c *Client) enqueue(message *outbound) bool {
+	return c.queue.push(message, c.hub.policyFor(message.channel).Policy)
+}
+
+// stats returns a snapshot of the client's queue metrics
+func (c *Client) stats() ClientStats {
+	transport := TransportWebSocket
+	if c.conn == nil {
+		transport = TransportSSE
+	}
+
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	return ClientStats{
+		ID:            c.id,
+		ReplicaID:     c.hub.config.Presence.ReplicaID,
+		UserID:        c.identity.UserID,
+		Username:      c.identity.Username,
+		IP:            c.ip,
+		Transport:     transport,
+		ConnectedAt:   c.connectedAt,
+		Channels:      channels,
+		QueueDepth:    c.queue.depth(),
+		QueueCapacity: c.queue.capacity,
+		Sent:          atomic.LoadUint64(&c.queue.sent),
+		Dropped:       atomic.LoadUint64(&c.queue.dropped),
+		Conflated:     atomic.LoadUint64(&c.queue.conflated),
+	}
+}
+
 // readPump pumps messages from the websocket connection to the hub
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
-		c.conn.Close()
+		// writePump flushes queued replies and sends the close frame once
+		// the queue is closed, then closes the connection
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+			c.conn.Close()
+		}
 	}()
 
-	c.conn.SetReadLimit(512)
+	c.conn.SetReadLimit(c.hub.readLimit())
 	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -203,22 +550,8 @@ func (c *Client) readPump() {
 			break
 		}
 
-		// Handle incoming messages (ping, pong, etc.)
-		var msg map[string]interface{}
-		if err := json.Unmarshal(message, &msg); err == nil {
-			if msgType, ok := msg["type"].(string); ok && msgType == "ping" {
-				pong := map[string]interface{}{
-					"type": "pong",
-				}
-				if pongData, err := json.Marshal(pong); err == nil {
-					select {
-					case c.send <- pongData:
-					default:
-						close(c.send)
-						return
-					}
-				}
-			}
+		if !c.handleMessage(message) {
+			return
 		}
 	}
 }
@@ -229,33 +562,34 @@ func (c *Client) writePump() {
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		c.hub.writers.Done()
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+		case <-c.queue.notify:
+			messages := c.queue.drain()
+			if len(messages) == 0 {
+				continue
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.writeMessages(messages); err != nil {
 				return
 			}
-			w.Write(message)
 
-			// Add queued messages to the current websocket message
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
+		case <-c.queue.done:
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if messages := c.queue.drain(); len(messages) > 0 {
+				c.writeMessages(messages)
 			}
 
-			if err := w.Close(); err != nil {
-				return
+			message := []byte{}
+			if code, reason := c.queue.closeStatus(); code != 0 {
+				message = websocket.FormatCloseMessage(code, reason)
 			}
+			c.conn.WriteMessage(websocket.CloseMessage, message)
+			return
 
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
@@ -265,3 +599,10 @@ func (c *Client) writePump() {
 		}
 	}
 }
+
+// newClientID returns a random identifier for a client session
+func newClientID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
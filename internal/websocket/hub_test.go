@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/config"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// testHub is a running hub served over HTTP. Connections authenticate as
+// the user named in the user query parameter, with a token expiring after
+// the ttl query parameter if set, and come from the ip query parameter, or
+// 127.0.0.1.
+type testHub struct {
+	*Hub
+	server *httptest.Server
+}
+
+// newTestHub starts a hub with the default WebSocket configuration, adjusted
+// by configure when it is not nil
+func newTestHub(t *testing.T, configure func(cfg *config.WebSocketConfig)) *testHub {
+	t.Helper()
+
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		t.Fatalf("failed to load default config: %v", err)
+	}
+	ws := cfg.APIGatewayConfig.WebSocket
+	if configure != nil {
+		configure(&ws)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	hub := NewHub(ws, logrus.NewEntry(logger))
+	go hub.Run()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var identity Identity
+		if user := r.URL.Query().Get("user"); user != "" {
+			identity = Identity{UserID: user, Username: user, Authenticated: true}
+		}
+		if ttl, err := time.ParseDuration(r.URL.Query().Get("ttl")); err == nil {
+			identity.ExpiresAt = time.Now().Add(ttl)
+		}
+		ip := r.URL.Query().Get("ip")
+		if ip == "" {
+			ip = "127.0.0.1"
+		}
+		hub.HandleWebSocket(w, r, identity, ip)
+	}))
+	t.Cleanup(func() {
+		hub.Close()
+		server.Close()
+	})
+	return &testHub{Hub: hub, server: server}
+}
+
+// testConn is a client connection to a test hub. It keeps messages that
+// arrived in the same frame as one already read, so none are lost.
+type testConn struct {
+	*websocket.Conn
+	pending []map[string]interface{}
+}
+
+// dial connects to the hub with the given query string, failing the test
+// if the upgrade is refused
+func (th *testHub) dial(t *testing.T, query string, dialer *websocket.Dialer) *testConn {
+	t.Helper()
+
+	before := th.GetClientCount()
+	conn, _, err := th.tryDial(query, dialer)
+	if err != nil {
+		t.Fatalf("failed to dial ?%s: %v", query, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	waitFor(t, func() bool { return th.GetClientCount() > before })
+	return &testConn{Conn: conn}
+}
+
+// tryDial connects to the hub with the given query string
+func (th *testHub) tryDial(query string, dialer *websocket.Dialer) (*websocket.Conn, *http.Response, error) {
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	return dialer.Dial("ws"+strings.TrimPrefix(th.server.URL, "http")+"?"+query, nil)
+}
+
+// readType skips messages until one of the given type arrives and returns
+// it. JSON clients get queued messages joined by newlines.
+func (c *testConn) readType(t *testing.T, messageType string) map[string]interface{} {
+	t.Helper()
+
+	for {
+		if message := c.next(t); message["type"] == messageType {
+			return message
+		}
+	}
+}
+
+// next returns the next message the client received
+func (c *testConn) next(t *testing.T) map[string]interface{} {
+	t.Helper()
+
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(c.pending) == 0 {
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			var message map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &message); err != nil {
+				t.Fatalf("message %q is not JSON: %v", line, err)
+			}
+			c.pending = append(c.pending, message)
+		}
+	}
+
+	message := c.pending[0]
+	c.pending = c.pending[1:]
+	return message
+}
+
+// expectCloseCode reads until the connection is closed and checks the close
+// code, returning the close error
+func expectCloseCode(t *testing.T, conn *testConn, code int, timeout time.Duration) *websocket.CloseError {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		closeErr, ok := err.(*websocket.CloseError)
+		if !ok {
+			t.Fatalf("connection ended without a close frame: %v", err)
+		}
+		if closeErr.Code != code {
+			t.Errorf("got close code %d, want %d", closeErr.Code, code)
+		}
+		return closeErr
+	}
+}
+
+// waitFor fails the test if condition does not hold within a second
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClientWants(t *testing.T) {
+	alice := Identity{UserID: "u-alice", Authenticated: true}
+	spoofed := Identity{UserID: "u-alice"}
+
+	tests := []struct {
+		name     string
+		identity Identity
+		channels map[string]bool
+		channel  string
+		userID   string
+		want     bool
+	}{
+		{"public event to anonymous client", spoofed, nil, "market.data.live", "", true},
+		{"private event to its owner", alice, nil, "trades.filled", "u-alice", true},
+		{"private event to another user", Identity{UserID: "u-bob", Authenticated: true}, nil, "trades.filled", "u-alice", false},
+		{"private event to unauthenticated client claiming the owner's id", spoofed, nil, "trades.filled", "u-alice", false},
+		{"presence to anonymous client", spoofed, nil, PresenceChannel, "", false},
+		{"presence to authenticated client", alice, nil, PresenceChannel, "", true},
+		{"unsubscribed channel", alice, map[string]bool{"pnl.update": true}, "trades.filled", "u-alice", false},
+		{"subscribed channel", alice, map[string]bool{"trades.filled": true}, "trades.filled", "u-alice", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{identity: tt.identity, channels: tt.channels}
+			if got := client.wants(&outbound{channel: tt.channel, userID: tt.userID}); got != tt.want {
+				t.Errorf("wants() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Error codes sent in the data of error replies
+const (
+	ErrorRateLimited     = "rate_limited"
+	ErrorInvalidMessage  = "invalid_message"
+	ErrorMessageTooLarge = "message_too_large"
+	ErrorUnknownType     = "unknown_type"
+	ErrorInvalidPayload  = "invalid_payload"
+	ErrorUnauthorized    = "unauthorized"
+	ErrorForbidden       = "forbidden"
+	ErrorCommandFailed   = "command_failed"
+)
+
+// maxRequestIDLength bounds the client-chosen id echoed in replies
+const maxRequestIDLength = 128
+
+// CodedError is implemented by command errors that carry an error code
+type CodedError interface {
+	error
+	Code() string
+}
+
+// decodeInbound strictly decodes a client message envelope
+func decodeInbound(raw []byte) (inboundMessage, error) {
+	var msg inboundMessage
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&msg); err != nil {
+		return msg, fmt.Errorf("invalid message format: %w", err)
+	}
+	if decoder.More() {
+		return msg, errors.New("invalid message format: trailing data")
+	}
+
+	if msg.Type == "" {
+		return msg, errors.New("message type required")
+	}
+	if len(msg.ID) > maxRequestIDLength {
+		return msg, fmt.Errorf("message id longer than %d characters", maxRequestIDLength)
+	}
+	return msg, nil
+}
+
+// readLimit returns the largest message any type may send, used as the
+// connection-level read limit
+func (h *Hub) readLimit() int64 {
+	limit := h.config.Inbound.DefaultMaxBytes
+	for _, size := range h.config.Inbound.MaxBytesByType {
+		if size > limit {
+			limit = size
+		}
+	}
+	return int64(limit)
+}
+
+// readLimitFor returns the largest message allowed for a message type
+func (h *Hub) readLimitFor(messageType string) int {
+	if size, ok := h.config.Inbound.MaxBytesByType[messageType]; ok {
+		return size
+	}
+	return h.config.Inbound.DefaultMaxBytes
+}
+
+// isCommand reports whether a message type is handled by the command handler
+func (h *Hub) isCommand(messageType string) bool {
+	return h.commands[messageType]
+}
+
+// replyError sends a structured error reply
+func (c *Client) replyError(id, command, code, message string) bool {
+	data := map[string]interface{}{
+		"code":  code,
+		"error": message,
+	}
+	if command != "" {
+		data["command"] = command
+	}
+	return c.reply("error", id, data)
+}
+
+// violation replies with an error and counts it against the client. Clients
+// that exceed the allowed number of violations within the window are
+// disconnected with a policy violation close code.
+func (c *Client) violation(id, command, code, message string) bool {
+	inbound := c.hub.config.Inbound
+	now := time.Now()
+
+	if now.Sub(c.violationWindowStart) > time.Duration(inbound.ViolationWindowSeconds)*time.Second {
+		c.violationWindowStart = now
+		c.violations = 0
+	}
+	c.violations++
+
+	if c.violations > inbound.MaxViolations {
+		c.hub.logger.Warnf("Disconnecting WebSocket client %s after %d protocol violations", c.identity.UserID, c.violations)
+		c.queue.closeWith(websocket.ClosePolicyViolation, "Too many invalid messages")
+		return false
+	}
+
+	return c.replyError(id, command, code, message)
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(1000, 3)
+	for i := 0; i < 3; i++ {
+		if !bucket.allow() {
+			t.Fatalf("message %d within the burst refused", i+1)
+		}
+	}
+	if bucket.allow() {
+		t.Fatal("message beyond the burst allowed")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if !bucket.allow() {
+		t.Error("message refused after the bucket refilled")
+	}
+}
+
+func TestDecodeInbound(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr string
+	}{
+		{"valid", `{"type":"ping","id":"1"}`, ""},
+		{"not JSON", `ping`, "invalid message format"},
+		{"unknown field", `{"type":"ping","extra":true}`, "invalid message format"},
+		{"trailing data", `{"type":"ping"}{"type":"ping"}`, "trailing data"},
+		{"missing type", `{"id":"1"}`, "type required"},
+		{"long id", `{"type":"ping","id":"` + strings.Repeat("x", maxRequestIDLength+1) + `"}`, "message id longer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeInbound([]byte(tt.raw))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("decodeInbound() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("decodeInbound() error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInboundRateLimitReplies(t *testing.T) {
+	th := newTestHub(t, func(cfg *config.WebSocketConfig) {
+		cfg.Inbound.MessagesPerSecond = 0.001
+		cfg.Inbound.Burst = 1
+		cfg.Inbound.MaxViolations = 5
+	})
+	conn := th.dial(t, "", nil)
+
+	for _, id := range []string{"1", "2"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping","id":"`+id+`"}`)); err != nil {
+			t.Fatalf("send ping %s: %v", id, err)
+		}
+	}
+	if pong := conn.readType(t, "pong"); pong["id"] != "1" {
+		t.Errorf("pong = %v, want a reply to 1", pong)
+	}
+	reply := conn.readType(t, "error")
+	if data, _ := reply["data"].(map[string]interface{}); data["code"] != ErrorRateLimited {
+		t.Errorf("reply = %v, want %s", reply, ErrorRateLimited)
+	}
+}
+
+func TestOversizedMessageIsRejected(t *testing.T) {
+	th := newTestHub(t, func(cfg *config.WebSocketConfig) {
+		cfg.Inbound.DefaultMaxBytes = 64
+		cfg.Inbound.MaxBytesByType = map[string]int{"presence": 1024}
+	})
+	conn := th.dial(t, "", nil)
+
+	padding := strings.Repeat("x", 100)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping","id":"`+padding+`"}`)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	reply := conn.readType(t, "error")
+	if data, _ := reply["data"].(map[string]interface{}); data["code"] != ErrorMessageTooLarge || data["command"] != "ping" {
+		t.Errorf("reply = %v, want %s for ping", reply, ErrorMessageTooLarge)
+	}
+}
+
+func TestRepeatedViolationsDisconnect(t *testing.T) {
+	th := newTestHub(t, func(cfg *config.WebSocketConfig) {
+		cfg.Inbound.MaxViolations = 2
+		cfg.Inbound.ViolationWindowSeconds = 60
+	})
+	conn := th.dial(t, "", nil)
+
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`not json`)); err != nil {
+			t.Fatalf("send invalid message %d: %v", i+1, err)
+		}
+	}
+
+	// The first two violations are answered, the third closes the connection
+	for i := 0; i < 2; i++ {
+		if reply := conn.readType(t, "error"); reply["data"].(map[string]interface{})["code"] != ErrorInvalidMessage {
+			t.Errorf("reply %d = %v, want %s", i+1, reply, ErrorInvalidMessage)
+		}
+	}
+	expectCloseCode(t, conn, websocket.ClosePolicyViolation, time.Second)
+	waitFor(t, func() bool { return th.GetClientCount() == 0 })
+}
@@ -0,0 +1,172 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Reasons a connection can be rejected by admission control
+const (
+	RejectMaxConnections = "max_connections"
+	RejectMaxPerUser     = "max_per_user"
+	RejectMaxPerIP       = "max_per_ip"
+	RejectUpgradeRate    = "upgrade_rate"
+	RejectShuttingDown   = "shutting_down"
+)
+
+// RejectionError is returned when admission control refuses a connection
+type RejectionError struct {
+	Reason  string
+	Status  int
+	Message string
+}
+
+func (e *RejectionError) Error() string {
+	return e.Message
+}
+
+// tokenBucket is a simple rate limiter refilling at rate tokens per second
+// up to burst tokens
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// allow takes a token if one is available
+func (b *tokenBucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// checkLimits reports whether another connection for identity from ip would
+// exceed the configured caps. Anonymous clients are only limited per IP, and
+// nobody is admitted once shutdown has started. The caller must hold h.mu.
+func (h *Hub) checkLimits(identity Identity, ip string) *RejectionError {
+	if h.shuttingDown {
+		return &RejectionError{RejectShuttingDown, http.StatusServiceUnavailable, "Server is shutting down"}
+	}
+
+	limits := h.config.Limits
+
+	if limits.MaxConnections > 0 && len(h.clients) >= limits.MaxConnections {
+		return &RejectionError{RejectMaxConnections, http.StatusServiceUnavailable, "Too many connections"}
+	}
+
+	if limits.MaxPerUser == 0 && limits.MaxPerIP == 0 {
+		return nil
+	}
+
+	perUser, perIP := 0, 0
+	for client := range h.clients {
+		if identity.Authenticated && client.identity.UserID == identity.UserID {
+			perUser++
+		}
+		if client.ip == ip {
+			perIP++
+		}
+	}
+
+	if limits.MaxPerUser > 0 && perUser >= limits.MaxPerUser {
+		return &RejectionError{RejectMaxPerUser, http.StatusTooManyRequests, "Too many connections for user"}
+	}
+	if limits.MaxPerIP > 0 && perIP >= limits.MaxPerIP {
+		return &RejectionError{RejectMaxPerIP, http.StatusTooManyRequests, "Too many connections from address"}
+	}
+	return nil
+}
+
+// admit runs admission control before a connection is accepted
+func (h *Hub) admit(identity Identity, ip string) *RejectionError {
+	if h.upgradeLimiter != nil && !h.upgradeLimiter.allow() {
+		return &RejectionError{RejectUpgradeRate, http.StatusTooManyRequests, "Too many connection attempts"}
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.checkLimits(identity, ip)
+}
+
+// addClient registers a client if it is still within the connection caps
+func (h *Hub) addClient(client *Client) *RejectionError {
+	h.mu.Lock()
+	if err := h.checkLimits(client.identity, client.ip); err != nil {
+		h.mu.Unlock()
+		return err
+	}
+	h.attach(client)
+	h.writers.Add(1) // done by writePump, counted here so Shutdown cannot miss it
+	count := len(h.clients)
+	h.mu.Unlock()
+
+	h.logger.Infof("WebSocket client connected. Total clients: %d", count)
+	return nil
+}
+
+// countRejection records a rejected connection in the hub metrics
+func (h *Hub) countRejection(err *RejectionError) {
+	h.rejectionsMu.Lock()
+	h.rejections[err.Reason]++
+	h.rejectionsMu.Unlock()
+
+	h.logger.Warnf("Rejected connection: %s", err.Message)
+}
+
+// GetRejectionCounts returns the number of rejected connections by reason
+func (h *Hub) GetRejectionCounts() map[string]uint64 {
+	h.rejectionsMu.Lock()
+	defer h.rejectionsMu.Unlock()
+
+	counts := make(map[string]uint64, len(h.rejections))
+	for reason, count := range h.rejections {
+		counts[reason] = count
+	}
+	return counts
+}
+
+// writeRejection answers a refused upgrade with an HTTP error
+func writeRejection(w http.ResponseWriter, err *RejectionError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(err.Status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Message})
+}
+
+// closeRejected closes an upgraded connection that lost the race for a slot
+func closeRejected(conn *websocket.Conn, err *RejectionError) {
+	message := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Message)
+	conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	conn.Close()
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"net/http"
+	"testing"
+
+	"cryptobot-api-gateway/internal/config"
+)
+
+func TestAdmissionLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		limits     config.ConnectionLimits
+		open       []string
+		next       string
+		wantStatus int
+		wantReason string
+	}{
+		{"per-user cap", config.ConnectionLimits{MaxPerUser: 2},
+			[]string{"user=u-alice&ip=10.0.0.1", "user=u-alice&ip=10.0.0.2"},
+			"user=u-alice&ip=10.0.0.3", http.StatusTooManyRequests, RejectMaxPerUser},
+		{"per-user cap leaves other users alone", config.ConnectionLimits{MaxPerUser: 2},
+			[]string{"user=u-alice", "user=u-alice"},
+			"user=u-bob", http.StatusSwitchingProtocols, ""},
+		{"anonymous clients are not capped per user", config.ConnectionLimits{MaxPerUser: 1},
+			[]string{"ip=10.0.0.1"},
+			"ip=10.0.0.2", http.StatusSwitchingProtocols, ""},
+		{"per-IP cap", config.ConnectionLimits{MaxPerIP: 2},
+			[]string{"user=u-alice&ip=10.0.0.1", "ip=10.0.0.1"},
+			"user=u-bob&ip=10.0.0.1", http.StatusTooManyRequests, RejectMaxPerIP},
+		{"per-IP cap leaves other addresses alone", config.ConnectionLimits{MaxPerIP: 1},
+			[]string{"ip=10.0.0.1"},
+			"ip=10.0.0.2", http.StatusSwitchingProtocols, ""},
+		{"total cap", config.ConnectionLimits{MaxConnections: 1},
+			[]string{"user=u-alice"},
+			"user=u-bob&ip=10.0.0.2", http.StatusServiceUnavailable, RejectMaxConnections},
+		{"upgrade rate", config.ConnectionLimits{UpgradesPerSecond: 0.001, UpgradeBurst: 1},
+			[]string{"user=u-alice"},
+			"user=u-bob", http.StatusTooManyRequests, RejectUpgradeRate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newTestHub(t, func(cfg *config.WebSocketConfig) {
+				cfg.Limits = tt.limits
+			})
+			for _, query := range tt.open {
+				th.dial(t, query, nil)
+			}
+
+			conn, resp, err := th.tryDial(tt.next, nil)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("dial failed without a response: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("upgrade = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantReason == "" {
+				return
+			}
+
+			if retry := resp.Header.Get("Retry-After"); retry == "" {
+				t.Error("rejection has no Retry-After header")
+			}
+			if counts := th.GetRejectionCounts(); counts[tt.wantReason] != 1 {
+				t.Errorf("rejection counts = %v, want one %s", counts, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestStreamSubscribersShareAdmissionLimits(t *testing.T) {
+	th := newTestHub(t, func(cfg *config.WebSocketConfig) {
+		cfg.Limits = config.ConnectionLimits{MaxPerUser: 1}
+	})
+	th.dial(t, "user=u-alice", nil)
+
+	alice := Identity{UserID: "u-alice", Authenticated: true}
+	_, err := th.Subscribe(alice, "10.0.0.2", nil, 0)
+	rejection, ok := err.(*RejectionError)
+	if !ok || rejection.Reason != RejectMaxPerUser {
+		t.Fatalf("Subscribe() error = %v, want a %s rejection", err, RejectMaxPerUser)
+	}
+
+	sub, err := th.Subscribe(Identity{UserID: "u-bob", Authenticated: true}, "10.0.0.2", nil, 0)
+	if err != nil {
+		t.Fatalf("Subscribe() for another user: %v", err)
+	}
+	sub.Close()
+}
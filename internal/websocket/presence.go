@@ -0,0 +1,395 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PresenceChannel is the hub channel carrying presence events
+const PresenceChannel = "presence"
+
+// Presence event types
+const (
+	PresenceOnline  = "presence.online"
+	PresenceOffline = "presence.offline"
+	PresenceUpdated = "presence.updated"
+)
+
+// UserPresence is the presence of one user aggregated over all of their
+// connections. Dashboards lists the bot ids the user currently has open.
+type UserPresence struct {
+	UserID      string   `json:"userId"`
+	Username    string   `json:"username,omitempty"`
+	Connections int      `json:"connections"`
+	Dashboards  []string `json:"dashboards"`
+}
+
+// PresenceSnapshot is the local presence of one gateway replica. Replicas
+// exchange snapshots so presence is aggregated across the cluster.
+type PresenceSnapshot struct {
+	ReplicaID string         `json:"replicaId"`
+	Users     []UserPresence `json:"users"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// PresencePublisher sends this replica's snapshot to the other replicas
+type PresencePublisher func(snapshot PresenceSnapshot) error
+
+// presenceRequest is the payload of a presence message from a client
+type presenceRequest struct {
+	Dashboards []string `json:"dashboards"`
+}
+
+// connectionPresence is the presence of a single local connection
+type connectionPresence struct {
+	username   string
+	dashboards []string
+}
+
+// remotePresence is the last snapshot received from another replica
+type remotePresence struct {
+	users      []UserPresence
+	receivedAt time.Time
+}
+
+// presenceTracker aggregates presence over local connections and the
+// snapshots of other replicas
+type presenceTracker struct {
+	mu        sync.Mutex
+	replicaID string
+	local     map[string]map[string]connectionPresence // userID -> client id -> presence
+	remote    map[string]remotePresence                // replica id -> snapshot
+	changed   chan struct{}
+}
+
+// newPresenceTracker creates an empty tracker for a replica
+func newPresenceTracker(replicaID string) *presenceTracker {
+	return &presenceTracker{
+		replicaID: replicaID,
+		local:     make(map[string]map[string]connectionPresence),
+		remote:    make(map[string]remotePresence),
+		changed:   make(chan struct{}, 1),
+	}
+}
+
+// presenceEvent is a change in a user's aggregated presence
+type presenceEvent struct {
+	messageType string
+	presence    UserPresence
+}
+
+// update applies a mutation and returns the resulting presence events
+func (t *presenceTracker) update(localChange bool, mutate func()) []presenceEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	before := t.aggregate()
+	mutate()
+	after := t.aggregate()
+
+	if localChange {
+		select {
+		case t.changed <- struct{}{}:
+		default:
+		}
+	}
+
+	var events []presenceEvent
+	for userID, presence := range after {
+		previous, ok := before[userID]
+		switch {
+		case !ok:
+			events = append(events, presenceEvent{PresenceOnline, presence})
+		case !equalStrings(previous.Dashboards, presence.Dashboards):
+			events = append(events, presenceEvent{PresenceUpdated, presence})
+		}
+	}
+	for userID, presence := range before {
+		if _, ok := after[userID]; !ok {
+			presence.Connections = 0
+			presence.Dashboards = []string{}
+			events = append(events, presenceEvent{PresenceOffline, presence})
+		}
+	}
+	return events
+}
+
+// join records a new local connection
+func (t *presenceTracker) join(userID, clientID, username string) []presenceEvent {
+	return t.update(true, func() {
+		if t.local[userID] == nil {
+			t.local[userID] = make(map[string]connectionPresence)
+		}
+		t.local[userID][clientID] = connectionPresence{username: username}
+	})
+}
+
+// leave removes a local connection
+func (t *presenceTracker) leave(userID, clientID string) []presenceEvent {
+	return t.update(true, func() {
+		delete(t.local[userID], clientID)
+		if len(t.local[userID]) == 0 {
+			delete(t.local, userID)
+		}
+	})
+}
+
+// setDashboards records the dashboards open on a local connection
+func (t *presenceTracker) setDashboards(userID, clientID string, dashboards []string) []presenceEvent {
+	return t.update(true, func() {
+		if conn, ok := t.local[userID][clientID]; ok {
+			conn.dashboards = dashboards
+			t.local[userID][clientID] = conn
+		}
+	})
+}
+
+// applyRemote stores the snapshot of another replica
+func (t *presenceTracker) applyRemote(snapshot PresenceSnapshot) []presenceEvent {
+	if snapshot.ReplicaID == t.replicaID {
+		return nil
+	}
+	return t.update(false, func() {
+		t.remote[snapshot.ReplicaID] = remotePresence{users: snapshot.Users, receivedAt: time.Now()}
+	})
+}
+
+// expireRemote forgets replicas that have not sent a snapshot since cutoff
+func (t *presenceTracker) expireRemote(cutoff time.Time) []presenceEvent {
+	return t.update(false, func() {
+		for replicaID, remote := range t.remote {
+			if remote.receivedAt.Before(cutoff) {
+				delete(t.remote, replicaID)
+			}
+		}
+	})
+}
+
+// online reports whether a user has a connection on any replica
+func (t *presenceTracker) online(userID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.local[userID]) > 0 {
+		return true
+	}
+	for _, remote := range t.remote {
+		for _, presence := range remote.users {
+			if presence.UserID == userID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// replicas returns the ids of the other replicas still reporting presence
+func (t *presenceTracker) replicas() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ids := make([]string, 0, len(t.remote))
+	for replicaID := range t.remote {
+		ids = append(ids, replicaID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// localSnapshot returns this replica's presence for publishing
+func (t *presenceTracker) localSnapshot() PresenceSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	users := make([]UserPresence, 0, len(t.local))
+	for userID, conns := range t.local {
+		users = append(users, summarize(userID, conns))
+	}
+	return PresenceSnapshot{ReplicaID: t.replicaID, Users: users, Timestamp: time.Now().UTC()}
+}
+
+// snapshot returns the aggregated presence of every online user
+func (t *presenceTracker) snapshot() []UserPresence {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	aggregated := t.aggregate()
+	users := make([]UserPresence, 0, len(aggregated))
+	for _, presence := range aggregated {
+		users = append(users, presence)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserID < users[j].UserID })
+	return users
+}
+
+// aggregate merges local and remote presence. The caller must hold t.mu.
+func (t *presenceTracker) aggregate() map[string]UserPresence {
+	merged := make(map[string]UserPresence)
+	add := func(presence UserPresence) {
+		existing, ok := merged[presence.UserID]
+		if !ok {
+			merged[presence.UserID] = presence
+			return
+		}
+		existing.Connections += presence.Connections
+		existing.Dashboards = unionStrings(existing.Dashboards, presence.Dashboards)
+		if existing.Username == "" {
+			existing.Username = presence.Username
+		}
+		merged[presence.UserID] = existing
+	}
+
+	for userID, conns := range t.local {
+		add(summarize(userID, conns))
+	}
+	for _, remote := range t.remote {
+		for _, presence := range remote.users {
+			add(presence)
+		}
+	}
+	return merged
+}
+
+// summarize combines the local connections of a user
+func summarize(userID string, conns map[string]connectionPresence) UserPresence {
+	presence := UserPresence{UserID: userID, Connections: len(conns), Dashboards: []string{}}
+	for _, conn := range conns {
+		if presence.Username == "" {
+			presence.Username = conn.username
+		}
+		presence.Dashboards = unionStrings(presence.Dashboards, conn.dashboards)
+	}
+	return presence
+}
+
+// unionStrings returns the sorted union of two string sets
+func unionStrings(a, b []string) []string {
+	set := make(map[string]bool, len(a)+len(b))
+	for _, s := range a {
+		set[s] = true
+	}
+	for _, s := range b {
+		set[s] = true
+	}
+
+	result := make([]string, 0, len(set))
+	for s := range set {
+		result = append(result, s)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// equalStrings reports whether two sorted string slices are equal
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetPresencePublisher registers the function that shares this replica's
+// presence with the rest of the cluster
+func (h *Hub) SetPresencePublisher(publisher PresencePublisher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.presencePublisher = publisher
+}
+
+// publishPresence sends the local snapshot through the registered publisher
+func (h *Hub) publishPresence() {
+	h.mu.RLock()
+	publisher := h.presencePublisher
+	h.mu.RUnlock()
+
+	if publisher == nil {
+		return
+	}
+	if err := publisher(h.presence.localSnapshot()); err != nil {
+		h.logger.Warnf("Failed to publish presence: %v", err)
+	}
+}
+
+// ApplyRemotePresence merges a snapshot received from another replica
+func (h *Hub) ApplyRemotePresence(snapshot PresenceSnapshot) {
+	h.emitPresence(h.presence.applyRemote(snapshot))
+}
+
+// GetPresence returns the aggregated presence of every online user
+func (h *Hub) GetPresence() []UserPresence {
+	return h.presence.snapshot()
+}
+
+// trackJoin records presence for a newly added authenticated client
+func (h *Hub) trackJoin(client *Client) {
+	if client.identity.Authenticated {
+		h.emitPresence(h.presence.join(client.identity.UserID, client.id, client.identity.Username))
+	}
+}
+
+// trackLeave records that an authenticated client went away
+func (h *Hub) trackLeave(client *Client) {
+	if client.identity.Authenticated {
+		h.emitPresence(h.presence.leave(client.identity.UserID, client.id))
+	}
+}
+
+// emitPresence queues presence events for delivery on the presence channel
+func (h *Hub) emitPresence(events []presenceEvent) {
+	for _, event := range events {
+		message, err := h.newOutbound(event.messageType, event.presence)
+		if err != nil {
+			h.logger.Errorf("Failed to marshal presence event: %v", err)
+			continue
+		}
+		message.channel = PresenceChannel
+
+		select {
+		case h.events <- message:
+		default:
+			h.logger.Warn("Hub event queue is full, dropping presence event")
+		}
+	}
+}
+
+// runPresence publishes local presence changes and heartbeats to the other
+// replicas and expires replicas that stopped reporting
+func (h *Hub) runPresence() {
+	interval := time.Duration(h.config.Presence.HeartbeatSeconds) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.presence.changed:
+		case <-ticker.C:
+			h.emitPresence(h.presence.expireRemote(time.Now().Add(-3 * interval)))
+		case <-h.done:
+			return
+		}
+
+		h.publishPresence()
+	}
+}
+
+// handlePresence records the dashboards a client has open
+func (c *Client) handlePresence(msg inboundMessage) bool {
+	var request presenceRequest
+	if err := json.Unmarshal(msg.Data, &request); err != nil {
+		return c.replyError(msg.ID, "presence", ErrorInvalidPayload, "Invalid presence payload")
+	}
+	if !c.identity.Authenticated {
+		return c.replyError(msg.ID, "presence", ErrorUnauthorized, "Authentication required")
+	}
+
+	dashboards := unionStrings(request.Dashboards, nil)
+	c.hub.emitPresence(c.hub.presence.setDashboards(c.identity.UserID, c.id, dashboards))
+	return c.reply("ack", msg.ID, map[string]interface{}{"command": "presence", "dashboards": dashboards})
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// newReplicaTestHubs starts two hubs that share presence and admin requests
+// with each other as replicas connected through a broker do
+func newReplicaTestHubs(t *testing.T) (*testHub, *testHub) {
+	t.Helper()
+
+	replica := func(id string) *testHub {
+		return newTestHub(t, func(cfg *config.WebSocketConfig) {
+			cfg.Presence = config.PresenceConfig{ReplicaID: id, HeartbeatSeconds: 1}
+		})
+	}
+	a, b := replica("a"), replica("b")
+	a.SetPresencePublisher(func(snapshot PresenceSnapshot) error {
+		b.ApplyRemotePresence(snapshot)
+		return nil
+	})
+	b.SetPresencePublisher(func(snapshot PresenceSnapshot) error {
+		a.ApplyRemotePresence(snapshot)
+		return nil
+	})
+	a.SetAdminPublisher(func(message AdminMessage) error {
+		b.ApplyAdminMessage(message)
+		return nil
+	})
+	b.SetAdminPublisher(func(message AdminMessage) error {
+		a.ApplyAdminMessage(message)
+		return nil
+	})
+	return a, b
+}
+
+// openDashboards reports the dashboards a client has open
+func openDashboards(t *testing.T, conn *testConn, dashboards string) {
+	t.Helper()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"presence","id":"p","data":{"dashboards":`+dashboards+`}}`)); err != nil {
+		t.Fatalf("send presence: %v", err)
+	}
+	conn.readType(t, "ack")
+}
+
+// presenceOf returns a user's aggregated presence on a hub
+func presenceOf(hub *testHub, userID string) (UserPresence, bool) {
+	for _, presence := range hub.GetPresence() {
+		if presence.UserID == userID {
+			return presence, true
+		}
+	}
+	return UserPresence{}, false
+}
+
+func TestPresenceAggregatesAcrossReplicas(t *testing.T) {
+	a, b := newReplicaTestHubs(t)
+
+	openDashboards(t, a.dial(t, "user=u-alice", nil), `["bot-1"]`)
+	openDashboards(t, b.dial(t, "user=u-alice", nil), `["bot-2","bot-1"]`)
+	b.dial(t, "user=u-bob", nil)
+
+	want := map[string]UserPresence{
+		"u-alice": {UserID: "u-alice", Username: "u-alice", Connections: 2, Dashboards: []string{"bot-1", "bot-2"}},
+		"u-bob":   {UserID: "u-bob", Username: "u-bob", Connections: 1, Dashboards: []string{}},
+	}
+	for name, hub := range map[string]*testHub{"a": a, "b": b} {
+		waitFor(t, func() bool {
+			for userID, presence := range want {
+				if got, _ := presenceOf(hub, userID); !reflect.DeepEqual(got, presence) {
+					return false
+				}
+			}
+			return true
+		})
+		if !hub.IsUserOnline("u-bob") {
+			t.Errorf("replica %s reports u-bob offline", name)
+		}
+	}
+}
+
+func TestPresenceOfRemoteUserReachesLocalClients(t *testing.T) {
+	a, b := newReplicaTestHubs(t)
+	watcher := a.dial(t, "user=u-alice", nil)
+	watcher.readType(t, PresenceOnline)
+
+	bob := b.dial(t, "user=u-bob", nil)
+	online := watcher.readType(t, PresenceOnline)
+	if data, _ := online["data"].(map[string]interface{}); data["userId"] != "u-bob" {
+		t.Fatalf("presence event = %v, want u-bob online", online)
+	}
+
+	bob.Close()
+	offline := watcher.readType(t, PresenceOffline)
+	if data, _ := offline["data"].(map[string]interface{}); data["userId"] != "u-bob" {
+		t.Errorf("presence event = %v, want u-bob offline", offline)
+	}
+}
+
+func TestPresenceExpiresSilentReplicas(t *testing.T) {
+	tracker := newPresenceTracker("a")
+	tracker.applyRemote(PresenceSnapshot{ReplicaID: "b", Users: []UserPresence{{UserID: "u-bob", Connections: 1}}})
+
+	if events := tracker.expireRemote(time.Now().Add(-time.Minute)); len(events) != 0 {
+		t.Errorf("expiring a replica that reported recently produced %v", events)
+	}
+	if !tracker.online("u-bob") {
+		t.Fatal("u-bob offline before the replica expired")
+	}
+
+	events := tracker.expireRemote(time.Now().Add(time.Second))
+	if len(events) != 1 || events[0].messageType != PresenceOffline || events[0].presence.UserID != "u-bob" {
+		t.Errorf("events = %v, want u-bob offline", events)
+	}
+	if tracker.online("u-bob") {
+		t.Error("u-bob still online after the replica expired")
+	}
+}
+
+func TestPresenceIgnoresOwnSnapshot(t *testing.T) {
+	tracker := newPresenceTracker("a")
+	tracker.applyRemote(PresenceSnapshot{ReplicaID: "a", Users: []UserPresence{{UserID: "u-bob", Connections: 1}}})
+	if tracker.online("u-bob") {
+		t.Error("replica counted its own echoed snapshot")
+	}
+}
@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Backpressure policies applied when a client's send queue is full
+const (
+	PolicyDisconnect = "disconnect"
+	PolicyDropOldest = "drop-oldest"
+	PolicyDropNewest = "drop-newest"
+	PolicyConflate   = "conflate"
+)
+
+// outbound is a message waiting to be written to a client
+type outbound struct {
+	id      uint64
+	channel string
+	userID  string
+	key     string
+	data    []byte
+
+	packOnce sync.Once
+	packed   []byte
+	packErr  error
+}
+
+// sendQueue is a bounded per-client outbound queue that applies a
+// backpressure policy instead of blocking the hub when the client is slow
+type sendQueue struct {
+	mu       sync.Mutex
+	items    []*outbound
+	capacity int
+	closed   bool
+	code     int
+	reason   string
+	notify   chan struct{}
+	done     chan struct{}
+
+	sent      uint64
+	dropped   uint64
+	conflated uint64
+}
+
+// newSendQueue creates a queue holding at most capacity messages
+func newSendQueue(capacity int) *sendQueue {
+	return &sendQueue{
+		items:    make([]*outbound, 0, capacity),
+		capacity: capacity,
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// push adds a message according to policy. It returns false when the
+// client should be disconnected.
+func (q *sendQueue) push(msg *outbound, policy string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+
+	if policy == PolicyConflate && msg.key != "" {
+		for i, item := range q.items {
+			if item.channel == msg.channel && item.key == msg.key {
+				q.items[i] = msg
+				atomic.AddUint64(&q.conflated, 1)
+				return true
+			}
+		}
+	}
+
+	if len(q.items) >= q.capacity {
+		switch policy {
+		case PolicyDropNewest:
+			atomic.AddUint64(&q.dropped, 1)
+			return true
+		case PolicyDropOldest, PolicyConflate:
+			q.items[0] = nil
+			q.items = q.items[1:]
+			atomic.AddUint64(&q.dropped, 1)
+		default:
+			return false
+		}
+	}
+
+	q.items = append(q.items, msg)
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// drain removes and returns all queued messages
+func (q *sendQueue) drain() []*outbound {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := q.items
+	q.items = make([]*outbound, 0, q.capacity)
+	atomic.AddUint64(&q.sent, uint64(len(items)))
+	return items
+}
+
+// depth returns the number of messages waiting to be written
+func (q *sendQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// close marks the queue closed and wakes the writer. It is safe to call
+// more than once.
+func (q *sendQueue) close() {
+	q.closeWith(0, "")
+}
+
+// closeWith closes the queue, recording the close code and reason the
+// writer should send. Only the first call has any effect.
+func (q *sendQueue) closeWith(code int, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.code = code
+	q.reason = TruncateCloseReason(reason)
+	close(q.done)
+}
+
+// MaxCloseReasonBytes is the longest reason that fits in a close frame,
+// whose payload is limited to 125 bytes including the 2-byte close code
+const MaxCloseReasonBytes = 123
+
+// TruncateCloseReason shortens reason to fit in a close frame without
+// splitting a UTF-8 sequence
+func TruncateCloseReason(reason string) string {
+	if len(reason) <= MaxCloseReasonBytes {
+		return reason
+	}
+
+	cut := MaxCloseReasonBytes
+	for cut > 0 && !utf8.RuneStart(reason[cut]) {
+		cut--
+	}
+	return reason[:cut]
+}
+
+// closeStatus returns the close code and reason recorded by closeWith
+func (q *sendQueue) closeStatus() (int, string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.code, q.reason
+}
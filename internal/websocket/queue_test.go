@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSendQueuePushAtCapacity(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        string
+		key           string
+		wantOK        bool
+		wantIDs       []uint64
+		wantDropped   uint64
+		wantConflated uint64
+	}{
+		{"drop-oldest", PolicyDropOldest, "", true, []uint64{2, 3}, 1, 0},
+		{"drop-newest", PolicyDropNewest, "", true, []uint64{1, 2}, 1, 0},
+		{"conflate replaces in place", PolicyConflate, "BTC", true, []uint64{3, 2}, 0, 1},
+		{"conflate with a new key drops oldest", PolicyConflate, "SOL", true, []uint64{2, 3}, 1, 0},
+		{"disconnect", PolicyDisconnect, "", false, []uint64{1, 2}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newSendQueue(2)
+			q.push(&outbound{id: 1, channel: "market.data.live", key: "BTC"}, tt.policy)
+			q.push(&outbound{id: 2, channel: "market.data.live", key: "ETH"}, tt.policy)
+
+			if ok := q.push(&outbound{id: 3, channel: "market.data.live", key: tt.key}, tt.policy); ok != tt.wantOK {
+				t.Errorf("push() = %v, want %v", ok, tt.wantOK)
+			}
+
+			var ids []uint64
+			for _, item := range q.drain() {
+				ids = append(ids, item.id)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("queued ids = %v, want %v", ids, tt.wantIDs)
+			}
+			if q.dropped != tt.wantDropped || q.conflated != tt.wantConflated {
+				t.Errorf("dropped %d, conflated %d; want %d, %d", q.dropped, q.conflated, tt.wantDropped, tt.wantConflated)
+			}
+		})
+	}
+}
+
+func TestSendQueueConflatesOnlySameChannel(t *testing.T) {
+	q := newSendQueue(4)
+	q.push(&outbound{id: 1, channel: "market.data.live", key: "BTC"}, PolicyConflate)
+	q.push(&outbound{id: 2, channel: "market.data.delayed", key: "BTC"}, PolicyConflate)
+
+	if depth := q.depth(); depth != 2 {
+		t.Errorf("depth = %d, want 2", depth)
+	}
+	if q.conflated != 0 {
+		t.Errorf("conflated %d across channels, want 0", q.conflated)
+	}
+}
+
+func TestSendQueueRefusesPushAfterClose(t *testing.T) {
+	q := newSendQueue(1)
+	q.close()
+	if q.push(&outbound{id: 1}, PolicyDropNewest) {
+		t.Error("push() to a closed queue = true, want false")
+	}
+}
+
+func TestTruncateCloseReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason string
+		want   string
+	}{
+		{"short", "Disconnected by administrator", "Disconnected by administrator"},
+		{"exactly the limit", strings.Repeat("a", MaxCloseReasonBytes), strings.Repeat("a", MaxCloseReasonBytes)},
+		{"ascii over the limit", strings.Repeat("a", 200), strings.Repeat("a", MaxCloseReasonBytes)},
+		// 41 three-byte runes fill the limit exactly, the 42nd must not be split
+		{"multibyte over the limit", strings.Repeat("€", 42), strings.Repeat("€", 41)},
+		{"multibyte straddling the limit", "a" + strings.Repeat("€", 41), "a" + strings.Repeat("€", 40)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TruncateCloseReason(tt.reason)
+			if got != tt.want {
+				t.Errorf("TruncateCloseReason() = %q, want %q", got, tt.want)
+			}
+			if len(got) > MaxCloseReasonBytes || !utf8.ValidString(got) {
+				t.Errorf("TruncateCloseReason() = %q is not a valid close reason", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+
+	"github.com/gorilla/websocket"
+)
+
+// ShutdownReason is the JSON close reason sent to clients when the gateway
+// shuts down. Clients should wait RetryAfterMs before reconnecting.
+type ShutdownReason struct {
+	Reason       string `json:"reason"`
+	RetryAfterMs int    `json:"retryAfterMs"`
+}
+
+// Shutdown stops admitting clients and closes every connected client with
+// 1001 Going Away and a randomized reconnect hint. It waits for queued
+// messages to be flushed until ctx is done, then closes the remaining
+// connections.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.shuttingDown = true
+
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		client.queue.closeWith(websocket.CloseGoingAway, h.shutdownReason())
+		h.detach(client)
+		clients = append(clients, client)
+	}
+	h.mu.Unlock()
+
+	h.logger.Infof("Draining %d WebSocket clients", len(clients))
+
+	drained := make(chan struct{})
+	go func() {
+		h.writers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		for _, client := range clients {
+			if client.conn != nil {
+				client.conn.Close()
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// IsShuttingDown reports whether Shutdown has been called
+func (h *Hub) IsShuttingDown() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.shuttingDown
+}
+
+// shutdownReason returns an encoded close reason with a random retry delay
+func (h *Hub) shutdownReason() string {
+	cfg := h.config.Shutdown
+	retryAfter := cfg.RetryAfterMinMs
+	if spread := cfg.RetryAfterMaxMs - cfg.RetryAfterMinMs; spread > 0 {
+		retryAfter += rand.Intn(spread + 1)
+	}
+
+	reason, _ := json.Marshal(ShutdownReason{Reason: "server_shutdown", RetryAfterMs: retryAfter})
+	return string(reason)
+}
@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"cryptobot-api-gateway/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// newShutdownTestHub starts a hub telling clients to wait 100-200ms before
+// reconnecting
+func newShutdownTestHub(t *testing.T) *testHub {
+	t.Helper()
+
+	return newTestHub(t, func(cfg *config.WebSocketConfig) {
+		cfg.Shutdown = config.ShutdownConfig{DrainTimeoutSeconds: 5, RetryAfterMinMs: 100, RetryAfterMaxMs: 200}
+	})
+}
+
+// checkRetryAfter checks that a shutdown reason asks for a delay in the
+// configured range
+func checkRetryAfter(t *testing.T, reason ShutdownReason) {
+	t.Helper()
+
+	if reason.Reason != "server_shutdown" || reason.RetryAfterMs < 100 || reason.RetryAfterMs > 200 {
+		t.Errorf("shutdown reason = %+v, want server_shutdown with a 100-200ms delay", reason)
+	}
+}
+
+func TestShutdownDrainsQueueThenSendsGoingAway(t *testing.T) {
+	th := newShutdownTestHub(t)
+	conn := th.dial(t, "user=u-alice", nil)
+
+	const queued = 20
+	for i := 0; i < queued; i++ {
+		th.BroadcastToUser("u-alice", "pnl.update", map[string]interface{}{"seq": i})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := th.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	// Every queued message arrives before the close frame
+	for i := 0; i < queued; i++ {
+		message := conn.readType(t, "pnl.update")
+		if data, _ := message["data"].(map[string]interface{}); data["seq"] != float64(i) {
+			t.Fatalf("message %d = %v, want seq %d", i, message, i)
+		}
+	}
+	closeErr := expectCloseCode(t, conn, websocket.CloseGoingAway, time.Second)
+
+	var reason ShutdownReason
+	if err := json.Unmarshal([]byte(closeErr.Text), &reason); err != nil {
+		t.Fatalf("close reason %q is not JSON: %v", closeErr.Text, err)
+	}
+	checkRetryAfter(t, reason)
+}
+
+func TestShutdownRefusesNewClients(t *testing.T) {
+	th := newShutdownTestHub(t)
+	if err := th.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	_, resp, err := th.tryDial("user=u-alice", nil)
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("dial after shutdown = %v, %v; want %d", resp, err, http.StatusServiceUnavailable)
+	}
+	if counts := th.GetRejectionCounts(); counts[RejectShuttingDown] != 1 {
+		t.Errorf("rejection counts = %v, want one %s", counts, RejectShuttingDown)
+	}
+	if !th.IsShuttingDown() {
+		t.Error("IsShuttingDown() = false after Shutdown")
+	}
+}
+
+func TestShutdownTellsStreamSubscribersWhenToReconnect(t *testing.T) {
+	th := newShutdownTestHub(t)
+	sub, err := th.Subscribe(Identity{UserID: "u-alice", Authenticated: true}, "127.0.0.1", []string{"pnl.update"}, 0)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	defer sub.Close()
+	th.BroadcastToUser("u-alice", "pnl.update", map[string]interface{}{"seq": 1})
+
+	if err := th.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		t.Fatal("subscriber not dropped on shutdown")
+	}
+	if events := sub.Events(); len(events) != 1 {
+		t.Errorf("got %d events left to flush, want 1", len(events))
+	}
+	reason, ok := sub.ShutdownReason()
+	if !ok {
+		t.Fatal("subscriber has no shutdown reason")
+	}
+	checkRetryAfter(t, reason)
+}
@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Event is a hub message delivered to a stream subscriber
+type Event struct {
+	ID      uint64
+	Channel string
+	Data    []byte
+}
+
+// Subscription receives hub messages without a WebSocket connection, for
+// example to serve Server-Sent Events. It shares the hub's channel
+// policies and user routing with WebSocket clients.
+type Subscription struct {
+	client *Client
+}
+
+// Subscribe registers a stream subscriber for identity connecting from ip.
+// A nil or empty channels list receives every channel. The backlog and then
+// messages recorded after lastEventID are sent before live delivery starts. Subscribers
+// are subject to the same connection limits as WebSocket clients.
+func (h *Hub) Subscribe(identity Identity, ip string, channels []string, lastEventID uint64) (*Subscription, error) {
+	if rejection := h.admit(identity, ip); rejection != nil {
+		h.countRejection(rejection)
+		return nil, rejection
+	}
+
+	backlog := h.backlogFor(identity)
+	client := &Client{
+		id:          newClientID(),
+		hub:         h,
+		queue:       newSendQueue(h.config.SendBufferSize + len(backlog)),
+		identity:    identity,
+		ip:          ip,
+		connectedAt: time.Now().UTC(),
+		reauthed:    make(chan struct{}, 1),
+	}
+	if len(channels) > 0 {
+		client.channels = make(map[string]bool, len(channels))
+		for _, channel := range channels {
+			client.channels[channel] = true
+		}
+	}
+
+	client.queueBacklog(backlog)
+
+	h.mu.Lock()
+	if rejection := h.checkLimits(identity, ip); rejection != nil {
+		h.mu.Unlock()
+		h.countRejection(rejection)
+		return nil, rejection
+	}
+
+	if lastEventID > 0 {
+		for _, message := range h.history {
+			if message.id > lastEventID {
+				h.deliver(client, message)
+			}
+		}
+	}
+	h.attach(client)
+	h.logger.Infof("Stream subscriber %s connected. Total clients: %d", identity.UserID, len(h.clients))
+	h.mu.Unlock()
+
+	// Stream subscribers cannot reauth in-band, so they are closed at expiry
+	go client.watchExpiry()
+
+	return &Subscription{client: client}, nil
+}
+
+// Ready is signalled when events are waiting to be read
+func (s *Subscription) Ready() <-chan struct{} {
+	return s.client.queue.notify
+}
+
+// Done is closed when the hub drops the subscriber
+func (s *Subscription) Done() <-chan struct{} {
+	return s.client.queue.done
+}
+
+// ShutdownReason returns the reconnect hint sent when the hub dropped the
+// subscriber because the gateway is shutting down
+func (s *Subscription) ShutdownReason() (ShutdownReason, bool) {
+	var reason ShutdownReason
+	code, text := s.client.queue.closeStatus()
+	if code != websocket.CloseGoingAway || json.Unmarshal([]byte(text), &reason) != nil {
+		return reason, false
+	}
+	return reason, true
+}
+
+// Events returns and removes the queued events
+func (s *Subscription) Events() []Event {
+	messages := s.client.queue.drain()
+	events := make([]Event, len(messages))
+	for i, message := range messages {
+		events[i] = Event{ID: message.id, Channel: message.channel, Data: message.data}
+	}
+	return events
+}
+
+// Close removes the subscriber from the hub
+func (s *Subscription) Close() {
+	h := s.client.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.detach(s.client) {
+		h.logger.Infof("Stream subscriber %s disconnected. Total clients: %d", s.client.identity.UserID, len(h.clients))
+	}
+	s.client.queue.close()
+}